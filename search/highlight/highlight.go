@@ -0,0 +1,479 @@
+// Package highlight finds the fragments of a stored field that best match a
+// query, with the matched terms wrapped in tags.
+package highlight
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/larose/lynx/search/index"
+	"github.com/larose/lynx/search/query"
+)
+
+// Options of Highlight. Zero fields take their default value.
+type Options struct {
+	// Tags around the matched terms. Defaults to <em> and </em>.
+	PreTag  string
+	PostTag string
+	// Size of the fragments in bytes. Fragments are cut between words, so
+	// they can be a bit shorter, or longer when a word doesn't fit. Defaults
+	// to 100.
+	FragmentSize int
+	// Maximum number of fragments. Defaults to 3.
+	NumFragments int
+	// Encodes the text of the fragments, but not the tags, e.g.
+	// html.EscapeString. The text is not encoded when nil.
+	Encode func(string) string
+}
+
+func (o *Options) withDefaults() Options {
+	options := Options{}
+	if o != nil {
+		options = *o
+	}
+
+	if options.PreTag == "" && options.PostTag == "" {
+		options.PreTag, options.PostTag = "<em>", "</em>"
+	}
+
+	if options.FragmentSize <= 0 {
+		options.FragmentSize = 100
+	}
+
+	if options.NumFragments <= 0 {
+		options.NumFragments = 3
+	}
+
+	if options.Encode == nil {
+		options.Encode = func(s string) string { return s }
+	}
+
+	return options
+}
+
+// Fragment is a part of the stored value of a field
+type Fragment struct {
+	// Text of the fragment with the matched terms wrapped in the tags
+	Text string
+	// Sum of the weights of the distinct terms matched by the fragment
+	Score float32
+	// Byte offsets of the fragment in the stored value
+	Start int
+	End   int
+}
+
+// Highlight returns the fragments of the stored value of the field of the doc
+// that best match the query, best first. Fragments without matches are not
+// returned.
+//
+// The matches are read from the offsets file of the field when the segment of
+// the doc has one, see Schema.SetFieldOffsets. Otherwise the value is analyzed
+// again with the analyzer saved for the field by the segments. Fragments
+// are split between words, as by the standard tokenizer. The terms of phrases
+// are matched on their own, and the terms of the MustNot clauses are not
+// matched.
+func Highlight(indexReader *index.IndexReader, node query.Node, docId uint64, fieldName string, options *Options) ([]*Fragment, error) {
+	_options := options.withDefaults()
+	analyzer, err := indexReader.Schema.Analyzer(fieldName)
+	if err != nil {
+		return nil, err
+	}
+
+	queryTerms := newQueryTerms()
+	queryTerms.add(node, fieldName, analyzer, 1)
+
+	if queryTerms.empty() {
+		return nil, nil
+	}
+
+	if err := queryTerms.weightByIdf(indexReader, fieldName); err != nil {
+		return nil, err
+	}
+
+	value, err := indexReader.Value(fieldName, docId)
+	if err != nil {
+		return nil, err
+	}
+
+	matches, err := findMatches(indexReader, queryTerms, docId, fieldName, analyzer, value)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(matches) == 0 {
+		return nil, nil
+	}
+
+	fragments := make([]*Fragment, 0)
+	for _, span := range splitFragments(value, splitWords(value), _options.FragmentSize) {
+		fragment := newFragment(value, span, matches, &_options)
+		if fragment != nil {
+			fragments = append(fragments, fragment)
+		}
+	}
+
+	slices.SortStableFunc(fragments, func(a, b *Fragment) int {
+		return cmp.Compare(b.Score, a.Score)
+	})
+
+	return fragments[:min(len(fragments), _options.NumFragments)], nil
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// Query terms
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+type prefixTerm struct {
+	prefix []byte
+	weight float32
+}
+
+// queryTerms are the terms of a query in a field, with their weight
+type queryTerms struct {
+	terms    map[string]float32
+	prefixes []*prefixTerm
+}
+
+func newQueryTerms() *queryTerms {
+	return &queryTerms{terms: make(map[string]float32)}
+}
+
+func (q *queryTerms) empty() bool {
+	return len(q.terms) == 0 && len(q.prefixes) == 0
+}
+
+func (q *queryTerms) addTerm(term []byte, boost float32) {
+	q.terms[string(term)] = max(q.terms[string(term)], boost)
+}
+
+func (q *queryTerms) add(node query.Node, fieldName string, analyzer index.Analyzer, boost float32) {
+	switch n := node.(type) {
+	case *query.BooleanNode:
+		for _, clause := range n.Clauses {
+			if clause.Type != query.MustNot {
+				q.add(clause.Node, fieldName, analyzer, boost)
+			}
+		}
+	case *query.BoostNode:
+		q.add(n.Node, fieldName, analyzer, boost*n.Boost)
+	case *query.MatchNode:
+		if n.FieldName == fieldName {
+			for _, term := range index.Analyze(analyzer, []byte(n.Text)) {
+				q.addTerm(term, boost)
+			}
+		}
+	case *query.MatchPhraseNode:
+		if n.FieldName == fieldName {
+			for _, term := range index.Analyze(analyzer, []byte(n.Text)) {
+				q.addTerm(term, boost)
+			}
+		}
+	case *query.PhraseNode:
+		if n.FieldName == fieldName {
+			for _, term := range n.Terms {
+				q.addTerm(term, boost)
+			}
+		}
+	case *query.PrefixNode:
+		if n.FieldName == fieldName {
+			q.prefixes = append(q.prefixes, &prefixTerm{prefix: n.Prefix, weight: boost})
+		}
+	case *query.TermNode:
+		if n.FieldName == fieldName {
+			q.addTerm(n.Term, boost)
+		}
+	}
+}
+
+// Multiplies the weights of the terms by their idf, as in the scores of the
+// search. Prefixes match many terms and keep their weight.
+func (q *queryTerms) weightByIdf(indexReader *index.IndexReader, fieldName string) error {
+	docCount := uint32(0)
+	docFreqs := make(map[string]uint32, len(q.terms))
+
+	for _, segmentReader := range indexReader.SegmentReaders {
+		if !segmentReader.Info.HasField(fieldName) {
+			continue
+		}
+
+		segmentDocCount, _, err := segmentReader.DocCountAndSumTermFreqForField(fieldName)
+		if err != nil {
+			return err
+		}
+
+		docCount += segmentDocCount
+
+		dictionaryReader, err := segmentReader.DictionaryReader(fieldName)
+		if err != nil {
+			return err
+		}
+
+		for term := range q.terms {
+			if termInfo := dictionaryReader.Get([]byte(term)); termInfo != nil {
+				docFreqs[term] += termInfo.DocFreq
+			}
+		}
+	}
+
+	for term, weight := range q.terms {
+		docFreq := float64(docFreqs[term])
+		idf := math.Log(1 + (float64(docCount)-docFreq+0.5)/(docFreq+0.5))
+		q.terms[term] = weight * float32(idf)
+	}
+
+	return nil
+}
+
+// Returns the weight of the term, or false if the query doesn't match it
+func (q *queryTerms) weight(term []byte) (float32, bool) {
+	weight, ok := q.terms[string(term)]
+
+	for _, prefix := range q.prefixes {
+		if bytes.HasPrefix(term, prefix.prefix) {
+			weight = max(weight, prefix.weight)
+			ok = true
+		}
+	}
+
+	return weight, ok
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// Matches
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+// span is a range of bytes of the value
+type span struct {
+	start int
+	end   int
+}
+
+type match struct {
+	span
+	term   string
+	weight float32
+}
+
+// Returns the matches of the query terms in the value, in order and without
+// overlaps
+func findMatches(indexReader *index.IndexReader, queryTerms *queryTerms, docId uint64, fieldName string, analyzer index.Analyzer, value []byte) ([]*match, error) {
+	segmentId := index.ToSegmentId(docId)
+
+	for _, segmentReader := range indexReader.SegmentReaders {
+		if segmentReader.Id == segmentId && segmentReader.Info.HasOffsets(fieldName) {
+			matches, err := indexedMatches(segmentReader, queryTerms, index.DocumentId(uint32(docId)), fieldName)
+			if err != nil {
+				return nil, err
+			}
+
+			return removeOverlaps(matches), nil
+		}
+	}
+
+	return removeOverlaps(analyzedMatches(analyzer, queryTerms, value)), nil
+}
+
+// Reads the offsets of the query terms in the doc from the offsets file
+func indexedMatches(segmentReader *index.SegmentReader, queryTerms *queryTerms, docId index.DocumentId, fieldName string) ([]*match, error) {
+	dictionaryReader, err := segmentReader.DictionaryReader(fieldName)
+	if err != nil {
+		return nil, err
+	}
+
+	fieldFreqsReader, err := segmentReader.FieldFreqsReader(fieldName)
+	if err != nil {
+		return nil, err
+	}
+
+	fieldOffsetsReader, err := segmentReader.FieldOffsetsReader(fieldName)
+	if err != nil {
+		return nil, err
+	}
+
+	terms := make([][]byte, 0, len(queryTerms.terms))
+	for term := range queryTerms.terms {
+		terms = append(terms, []byte(term))
+	}
+
+	for _, prefix := range queryTerms.prefixes {
+		prefixTerms, ok := dictionaryReader.TermsWithPrefix(prefix.prefix, query.MaxPrefixTerms)
+		if !ok {
+			return nil, fmt.Errorf("prefix %q of field %s matches more than %d terms", prefix.prefix, fieldName, query.MaxPrefixTerms)
+		}
+
+		terms = append(terms, prefixTerms...)
+	}
+
+	matches := make([]*match, 0)
+
+	for _, term := range terms {
+		termInfo := dictionaryReader.Get(term)
+		if termInfo == nil {
+			continue
+		}
+
+		it := fieldOffsetsReader.TermOffsetsIterator(fieldFreqsReader.TermFreqsIterator(termInfo), termInfo)
+		if !it.Next(docId) || it.DocId() != docId {
+			continue
+		}
+
+		weight, _ := queryTerms.weight(term)
+
+		for _, offsets := range it.Offsets() {
+			matches = append(matches, &match{
+				span:   span{start: int(offsets.Start), end: int(offsets.End)},
+				term:   string(term),
+				weight: weight,
+			})
+		}
+	}
+
+	return matches, nil
+}
+
+// Analyzes the value again to find the offsets of the query terms
+func analyzedMatches(analyzer index.Analyzer, queryTerms *queryTerms, value []byte) []*match {
+	tokenStream := analyzer.NewTokenStream()
+	tokenStream.Reset(value)
+
+	matches := make([]*match, 0)
+
+	for {
+		token, ok := tokenStream.NextToken()
+		if !ok {
+			return matches
+		}
+
+		if weight, ok := queryTerms.weight(token.Text); ok {
+			matches = append(matches, &match{
+				span:   span{start: token.Start, end: token.End},
+				term:   string(token.Text),
+				weight: weight,
+			})
+		}
+	}
+}
+
+// Sorts the matches and drops the ones overlapping a previous match, e.g.
+// two terms from the same word
+func removeOverlaps(matches []*match) []*match {
+	slices.SortStableFunc(matches, func(a, b *match) int {
+		return cmp.Or(cmp.Compare(a.start, b.start), cmp.Compare(b.end, a.end))
+	})
+
+	end := 0
+	return slices.DeleteFunc(matches, func(match *match) bool {
+		if match.start < end {
+			return true
+		}
+
+		end = match.end
+		return false
+	})
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// Fragments
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+// Splits the value on spaces and punctuation, as the standard tokenizer
+func splitWords(value []byte) []span {
+	words := make([]span, 0)
+	start := -1
+
+	for i := 0; i < len(value); {
+		r, size := utf8.DecodeRune(value[i:])
+
+		if unicode.IsSpace(r) || unicode.IsPunct(r) {
+			if start >= 0 {
+				words = append(words, span{start: start, end: i})
+				start = -1
+			}
+		} else if start < 0 {
+			start = i
+		}
+
+		i += size
+	}
+
+	if start >= 0 {
+		words = append(words, span{start: start, end: len(value)})
+	}
+
+	return words
+}
+
+// Splits the value in fragments of about fragmentSize bytes. Fragments start
+// at a word and end before the next fragment, without the trailing spaces, so
+// that they keep their punctuation.
+func splitFragments(value []byte, words []span, fragmentSize int) []span {
+	fragments := make([]span, 0)
+
+	for i := 0; i < len(words); {
+		start := words[i].start
+
+		// At least one word per fragment
+		j := i + 1
+		for j < len(words) && words[j].end-start <= fragmentSize {
+			j++
+		}
+
+		end := len(value)
+		if j < len(words) {
+			end = words[j].start
+		}
+
+		end = start + len(bytes.TrimRightFunc(value[start:end], unicode.IsSpace))
+
+		fragments = append(fragments, span{start: start, end: end})
+		i = j
+	}
+
+	return fragments
+}
+
+// Returns nil if the fragment has no matches
+func newFragment(value []byte, fragmentSpan span, matches []*match, options *Options) *Fragment {
+	var text strings.Builder
+
+	score := float32(0)
+	matchedTerms := make(map[string]bool)
+	position := fragmentSpan.start
+
+	for _, match := range matches {
+		if match.start < fragmentSpan.start || match.end > fragmentSpan.end {
+			continue
+		}
+
+		if !matchedTerms[match.term] {
+			matchedTerms[match.term] = true
+			score += match.weight
+		}
+
+		text.WriteString(options.Encode(string(value[position:match.start])))
+		text.WriteString(options.PreTag)
+		text.WriteString(options.Encode(string(value[match.start:match.end])))
+		text.WriteString(options.PostTag)
+
+		position = match.end
+	}
+
+	if len(matchedTerms) == 0 {
+		return nil
+	}
+
+	text.WriteString(options.Encode(string(value[position:fragmentSpan.end])))
+
+	return &Fragment{
+		Text:  text.String(),
+		Score: score,
+		Start: fragmentSpan.start,
+		End:   fragmentSpan.end,
+	}
+}
@@ -0,0 +1,231 @@
+package highlight_test
+
+import (
+	"encoding/binary"
+	"html"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/larose/lynx/search"
+	"github.com/larose/lynx/search/highlight"
+	"github.com/larose/lynx/search/index"
+	"github.com/larose/lynx/search/query"
+	"github.com/larose/lynx/search/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+const longBody = "Lynx is a search engine written in Go. It indexes documents in segments. " +
+	"Each segment has a dictionary of terms, and the postings of each term. " +
+	"Queries are scored with BM25, and block-max WAND skips the blocks of postings that can't make it to the top results. " +
+	"Segments are merged in the background."
+
+// Returns the reader of an index with one doc per body, and their doc ids
+func initIndex(name string, schema *index.Schema, bodies ...string) (*index.IndexReader, []uint64) {
+	directory := filepath.Join("testdata", name)
+	os.RemoveAll(directory)
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		log.Fatal(err)
+	}
+
+	indexWriter, err := index.NewIndexWriter(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer indexWriter.Close()
+	indexWriter.SetSchema(schema)
+
+	docs := make([]index.Document, len(bodies))
+	for i, body := range bodies {
+		docs[i] = []index.Field{
+			{Name: "id", FieldType: index.ByteFieldType, Value: utils.Uint64ToBytes(uint64(i))},
+			{Name: "body", FieldType: index.TextFieldType, Value: []byte(body)},
+			{Name: "title", FieldType: index.TextFieldType, Value: []byte("Search engine")},
+		}
+	}
+
+	if err := indexWriter.AddDocuments(docs); err != nil {
+		log.Fatal(err)
+	}
+
+	indexReader, err := index.NewIndexReader(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	collector := query.NewTopNCollector(len(bodies))
+	if err := search.Search(&query.TermNode{FieldName: "title", Term: []byte("search")}, indexReader, collector); err != nil {
+		log.Fatal(err)
+	}
+
+	docIds := make([]uint64, len(bodies))
+	for _, result := range collector.Get() {
+		value, err := indexReader.Value("id", result.DocId)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		docIds[binary.BigEndian.Uint64(value)] = result.DocId
+	}
+
+	return indexReader, docIds
+}
+
+func texts(fragments []*highlight.Fragment) []string {
+	texts := make([]string, len(fragments))
+	for i, fragment := range fragments {
+		texts[i] = fragment.Text
+	}
+
+	return texts
+}
+
+func TestHighlight(t *testing.T) {
+	indexReader, docIds := initIndex("highlight", index.NewSchema(index.StandardAnalyzerName),
+		"The quick brown fox jumps over the lazy dog.",
+		longBody,
+		"Nothing to see here",
+	)
+
+	defer indexReader.Close()
+
+	_query, err := query.Parse("Quick OR fox^2 OR title:dog", "body", nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fragments, err := highlight.Highlight(indexReader, _query, docIds[0], "body", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"The <em>quick</em> brown <em>fox</em> jumps over the lazy dog."}, texts(fragments))
+	assert.Equal(t, 0, fragments[0].Start)
+	assert.Equal(t, 44, fragments[0].End)
+
+	// No matches
+	fragments, err = highlight.Highlight(indexReader, _query, docIds[2], "body", nil)
+	assert.NoError(t, err)
+	assert.Empty(t, fragments)
+
+	// Best fragments first
+	_query, err = query.Parse(`segment* -engine "postings blocks"`, "body", nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fragments, err = highlight.Highlight(indexReader, _query, docIds[1], "body", &highlight.Options{
+		PreTag:       "[",
+		PostTag:      "]",
+		FragmentSize: 80,
+		NumFragments: 2,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"[segment] has a dictionary of terms, and the [postings] of each term. Queries are",
+		"scored with BM25, and block-max WAND skips the [blocks] of [postings] that can't",
+	}, texts(fragments))
+
+	for _, fragment := range fragments {
+		assert.LessOrEqual(t, fragment.End-fragment.Start, 80)
+	}
+}
+
+func TestHighlightEncode(t *testing.T) {
+	indexReader, docIds := initIndex("highlight_encode", index.NewSchema(index.StandardAnalyzerName),
+		`Say "hi" & bye`,
+	)
+
+	defer indexReader.Close()
+
+	fragments, err := highlight.Highlight(indexReader, &query.TermNode{FieldName: "body", Term: []byte("hi")}, docIds[0], "body", &highlight.Options{
+		Encode: html.EscapeString,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Say &#34;<em>hi</em>&#34; &amp; bye"}, texts(fragments))
+}
+
+func TestHighlightAnalyzer(t *testing.T) {
+	indexReader, docIds := initIndex("highlight_analyzer", index.NewSchema(index.EnglishAnalyzerName),
+		"The dogs were Running in the PARK",
+	)
+
+	defer indexReader.Close()
+
+	fragments, err := highlight.Highlight(indexReader, &query.MatchNode{FieldName: "body", Text: "the dog runs in parks"}, docIds[0], "body", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"The <em>dogs</em> were <em>Running</em> in the <em>PARK</em>"}, texts(fragments))
+}
+
+func TestHighlightSegmentWithoutField(t *testing.T) {
+	directory := filepath.Join("testdata", "highlight_segment_without_field")
+	os.RemoveAll(directory)
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		log.Fatal(err)
+	}
+
+	indexWriter, err := index.NewIndexWriter(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer indexWriter.Close()
+	indexWriter.SetMergePolicy(&index.NoMergePolicy{})
+
+	// The first segment has no body
+	if err := indexWriter.AddDocuments([]index.Document{{{Name: "title", FieldType: index.TextFieldType, Value: []byte("Search engine")}}}); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := indexWriter.AddDocuments([]index.Document{{{Name: "body", FieldType: index.TextFieldType, Value: []byte("The quick brown fox")}}}); err != nil {
+		log.Fatal(err)
+	}
+
+	indexReader, err := index.NewIndexReader(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer indexReader.Close()
+
+	docId := index.ToGlobalDocId(indexReader.SegmentReaders[1].Id, 0)
+	fragments, err := highlight.Highlight(indexReader, &query.TermNode{FieldName: "body", Term: []byte("fox")}, docId, "body", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"The quick brown <em>fox</em>"}, texts(fragments))
+}
+
+func TestHighlightOffsets(t *testing.T) {
+	bodies := []string{"The ﬁne dogs were Running in the PARK", longBody}
+
+	schema := index.NewSchema(index.EnglishAnalyzerName)
+	schema.SetFieldOffsets("body", true)
+
+	indexReader, docIds := initIndex("highlight_offsets", schema, bodies...)
+	defer indexReader.Close()
+
+	// Same fragments as when the value is analyzed again
+	withoutOffsetsReader, withoutOffsetsDocIds := initIndex("highlight_without_offsets", index.NewSchema(index.EnglishAnalyzerName), bodies...)
+	defer withoutOffsetsReader.Close()
+
+	_query, err := query.Parse("fine dog runs OR par* OR segment*", "body", nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	expected := [][]string{
+		{"The <em>ﬁne</em> <em>dogs</em> were <em>Running</em> in the <em>PARK</em>"},
+		{
+			"Lynx is a search engine written in Go. It indexes documents in <em>segments</em>. Each <em>segment</em> has a",
+			"skips the blocks of postings that can't make it to the top results. <em>Segments</em> are merged in the",
+		},
+	}
+
+	for i := range bodies {
+		fragments, err := highlight.Highlight(indexReader, _query, docIds[i], "body", nil)
+		assert.NoError(t, err)
+		assert.Equal(t, expected[i], texts(fragments))
+
+		fragments, err = highlight.Highlight(withoutOffsetsReader, _query, withoutOffsetsDocIds[i], "body", nil)
+		assert.NoError(t, err)
+		assert.Equal(t, expected[i], texts(fragments))
+	}
+}
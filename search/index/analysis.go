@@ -7,8 +7,14 @@ import (
 
 type Token struct {
 	Text []byte
+	// Byte offsets of the token in the input of the analyzer: the token comes
+	// from input[Start:End]
+	Start int
+	End   int
 }
 
+// StandardTokenizer splits the input on spaces and punctuation. It doesn't
+// change the case of the tokens, see LowerCaseFilter.
 type StandardTokenizer struct {
 	input           []byte
 	inputIndex      int
@@ -55,16 +61,18 @@ func (t *StandardTokenizer) NextToken() (*Token, bool) {
 	for t.inputIndex < len(t.input) {
 		r, size := utf8.DecodeRune(t.input[t.inputIndex:])
 
-		// TODO: apply proper normalizatoin
-		normalizedRune := unicode.ToLower(r)
-
-		if unicode.IsSpace(normalizedRune) || unicode.IsPunct(normalizedRune) {
+		if unicode.IsSpace(r) || unicode.IsPunct(r) {
 			if len(t.tokenBuffer) > 0 {
 				t.tokenTextBuffer, t.token.Text = runesToBytes(t.tokenBuffer, t.tokenTextBuffer)
+				t.token.End = t.inputIndex
 				return t.token, true
 			}
 		} else {
-			t.tokenBuffer = append(t.tokenBuffer, normalizedRune)
+			if len(t.tokenBuffer) == 0 {
+				t.token.Start = t.inputIndex
+			}
+
+			t.tokenBuffer = append(t.tokenBuffer, r)
 		}
 
 		t.inputIndex += size
@@ -72,6 +80,7 @@ func (t *StandardTokenizer) NextToken() (*Token, bool) {
 
 	if len(t.tokenBuffer) > 0 {
 		t.tokenTextBuffer, t.token.Text = runesToBytes(t.tokenBuffer, t.tokenTextBuffer)
+		t.token.End = t.inputIndex
 		return t.token, true
 	}
 
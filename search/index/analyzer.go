@@ -0,0 +1,318 @@
+package index
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+	"unicode/utf8"
+)
+
+// TokenStream splits an input into tokens. A TokenStream is not safe for
+// concurrent use.
+type TokenStream interface {
+	Reset(input []byte)
+	// Token is valid until the next call to NextToken
+	NextToken() (*Token, bool)
+}
+
+// CharFilter transforms the input before it is tokenized. It must be safe for
+// concurrent use.
+type CharFilter interface {
+	Filter(input []byte) []byte
+}
+
+// OffsetCharFilter is a CharFilter that can change the length of its input.
+// It returns how the offsets of its output map to the offsets of its input,
+// so that the offsets of the tokens point to the input of the analyzer. The
+// offsets of the char filters that don't implement it are left as is.
+type OffsetCharFilter interface {
+	CharFilter
+	FilterWithOffsets(input []byte) ([]byte, *OffsetCorrection)
+}
+
+// OffsetCorrection maps the offsets of the output of a char filter to the
+// offsets of its input. The output is made of parts, each one coming from a
+// part of the input. Offsets inside a part of the same length as its input
+// part are mapped one to one, the others to the start of their part. A nil
+// correction leaves the offsets as is.
+type OffsetCorrection struct {
+	// Start of each part in the output and in the input, and a last part at
+	// the end of the output and the input
+	outputStarts []int
+	inputStarts  []int
+	// changed[i] is true when part i has a different length in the output
+	changed []bool
+}
+
+// Add appends a part of the output coming from input[inputStart:inputEnd].
+// Parts must be added in order, without gaps.
+func (c *OffsetCorrection) Add(outputStart, outputEnd, inputStart, inputEnd int) {
+	changed := outputEnd-outputStart != inputEnd-inputStart
+	last := len(c.changed) - 1
+
+	// Consecutive unchanged parts are merged
+	if last >= 0 && !changed && !c.changed[last] {
+		c.outputStarts[last+1] = outputEnd
+		c.inputStarts[last+1] = inputEnd
+		return
+	}
+
+	if last < 0 {
+		c.outputStarts = append(c.outputStarts, outputStart)
+		c.inputStarts = append(c.inputStarts, inputStart)
+	}
+
+	c.outputStarts = append(c.outputStarts, outputEnd)
+	c.inputStarts = append(c.inputStarts, inputEnd)
+	c.changed = append(c.changed, changed)
+}
+
+// Correct returns the offset of the input for the offset of the output
+func (c *OffsetCorrection) Correct(offset int) int {
+	if c == nil || len(c.changed) == 0 {
+		return offset
+	}
+
+	// Last part, or end, at or before offset
+	i := sort.Search(len(c.outputStarts), func(i int) bool {
+		return c.outputStarts[i] > offset
+	}) - 1
+
+	if i < 0 || i == len(c.changed) || c.changed[i] {
+		return c.inputStarts[max(i, 0)]
+	}
+
+	return c.inputStarts[i] + offset - c.outputStarts[i]
+}
+
+// TokenFilter transforms a token after it is tokenized. It may modify the
+// bytes of token.Text in place or point token.Text to new bytes. Returns false
+// to drop the token. It must be safe for concurrent use.
+type TokenFilter interface {
+	Filter(token *Token) bool
+}
+
+// Analyzer turns the value of a text field into the terms of the field. The
+// same analyzer must be used at index and at query time, otherwise the terms
+// of the query don't match the terms of the index. It must be safe for
+// concurrent use.
+type Analyzer interface {
+	NewTokenStream() TokenStream
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// ChainAnalyzer
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+// ChainAnalyzer runs the char filters, then the tokenizer, then the token
+// filters, in order.
+type ChainAnalyzer struct {
+	CharFilters  []CharFilter
+	NewTokenizer func() TokenStream
+	TokenFilters []TokenFilter
+}
+
+func (a *ChainAnalyzer) NewTokenStream() TokenStream {
+	return &chainTokenStream{
+		charFilters:  a.CharFilters,
+		tokenizer:    a.NewTokenizer(),
+		tokenFilters: a.TokenFilters,
+	}
+}
+
+type chainTokenStream struct {
+	charFilters  []CharFilter
+	tokenizer    TokenStream
+	tokenFilters []TokenFilter
+	// corrections[i] is the offset correction of the char filter i
+	corrections []*OffsetCorrection
+	corrected   bool
+}
+
+func (s *chainTokenStream) Reset(input []byte) {
+	s.corrections = s.corrections[:0]
+	s.corrected = false
+
+	for _, charFilter := range s.charFilters {
+		if offsetCharFilter, ok := charFilter.(OffsetCharFilter); ok {
+			var correction *OffsetCorrection
+			input, correction = offsetCharFilter.FilterWithOffsets(input)
+			s.corrections = append(s.corrections, correction)
+			s.corrected = s.corrected || correction != nil
+			continue
+		}
+
+		input = charFilter.Filter(input)
+		s.corrections = append(s.corrections, nil)
+	}
+
+	s.tokenizer.Reset(input)
+}
+
+func (s *chainTokenStream) NextToken() (*Token, bool) {
+	for {
+		token, ok := s.tokenizer.NextToken()
+		if !ok {
+			return nil, false
+		}
+
+		if s.corrected {
+			for i := len(s.corrections) - 1; i >= 0; i-- {
+				token.Start = s.corrections[i].Correct(token.Start)
+				token.End = s.corrections[i].Correct(token.End)
+			}
+		}
+
+		if s.filter(token) {
+			return token, true
+		}
+	}
+}
+
+func (s *chainTokenStream) filter(token *Token) bool {
+	for _, tokenFilter := range s.tokenFilters {
+		if !tokenFilter.Filter(token) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// NewStandardAnalyzer returns the analyzer used by default: it normalizes the
+// text with NFKC, splits it on spaces and punctuation and lowercases the
+// tokens.
+func NewStandardAnalyzer() *ChainAnalyzer {
+	return &ChainAnalyzer{
+		CharFilters:  []CharFilter{&NFKCCharFilter{}},
+		NewTokenizer: func() TokenStream { return NewStandardTokenizer() },
+		TokenFilters: []TokenFilter{&LowerCaseFilter{}},
+	}
+}
+
+// NewStandardV0Analyzer returns the standard analyzer of the indexes of version
+// 0, written before the NFKC normalization: it splits the text on spaces and
+// punctuation and lowercases the tokens.
+func NewStandardV0Analyzer() *ChainAnalyzer {
+	return &ChainAnalyzer{
+		NewTokenizer: func() TokenStream { return NewStandardTokenizer() },
+		TokenFilters: []TokenFilter{&LowerCaseFilter{}},
+	}
+}
+
+// NewFoldingAnalyzer returns the standard analyzer followed by an
+// ASCIIFoldingFilter, so that "Café" and "cafe" match.
+func NewFoldingAnalyzer() *ChainAnalyzer {
+	return &ChainAnalyzer{
+		CharFilters:  []CharFilter{&NFKCCharFilter{}},
+		NewTokenizer: func() TokenStream { return NewStandardTokenizer() },
+		TokenFilters: []TokenFilter{&LowerCaseFilter{}, &ASCIIFoldingFilter{}},
+	}
+}
+
+// NewEnglishAnalyzer returns an analyzer for English text: it normalizes the
+// text with NFKC, splits it on spaces and punctuation, lowercases the tokens,
+// drops the English stop words and stems the tokens.
+func NewEnglishAnalyzer() *ChainAnalyzer {
+	return &ChainAnalyzer{
+		CharFilters:  []CharFilter{&NFKCCharFilter{}},
+		NewTokenizer: func() TokenStream { return NewStandardTokenizer() },
+		TokenFilters: []TokenFilter{&LowerCaseFilter{}, NewStopFilter(EnglishStopWords), &PorterStemFilter{}},
+	}
+}
+
+// Analyze returns the terms of input. Convenient at query time, where
+// performance matters less than at index time.
+func Analyze(analyzer Analyzer, input []byte) [][]byte {
+	tokenStream := analyzer.NewTokenStream()
+	tokenStream.Reset(input)
+
+	terms := make([][]byte, 0, 10)
+
+	for {
+		token, ok := tokenStream.NextToken()
+		if !ok {
+			return terms
+		}
+
+		terms = append(terms, bytes.Clone(token.Text))
+	}
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// Analyzer registry
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+// Names of the analyzers registered by default
+const (
+	StandardAnalyzerName   = "standard"
+	StandardV0AnalyzerName = "standard-v0"
+	FoldingAnalyzerName    = "folding"
+	EnglishAnalyzerName    = "english"
+)
+
+// Schemas reference the analyzers by name, and the names are saved in the
+// segment infos, so that readers analyze the queries with the analyzers the
+// writer used for the documents. Other analyzers must be registered with
+// RegisterAnalyzer, under the same name, by the writers and the readers.
+var analyzerRegistry = struct {
+	analyzers map[string]Analyzer
+	mutex     sync.RWMutex
+}{
+	analyzers: map[string]Analyzer{
+		StandardAnalyzerName:   NewStandardAnalyzer(),
+		StandardV0AnalyzerName: NewStandardV0Analyzer(),
+		FoldingAnalyzerName:    NewFoldingAnalyzer(),
+		EnglishAnalyzerName:    NewEnglishAnalyzer(),
+	},
+}
+
+// RegisterAnalyzer registers analyzer under name. Returns an error if the name
+// is already registered.
+func RegisterAnalyzer(name string, analyzer Analyzer) error {
+	analyzerRegistry.mutex.Lock()
+	defer analyzerRegistry.mutex.Unlock()
+
+	if _, exists := analyzerRegistry.analyzers[name]; exists {
+		return fmt.Errorf("analyzer %q already registered", name)
+	}
+
+	analyzerRegistry.analyzers[name] = analyzer
+
+	return nil
+}
+
+func analyzerByName(name string) (Analyzer, error) {
+	analyzerRegistry.mutex.RLock()
+	defer analyzerRegistry.mutex.RUnlock()
+
+	analyzer, exists := analyzerRegistry.analyzers[name]
+	if !exists {
+		return nil, fmt.Errorf("unknown analyzer %q", name)
+	}
+
+	return analyzer, nil
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// LowerCaseFilter
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+type LowerCaseFilter struct {
+}
+
+func (f *LowerCaseFilter) Filter(token *Token) bool {
+	for i, b := range token.Text {
+		if b >= utf8.RuneSelf {
+			token.Text = bytes.ToLower(token.Text)
+			return true
+		}
+
+		if 'A' <= b && b <= 'Z' {
+			token.Text[i] = b + ('a' - 'A')
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,91 @@
+package index
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type removeDashCharFilter struct {
+}
+
+func (f *removeDashCharFilter) Filter(input []byte) []byte {
+	return bytes.ReplaceAll(input, []byte("-"), nil)
+}
+
+type minLengthFilter struct {
+	minLength int
+}
+
+func (f *minLengthFilter) Filter(token *Token) bool {
+	return len(token.Text) >= f.minLength
+}
+
+func toStrings(terms [][]byte) []string {
+	strings := make([]string, len(terms))
+	for i, term := range terms {
+		strings[i] = string(term)
+	}
+
+	return strings
+}
+
+func TestStandardAnalyzer(t *testing.T) {
+	terms := Analyze(NewStandardAnalyzer(), []byte("Hello, World! ÉTÉ  à Montréal."))
+
+	assert.Equal(t, []string{"hello", "world", "été", "à", "montréal"}, toStrings(terms))
+}
+
+func TestChainAnalyzer(t *testing.T) {
+	analyzer := &ChainAnalyzer{
+		CharFilters:  []CharFilter{&removeDashCharFilter{}},
+		NewTokenizer: func() TokenStream { return NewStandardTokenizer() },
+		TokenFilters: []TokenFilter{&minLengthFilter{minLength: 3}, &LowerCaseFilter{}},
+	}
+
+	terms := Analyze(analyzer, []byte("An E-Mail to Bob"))
+
+	assert.Equal(t, []string{"email", "bob"}, toStrings(terms))
+
+	// Token streams are reusable
+	tokenStream := analyzer.NewTokenStream()
+	for _, input := range []string{"Re-Use", "It"} {
+		tokenStream.Reset([]byte(input))
+
+		token, ok := tokenStream.NextToken()
+		if input == "It" {
+			assert.False(t, ok)
+			continue
+		}
+
+		assert.True(t, ok)
+		assert.Equal(t, "reuse", string(token.Text))
+	}
+}
+
+func tokenOffsets(analyzer Analyzer, input string) []string {
+	tokenStream := analyzer.NewTokenStream()
+	tokenStream.Reset([]byte(input))
+
+	tokens := make([]string, 0)
+	for {
+		token, ok := tokenStream.NextToken()
+		if !ok {
+			return tokens
+		}
+
+		tokens = append(tokens, string(token.Text)+"="+input[token.Start:token.End])
+	}
+}
+
+func TestAnalyzerOffsets(t *testing.T) {
+	// The ligature and the decomposed accent are shorter once normalized
+	assert.Equal(t,
+		[]string{"hello=Hello", "fine=ﬁne", "café=Café", "über=Über"},
+		tokenOffsets(NewStandardAnalyzer(), "Hello, ﬁne Café! Über"))
+
+	assert.Equal(t,
+		[]string{"fine=ﬁne", "cafe=Café"},
+		tokenOffsets(NewFoldingAnalyzer(), "ﬁne  Café"))
+}
@@ -0,0 +1,525 @@
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/RoaringBitmap/roaring/v2"
+	"github.com/larose/lynx/search/utils"
+)
+
+// CheckIndex reads all the files of the current commit of directory and
+// returns the problems found, joined in one error, or nil if the index is
+// consistent. It verifies the checksums, then decodes the files without
+// trusting them: a corrupted file is reported as an error, not a panic.
+//
+// The directory must not be modified during the check.
+func CheckIndex(directory string) error {
+	commit, err := readCommit(directory)
+	if err != nil {
+		return err
+	}
+
+	dirEntries, err := os.ReadDir(directory)
+	if err != nil {
+		return err
+	}
+
+	segmentInfos := make(map[uint32]*SegmentInfo, len(commit.SegmentIds))
+
+	for _, segmentId := range commit.SegmentIds {
+		segmentErr := checkSegment(directory, segmentId, dirEntries, segmentInfos)
+		if segmentErr != nil {
+			err = errors.Join(err, fmt.Errorf("segment %d: %w", segmentId, segmentErr))
+		}
+	}
+
+	if commit.DeletedId != nil {
+		if deletedErr := checkDeleted(directory, *commit.DeletedId, commit.deletedVersion(), segmentInfos); deletedErr != nil {
+			err = errors.Join(err, fmt.Errorf("deleted %d: %w", *commit.DeletedId, deletedErr))
+		}
+	}
+
+	return err
+}
+
+// Decoding errors of the checks are reported with recover, as a last resort
+func recoverCheck(err *error) {
+	if r := recover(); r != nil {
+		*err = errors.Join(*err, fmt.Errorf("panic: %v", r))
+	}
+}
+
+func checkSegment(directory string, segmentId uint32, dirEntries []os.DirEntry, segmentInfos map[uint32]*SegmentInfo) (err error) {
+	defer recoverCheck(&err)
+
+	segment := strconv.FormatUint(uint64(segmentId), 10)
+
+	segmentInfo, err := readSegmentInfo(directory, segment)
+	if err != nil {
+		return err
+	}
+
+	segmentInfos[segmentId] = segmentInfo
+
+	prefix := "segment." + segment + "."
+
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+		if !strings.HasPrefix(name, prefix) || name == prefix+"info" {
+			continue
+		}
+
+		err = errors.Join(err, verifyChecksum(filepath.Join(directory, name), segmentInfo.Version))
+	}
+
+	for _, fieldName := range segmentInfo.Fields {
+		if fieldErr := checkField(directory, segment, fieldName, segmentInfo.Version, segmentInfo.DocCount, segmentInfo.HasPositions(fieldName), segmentInfo.HasOffsets(fieldName)); fieldErr != nil {
+			err = errors.Join(err, fmt.Errorf("field %s: %w", fieldName, fieldErr))
+		}
+	}
+
+	for _, fieldName := range segmentInfo.DocValuesFields {
+		if fieldErr := checkDocValues(directory, segment, fieldName, segmentInfo.Version, segmentInfo.DocCount); fieldErr != nil {
+			err = errors.Join(err, fmt.Errorf("field %s: doc values: %w", fieldName, fieldErr))
+		}
+	}
+
+	return err
+}
+
+// The doc values reader checks the lengths of the sections of the file, and
+// the ordinals
+func checkDocValues(directory, segment, fieldName string, version, docCount uint32) error {
+	docValuesReader, err := newDocValuesReader(directory, segment, fieldName, version)
+	if err != nil {
+		return err
+	}
+
+	defer docValuesReader.Close()
+
+	if docValuesReader.docCount != docCount {
+		return fmt.Errorf("%d docs, expected %d", docValuesReader.docCount, docCount)
+	}
+
+	for ord := uint32(1); ord < docValuesReader.ValueCount(); ord++ {
+		if bytes.Compare(docValuesReader.Value(ord-1), docValuesReader.Value(ord)) >= 0 {
+			return fmt.Errorf("value %d not after value %d", ord, ord-1)
+		}
+	}
+
+	return nil
+}
+
+func checkField(directory, segment, fieldName string, version, docCount uint32, hasPositions, hasOffsets bool) error {
+	dictionaryReader, err := newDictionaryReader(directory, segment, fieldName, version)
+	if err != nil {
+		return err
+	}
+
+	defer dictionaryReader.Close()
+
+	fieldFreqsReader, err := newFieldFreqsReader(directory, segment, fieldName, version)
+	if err != nil {
+		return err
+	}
+
+	defer fieldFreqsReader.Close()
+
+	// nil if the field has no positions
+	var positions []byte
+
+	if hasPositions {
+		fieldPositionsReader, err := newFieldPositionsReader(directory, segment, fieldName, version)
+		if err != nil {
+			return err
+		}
+
+		defer fieldPositionsReader.Close()
+
+		positions = fieldPositionsReader.fileReader.data
+	}
+
+	// nil if the field has no offsets
+	var offsets []byte
+
+	if hasOffsets {
+		fieldOffsetsReader, err := newFieldOffsetsReader(directory, segment, fieldName, version)
+		if err != nil {
+			return err
+		}
+
+		defer fieldOffsetsReader.Close()
+
+		offsets = fieldOffsetsReader.fileReader.data
+	}
+
+	if err := checkDictionary(dictionaryReader.kvReader, fieldFreqsReader.fileReader.data, positions, offsets, docCount); err != nil {
+		return err
+	}
+
+	lengthsReader, err := newArrayStoreReader(filepath.Join(directory, "segment."+segment+"."+fieldName+".lengths"), lengthsCodec, 1, version)
+	if err != nil {
+		return err
+	}
+
+	defer lengthsReader.Close()
+
+	if len(lengthsReader.data) != int(docCount) {
+		return fmt.Errorf("%d field lengths for %d docs", len(lengthsReader.data), docCount)
+	}
+
+	fieldStatsReader, err := newFieldStatsReader(directory, segment, fieldName, version)
+	if err != nil {
+		return err
+	}
+
+	defer fieldStatsReader.Close()
+
+	statsDocCount, _, err := fieldStatsReader.Read()
+	if err != nil {
+		return err
+	}
+
+	if statsDocCount > docCount {
+		return fmt.Errorf("stats: %d docs with the field, more than the %d docs of the segment", statsDocCount, docCount)
+	}
+
+	storeReader, err := newKVStoreReader(fieldStorePath(directory, segment, fieldName), storeCodec, version)
+	if err != nil {
+		return err
+	}
+
+	defer storeReader.Close()
+
+	if err := checkKVStore(storeReader); err != nil {
+		return fmt.Errorf("store: %w", err)
+	}
+
+	for i := 0; i < storeReader.Len(); i++ {
+		key, _ := storeReader.At(i)
+
+		if len(key) != 4 || utils.BytesToUint32(key) >= docCount {
+			return fmt.Errorf("store: invalid doc id %x", key)
+		}
+	}
+
+	return nil
+}
+
+// checkKVStore checks that all the items are inside the data file and that
+// the keys are in increasing order
+func checkKVStore(kvReader *KVStoreReader) error {
+	if len(kvReader.index)%8 != 0 {
+		return fmt.Errorf("index of %d bytes is not a multiple of 8", len(kvReader.index))
+	}
+
+	var previousKey []byte
+
+	for i := 0; i < kvReader.Len(); i++ {
+		offset := binary.BigEndian.Uint64(kvReader.index[i*8:])
+
+		if offset > uint64(len(kvReader.data)) || uint64(len(kvReader.data))-offset < 8 {
+			return fmt.Errorf("item %d: offset %d outside of the data", i, offset)
+		}
+
+		keyLength := uint64(binary.BigEndian.Uint32(kvReader.data[offset:]))
+		valueLength := uint64(binary.BigEndian.Uint32(kvReader.data[offset+4:]))
+
+		if uint64(len(kvReader.data))-offset-8 < keyLength+valueLength {
+			return fmt.Errorf("item %d: length %d outside of the data", i, keyLength+valueLength)
+		}
+
+		key, _ := kvReader.At(i)
+
+		if i > 0 && bytes.Compare(previousKey, key) >= 0 {
+			return fmt.Errorf("item %d: key %q not after %q", i, key, previousKey)
+		}
+
+		previousKey = key
+	}
+
+	return nil
+}
+
+// positions is nil if the field has no positions, and offsets if it has no
+// offsets
+func checkDictionary(kvReader *KVStoreReader, freqs, positions, offsets []byte, docCount uint32) error {
+	if err := checkKVStore(kvReader); err != nil {
+		return fmt.Errorf("dictionary: %w", err)
+	}
+
+	for i := 0; i < kvReader.Len(); i++ {
+		term, value := kvReader.At(i)
+
+		termInfoLength := termInfoSize
+		if offsets != nil {
+			termInfoLength = termInfoWithOffsetsSize
+		}
+
+		// Fields without positions were written with or without their
+		// offsets
+		if len(value) != termInfoLength && (positions != nil || len(value) != termInfoWithoutPositionsSize) {
+			return fmt.Errorf("term %q: term info of %d bytes", term, len(value))
+		}
+
+		termInfo := decodeTermInfo(value)
+
+		if termInfo.FreqsFileStartOffset > termInfo.FreqsFileEndOffset || termInfo.FreqsFileEndOffset > uint64(len(freqs)) {
+			return fmt.Errorf("term %q: frequencies %d to %d outside of the file of %d bytes", term, termInfo.FreqsFileStartOffset, termInfo.FreqsFileEndOffset, len(freqs))
+		}
+
+		var termPositions []byte
+
+		if positions != nil {
+			if termInfo.PositionsFileStartOffset > termInfo.PositionsFileEndOffset || termInfo.PositionsFileEndOffset > uint64(len(positions)) {
+				return fmt.Errorf("term %q: positions %d to %d outside of the file of %d bytes", term, termInfo.PositionsFileStartOffset, termInfo.PositionsFileEndOffset, len(positions))
+			}
+
+			termPositions = positions[termInfo.PositionsFileStartOffset:termInfo.PositionsFileEndOffset]
+		}
+
+		var termOffsets []byte
+
+		if offsets != nil {
+			if termInfo.OffsetsFileStartOffset > termInfo.OffsetsFileEndOffset || termInfo.OffsetsFileEndOffset > uint64(len(offsets)) {
+				return fmt.Errorf("term %q: offsets %d to %d outside of the file of %d bytes", term, termInfo.OffsetsFileStartOffset, termInfo.OffsetsFileEndOffset, len(offsets))
+			}
+
+			termOffsets = offsets[termInfo.OffsetsFileStartOffset:termInfo.OffsetsFileEndOffset]
+		}
+
+		err := checkPostings(
+			freqs[termInfo.FreqsFileStartOffset:termInfo.FreqsFileEndOffset],
+			termPositions,
+			termOffsets,
+			termInfo.DocFreq,
+			docCount,
+		)
+
+		if err != nil {
+			return fmt.Errorf("term %q: %w", term, err)
+		}
+	}
+
+	return nil
+}
+
+// Decodes the blocks of a term like TermFreqsIterator, TermPositionsIterator
+// and TermOffsetsIterator, checking each length. positions is nil if the
+// field has no positions, and offsets if it has no offsets.
+func checkPostings(freqs, positions, offsets []byte, docFreq, docCount uint32) error {
+	readUvarints := func(data []byte, n uint64) ([]uint64, []byte, error) {
+		values := make([]uint64, 0, n)
+
+		for i := uint64(0); i < n; i++ {
+			value, length := binary.Uvarint(data)
+			if length <= 0 {
+				return nil, nil, errors.New("invalid varint")
+			}
+
+			values = append(values, value)
+			data = data[length:]
+		}
+
+		return values, data, nil
+	}
+
+	numDocs := uint32(0)
+	nextDocId := uint64(0)
+
+	for block := 0; len(freqs) > 0; block++ {
+		if len(freqs) < headerSize {
+			return fmt.Errorf("block %d: truncated header", block)
+		}
+
+		blockNumDocs := uint64(freqs[0])
+		firstDocId := binary.BigEndian.Uint32(freqs[1:])
+		lastDocId := binary.BigEndian.Uint32(freqs[5:])
+		maxFreq := binary.BigEndian.Uint64(freqs[9:])
+		blockLength := binary.BigEndian.Uint32(freqs[18:])
+
+		if blockNumDocs == 0 {
+			return fmt.Errorf("block %d: no docs", block)
+		}
+
+		if blockLength < headerSize || int(blockLength) > len(freqs) {
+			return fmt.Errorf("block %d: length %d outside of the postings", block, blockLength)
+		}
+
+		docIdDeltas, data, err := readUvarints(freqs[headerSize:blockLength], blockNumDocs)
+		if err != nil {
+			return fmt.Errorf("block %d: doc ids: %w", block, err)
+		}
+
+		termFreqs, data, err := readUvarints(data, blockNumDocs)
+		if err != nil {
+			return fmt.Errorf("block %d: term freqs: %w", block, err)
+		}
+
+		if len(data) > 0 {
+			return fmt.Errorf("block %d: %d extra bytes", block, len(data))
+		}
+
+		docId := uint64(0)
+		blockMaxFreq := uint64(0)
+		sumTermFreqs := uint64(0)
+
+		for i, delta := range docIdDeltas {
+			if i == 0 {
+				docId = delta
+			} else if delta == 0 {
+				return fmt.Errorf("block %d: doc ids not increasing", block)
+			} else {
+				docId += delta
+			}
+
+			if docId < nextDocId {
+				return fmt.Errorf("block %d: doc id %d not increasing", block, docId)
+			}
+
+			if docId >= uint64(docCount) {
+				return fmt.Errorf("block %d: doc id %d outside of the %d docs", block, docId, docCount)
+			}
+
+			nextDocId = docId + 1
+
+			if termFreqs[i] == 0 {
+				return fmt.Errorf("block %d: doc %d has a term freq of 0", block, docId)
+			}
+
+			blockMaxFreq = max(blockMaxFreq, termFreqs[i])
+			sumTermFreqs += termFreqs[i]
+		}
+
+		if uint64(firstDocId) != docIdDeltas[0] || uint64(lastDocId) != docId {
+			return fmt.Errorf("block %d: header doc ids %d to %d, expected %d to %d", block, firstDocId, lastDocId, docIdDeltas[0], docId)
+		}
+
+		if maxFreq != blockMaxFreq {
+			return fmt.Errorf("block %d: max term freq %d, expected %d", block, maxFreq, blockMaxFreq)
+		}
+
+		freqs = freqs[blockLength:]
+		numDocs += uint32(blockNumDocs)
+
+		// Positions block, if the field has positions
+		if positions != nil {
+			if len(positions) < positionsHeaderSize {
+				return fmt.Errorf("block %d: truncated positions header", block)
+			}
+
+			positionsLength := binary.BigEndian.Uint32(positions)
+			if positionsLength < positionsHeaderSize || int(positionsLength) > len(positions) {
+				return fmt.Errorf("block %d: positions length %d outside of the positions", block, positionsLength)
+			}
+
+			_, data, err = readUvarints(positions[positionsHeaderSize:positionsLength], sumTermFreqs)
+			if err != nil {
+				return fmt.Errorf("block %d: positions: %w", block, err)
+			}
+
+			if len(data) > 0 {
+				return fmt.Errorf("block %d: %d extra bytes of positions", block, len(data))
+			}
+
+			positions = positions[positionsLength:]
+		}
+
+		if offsets == nil {
+			continue
+		}
+
+		// Offsets block
+		if len(offsets) < offsetsHeaderSize {
+			return fmt.Errorf("block %d: truncated offsets header", block)
+		}
+
+		offsetsLength := binary.BigEndian.Uint32(offsets)
+		if offsetsLength < offsetsHeaderSize || int(offsetsLength) > len(offsets) {
+			return fmt.Errorf("block %d: offsets length %d outside of the offsets", block, offsetsLength)
+		}
+
+		// A start and a length per position
+		_, data, err = readUvarints(offsets[offsetsHeaderSize:offsetsLength], 2*sumTermFreqs)
+		if err != nil {
+			return fmt.Errorf("block %d: offsets: %w", block, err)
+		}
+
+		if len(data) > 0 {
+			return fmt.Errorf("block %d: %d extra bytes of offsets", block, len(data))
+		}
+
+		offsets = offsets[offsetsLength:]
+	}
+
+	if len(positions) > 0 {
+		return fmt.Errorf("%d extra bytes of positions", len(positions))
+	}
+
+	if len(offsets) > 0 {
+		return fmt.Errorf("%d extra bytes of offsets", len(offsets))
+	}
+
+	if numDocs != docFreq {
+		return fmt.Errorf("%d docs, expected a doc freq of %d", numDocs, docFreq)
+	}
+
+	return nil
+}
+
+func checkDeleted(directory string, deletedId, version uint32, segmentInfos map[uint32]*SegmentInfo) (err error) {
+	defer recoverCheck(&err)
+
+	basename := filepath.Join(directory, "deleted."+strconv.FormatUint(uint64(deletedId), 10))
+
+	for _, filename := range []string{basename + ".data", basename + ".index"} {
+		if err := verifyChecksum(filename, version); err != nil {
+			return err
+		}
+	}
+
+	kvReader, err := newKVStoreReader(basename, deletedCodec, version)
+	if err != nil {
+		return err
+	}
+
+	defer kvReader.Close()
+
+	if err := checkKVStore(kvReader); err != nil {
+		return err
+	}
+
+	for i := 0; i < kvReader.Len(); i++ {
+		key, value := kvReader.At(i)
+		if len(key) != 4 {
+			return fmt.Errorf("invalid segment id %x", key)
+		}
+
+		segmentId := utils.BytesToUint32(key)
+
+		deletedDocIds := roaring.NewBitmap()
+		if err := deletedDocIds.UnmarshalBinary(value); err != nil {
+			return fmt.Errorf("segment %d: %w", segmentId, err)
+		}
+
+		segmentInfo, exists := segmentInfos[segmentId]
+		if !exists {
+			// Deleted docs of segments that were dropped since are never
+			// read. Segments that failed their check are already reported.
+			continue
+		}
+
+		if !deletedDocIds.IsEmpty() && deletedDocIds.Maximum() >= segmentInfo.DocCount {
+			return fmt.Errorf("segment %d: deleted doc id %d outside of the %d docs", segmentId, deletedDocIds.Maximum(), segmentInfo.DocCount)
+		}
+	}
+
+	return nil
+}
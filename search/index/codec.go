@@ -0,0 +1,226 @@
+package index
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// Format versions
+//
+// Each binary file of a segment and of the deleted docs starts with a header:
+//
+//   - [0] magic (uint32)
+//   - [4] codec id length (byte)
+//   - [5] codec id, the component that wrote the file
+//   - [5 + codec id length] format version (uint32)
+//
+// and, from version 2, ends with a footer:
+//
+//   - [0] footer magic (uint32)
+//   - [4] CRC32C of the file up to the checksum (uint32)
+//
+// The segment info and the commit, in JSON, have a version field instead.
+// The format version changes whenever the layout of a file changes, so that
+// readers know how to decode it. Files written before the headers have no
+// header and are version 0, files written before the footers are version 1:
+// they are still read, and rewritten in the current format by
+// IndexWriter.Upgrade. The version of the files of a segment is the version
+// of its info, and the version of the deleted files is in the commit, so that
+// a file of a newer version without its header is reported as corrupted
+// rather than read as version 0.
+//
+// Readers only check the header and the footer. The checksums are verified
+// by CheckIndex, which reads the whole files.
+
+const (
+	codecMagic       uint32 = 0x4c594e58 // LYNX
+	codecFooterMagic uint32 = ^codecMagic
+	codecFooterSize         = 8
+
+	// Version of the files written by this version. All the older versions
+	// are readable, down to version 0, whose files have no header.
+	formatVersion uint32 = 2
+	// First version with a footer
+	footerFormatVersion uint32 = 2
+)
+
+const (
+	deletedCodec     = "LynxDeleted"
+	dictionaryCodec  = "LynxDictionary"
+	docValuesCodec   = "LynxDocValues"
+	frequenciesCodec = "LynxFrequencies"
+	lengthsCodec     = "LynxLengths"
+	offsetsCodec     = "LynxOffsets"
+	positionsCodec   = "LynxPositions"
+	statsCodec       = "LynxStats"
+	storeCodec       = "LynxStore"
+)
+
+// ErrUnsupportedFormat is returned when a file of the index was written in a
+// format version this version can't read, e.g. by a newer version.
+var ErrUnsupportedFormat = errors.New("unsupported index format")
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+func writeCodecHeader(writer io.Writer, codec string) error {
+	header := make([]byte, 0, 9+len(codec))
+	header = binary.BigEndian.AppendUint32(header, codecMagic)
+	header = append(header, byte(len(codec)))
+	header = append(header, codec...)
+	header = binary.BigEndian.AppendUint32(header, formatVersion)
+
+	_, err := writer.Write(header)
+	return err
+}
+
+// Returns the codec, the format version and the size of the header of the
+// file
+func parseCodecHeader(filename string, data []byte) (string, uint32, int, error) {
+	if len(data) < 5 || binary.BigEndian.Uint32(data) != codecMagic {
+		return "", 0, 0, fmt.Errorf("%s: missing header", filename)
+	}
+
+	codecLength := int(data[4])
+	if len(data) < 9+codecLength {
+		return "", 0, 0, fmt.Errorf("%s: truncated header", filename)
+	}
+
+	return string(data[5 : 5+codecLength]), binary.BigEndian.Uint32(data[5+codecLength:]), 9 + codecLength, nil
+}
+
+// readCodecFile checks the header and the footer of the file and returns the
+// data between them. version is the format version of the file, from the
+// segment info or the commit: files of version 0 have no header and are
+// returned as is, the other ones must have a header of the same version.
+func readCodecFile(filename string, data []byte, codec string, version uint32) ([]byte, error) {
+	if version == 0 {
+		return data, nil
+	}
+
+	fileCodec, fileVersion, headerSize, err := parseCodecHeader(filename, data)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkFormatVersion(filename, fileVersion); err != nil {
+		return nil, err
+	}
+
+	if fileVersion != version {
+		return nil, fmt.Errorf("%s: version %d, expected %d", filename, fileVersion, version)
+	}
+
+	if fileCodec != codec {
+		return nil, fmt.Errorf("%s: codec %s, expected %s", filename, fileCodec, codec)
+	}
+
+	if version < footerFormatVersion {
+		return data[headerSize:], nil
+	}
+
+	if len(data) < headerSize+codecFooterSize || binary.BigEndian.Uint32(data[len(data)-codecFooterSize:]) != codecFooterMagic {
+		return nil, fmt.Errorf("%s: missing footer", filename)
+	}
+
+	return data[headerSize : len(data)-codecFooterSize], nil
+}
+
+// verifyChecksum reads the whole file and compares its checksum to the one in
+// its footer. Files written before the footers, of an older version, are not
+// verified.
+func verifyChecksum(filename string, version uint32) error {
+	if version < footerFormatVersion {
+		return nil
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	_, _, headerSize, err := parseCodecHeader(filename, data)
+	if err != nil {
+		return err
+	}
+
+	if len(data) < headerSize+codecFooterSize || binary.BigEndian.Uint32(data[len(data)-codecFooterSize:]) != codecFooterMagic {
+		return fmt.Errorf("%s: missing footer", filename)
+	}
+
+	expected := binary.BigEndian.Uint32(data[len(data)-4:])
+	actual := crc32.Checksum(data[:len(data)-4], crc32cTable)
+
+	if actual != expected {
+		return fmt.Errorf("%s: checksum mismatch: %08x, expected %08x", filename, actual, expected)
+	}
+
+	return nil
+}
+
+func checkFormatVersion(filename string, version uint32) error {
+	if version > formatVersion {
+		return fmt.Errorf("%s: %w: version %d, supported versions are 0 to %d", filename, ErrUnsupportedFormat, version, formatVersion)
+	}
+
+	return nil
+}
+
+// codecFileWriter writes a new file with a header and a footer. The checksum
+// is computed as the file is written.
+type codecFileWriter struct {
+	file   *os.File
+	hash   hash.Hash32
+	writer *bufio.Writer
+}
+
+func createCodecFile(filename, codec string) (*codecFileWriter, error) {
+	file, err := createFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := crc32.New(crc32cTable)
+
+	writer := &codecFileWriter{
+		file:   file,
+		hash:   hash,
+		writer: bufio.NewWriter(io.MultiWriter(file, hash)),
+	}
+
+	if err := writeCodecHeader(writer.writer, codec); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	return writer, nil
+}
+
+func (writer *codecFileWriter) Write(p []byte) (int, error) {
+	return writer.writer.Write(p)
+}
+
+// Close writes the footer, then syncs and closes the file
+func (writer *codecFileWriter) Close() error {
+	if err := binary.Write(writer.writer, binary.BigEndian, codecFooterMagic); err != nil {
+		_ = writer.file.Close()
+		return err
+	}
+
+	if err := writer.writer.Flush(); err != nil {
+		_ = writer.file.Close()
+		return err
+	}
+
+	if err := binary.Write(writer.file, binary.BigEndian, writer.hash.Sum32()); err != nil {
+		_ = writer.file.Close()
+		return err
+	}
+
+	return closeSyncedFile(writer.file)
+}
@@ -0,0 +1,327 @@
+package index
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func initCodecTestIndex(t *testing.T, directory string) {
+	os.RemoveAll(directory)
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	indexWriter, err := NewIndexWriter(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer indexWriter.Close()
+
+	indexWriter.SetMergePolicy(&NoMergePolicy{})
+
+	for _, firstId := range []uint64{0, 20} {
+		if err := indexWriter.AddDocuments(crashTestDocuments(firstId, 20)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := indexWriter.DeleteDocuments("id", [][]byte{binary.BigEndian.AppendUint64(nil, 7)}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Rewrites the index as written before the format versions: without headers
+// nor version fields
+func downgradeToVersion0(t *testing.T, directory string) {
+	for _, name := range listFiles(directory) {
+		path := filepath.Join(directory, name)
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if name == "commit" || strings.HasSuffix(name, ".info") {
+			var fields map[string]any
+			if err := json.Unmarshal(data, &fields); err != nil {
+				t.Fatal(err)
+			}
+
+			delete(fields, "version")
+			delete(fields, "deletedVersion")
+
+			data, err = json.Marshal(fields)
+			if err != nil {
+				t.Fatal(err)
+			}
+		} else if len(data) >= 5 && binary.BigEndian.Uint32(data) == codecMagic {
+			data = data[9+int(data[4]) : len(data)-codecFooterSize]
+		} else {
+			continue
+		}
+
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Copies testdata/v0, an index written by the version before the format
+// versions, segment infos and positions, to directory
+func copyVersion0TestIndex(t *testing.T, directory string) {
+	os.RemoveAll(directory)
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	source := filepath.Join("testdata", "v0")
+
+	for _, name := range listFiles(source) {
+		data, err := os.ReadFile(filepath.Join(source, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(filepath.Join(directory, name), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readTestIndexIds(t *testing.T, directory string) []uint64 {
+	indexReader, err := NewIndexReader(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer indexReader.Close()
+
+	return readLiveIds(t, indexReader)
+}
+
+func TestCodecUpgrade(t *testing.T) {
+	directory := filepath.Join("testdata", "upgrade")
+	initCodecTestIndex(t, directory)
+
+	ids := readTestIndexIds(t, directory)
+	assert.Len(t, ids, 39)
+
+	downgradeToVersion0(t, directory)
+
+	// Version 0 is still read
+	assert.Equal(t, ids, readTestIndexIds(t, directory))
+
+	indexWriter, err := NewIndexWriter(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer indexWriter.Close()
+
+	if err := indexWriter.Upgrade(); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, ids, readTestIndexIds(t, directory))
+
+	for _, name := range listFiles(directory) {
+		data, err := os.ReadFile(filepath.Join(directory, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		switch {
+		case name == "commit" || strings.HasSuffix(name, ".info"):
+			assert.Contains(t, string(data), fmt.Sprintf(`"version":%d`, formatVersion), name)
+		case strings.HasPrefix(name, "segment.") || strings.HasPrefix(name, "deleted."):
+			assert.Equal(t, codecMagic, binary.BigEndian.Uint32(data), name)
+		}
+	}
+}
+
+func TestCodecUnsupportedVersion(t *testing.T) {
+	directory := filepath.Join("testdata", "unsupported")
+	initCodecTestIndex(t, directory)
+
+	commitPath := filepath.Join(directory, "commit")
+
+	commitData, err := os.ReadFile(commitPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Commit of a newer version
+	newerCommitData := strings.Replace(string(commitData), fmt.Sprintf(`"version":%d`, formatVersion), fmt.Sprintf(`"version":%d`, formatVersion+1), 1)
+	if err := os.WriteFile(commitPath, []byte(newerCommitData), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewIndexReader(directory)
+	assert.ErrorIs(t, err, ErrUnsupportedFormat)
+
+	if err := os.WriteFile(commitPath, commitData, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	frequenciesPath := firstSegmentPath(t, directory, ".body.frequencies")
+
+	// Frequencies of a newer version
+	frequencies, err := os.ReadFile(frequenciesPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	versionOffset := 5 + len(frequenciesCodec)
+	binary.BigEndian.PutUint32(frequencies[versionOffset:], formatVersion+1)
+
+	if err := os.WriteFile(frequenciesPath, frequencies, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewIndexReader(directory)
+	assert.ErrorIs(t, err, ErrUnsupportedFormat)
+
+	// Positions instead of frequencies
+	positions, err := os.ReadFile(firstSegmentPath(t, directory, ".body.positions"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(frequenciesPath, positions, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewIndexReader(directory)
+	assert.ErrorContains(t, err, "codec LynxPositions, expected LynxFrequencies")
+}
+
+func TestDeriveSegmentInfo(t *testing.T) {
+	directory := filepath.Join("testdata", "derive")
+	copyVersion0TestIndex(t, directory)
+
+	segmentInfo, err := readSegmentInfo(directory, "887699001")
+	if assert.NoError(t, err) {
+		assert.Equal(t, &SegmentInfo{
+			DocCount:          3,
+			Fields:            []string{"body", "id", "title"},
+			NoPositionsFields: []string{"body", "id", "title"},
+			Analyzers:         map[string]string{"body": StandardV0AnalyzerName, "id": StandardV0AnalyzerName, "title": StandardV0AnalyzerName},
+		}, segmentInfo)
+	}
+
+	segmentInfo, err = readSegmentInfo(directory, "2172391158")
+	if assert.NoError(t, err) {
+		assert.Equal(t, &SegmentInfo{
+			DocCount:          2,
+			Fields:            []string{"body", "id"},
+			NoPositionsFields: []string{"body", "id"},
+			Analyzers:         map[string]string{"body": StandardV0AnalyzerName, "id": StandardV0AnalyzerName},
+		}, segmentInfo)
+	}
+
+	_, err = readSegmentInfo(directory, "1")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// The info of a segment with headers is not derived
+	initCodecTestIndex(t, directory)
+
+	commit, err := readCommit(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	segment := fmt.Sprint(commit.SegmentIds[0])
+	if err := os.Remove(filepath.Join(directory, "segment."+segment+".info")); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = readSegmentInfo(directory, segment)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestCodecVersion0Index(t *testing.T) {
+	directory := filepath.Join("testdata", "version0")
+	copyVersion0TestIndex(t, directory)
+
+	// Doc 1 is deleted
+	assert.Equal(t, []uint64{0, 2, 3, 4}, readTestIndexIds(t, directory))
+	assert.NoError(t, CheckIndex(directory))
+
+	indexReader, err := NewIndexReader(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	docIds, err := indexReader.SearchByExactValues("id", [][]byte{binary.BigEndian.AppendUint64(nil, 3)})
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{ToGlobalDocId(2172391158, 0)}, docIds)
+
+	value, err := indexReader.Value("body", ToGlobalDocId(887699001, 2))
+	assert.NoError(t, err)
+	assert.Equal(t, "A quick bird flies over the brown fox", string(value))
+
+	if err := indexReader.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	indexWriter, err := NewIndexWriter(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer indexWriter.Close()
+
+	if err := indexWriter.Upgrade(); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, []uint64{0, 2, 3, 4}, readTestIndexIds(t, directory))
+	assert.NoError(t, CheckIndex(directory))
+
+	commit, err := readCommit(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, formatVersion, commit.deletedVersion())
+
+	for _, segmentId := range commit.SegmentIds {
+		segmentInfo, err := readSegmentInfo(directory, fmt.Sprint(segmentId))
+		if assert.NoError(t, err) {
+			assert.Equal(t, formatVersion, segmentInfo.Version)
+			// The positions are not recovered
+			assert.False(t, segmentInfo.HasPositions("body"))
+		}
+	}
+}
+
+// A file of a newer version that lost its header is not read as version 0
+func TestCodecMissingHeader(t *testing.T) {
+	directory := filepath.Join("testdata", "missing_header")
+	initCodecTestIndex(t, directory)
+
+	path := firstSegmentPath(t, directory, ".body.frequencies")
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	binary.BigEndian.PutUint32(data, 0)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.ErrorContains(t, CheckIndex(directory), "body.frequencies: missing header")
+
+	_, err = NewIndexReader(directory)
+	assert.ErrorContains(t, err, "body.frequencies: missing header")
+}
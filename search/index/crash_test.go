@@ -0,0 +1,316 @@
+package index
+
+import (
+	"encoding/binary"
+	"errors"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errCrash = errors.New("crash")
+
+// crashSimulator counts the syncs and renames of the writer and panics at the
+// crashAt-th one. Then powerLoss undoes what was not durable at that point:
+// the files that were not synced are truncated, the names that were not
+// synced in the directory may be gone and the last rename may be lost.
+type crashSimulator struct {
+	random    *rand.Rand
+	directory string
+	crashAt   int
+	events    int
+
+	// Names in the directory before the simulation, all synced
+	initialNames map[string]bool
+	syncedFiles  map[string]bool
+	// Names in the directory at the last sync of the directory
+	durableNames map[string]bool
+	// Content of the commit file at the last sync of the directory
+	durableCommit []byte
+}
+
+func newCrashSimulator(random *rand.Rand, directory string, crashAt int) *crashSimulator {
+	simulator := &crashSimulator{
+		random:      random,
+		directory:   directory,
+		crashAt:     crashAt,
+		syncedFiles: make(map[string]bool),
+	}
+
+	simulator.snapshotDirectory()
+	simulator.initialNames = simulator.durableNames
+
+	return simulator
+}
+
+func (s *crashSimulator) snapshotDirectory() {
+	s.durableNames = make(map[string]bool)
+	for _, name := range listFiles(s.directory) {
+		s.durableNames[name] = true
+	}
+
+	commit, err := os.ReadFile(filepath.Join(s.directory, "commit"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	s.durableCommit = commit
+}
+
+func (s *crashSimulator) event() {
+	s.events++
+	if s.events == s.crashAt {
+		panic(errCrash)
+	}
+}
+
+func (s *crashSimulator) install() func() {
+	previousSyncFile, previousSyncDirectory, previousRenameFile := syncFile, syncDirectory, renameFile
+
+	syncFile = func(file *os.File) error {
+		s.event()
+		s.syncedFiles[filepath.Base(file.Name())] = true
+		return nil
+	}
+
+	syncDirectory = func(directory string) error {
+		s.event()
+		s.snapshotDirectory()
+		return nil
+	}
+
+	renameFile = func(oldPath, newPath string) error {
+		s.event()
+		return os.Rename(oldPath, newPath)
+	}
+
+	return func() {
+		syncFile, syncDirectory, renameFile = previousSyncFile, previousSyncDirectory, previousRenameFile
+	}
+}
+
+func (s *crashSimulator) powerLoss() {
+	for _, name := range listFiles(s.directory) {
+		path := filepath.Join(s.directory, name)
+
+		if name == "commit" {
+			if err := os.WriteFile(path, s.durableCommit, 0600); err != nil {
+				log.Fatal(err)
+			}
+
+			continue
+		}
+
+		if !s.durableNames[name] && s.random.Intn(2) == 0 {
+			if err := os.Remove(path); err != nil {
+				log.Fatal(err)
+			}
+
+			continue
+		}
+
+		if s.initialNames[name] || s.syncedFiles[name] {
+			continue
+		}
+
+		fileInfo, err := os.Stat(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := os.Truncate(path, s.random.Int63n(fileInfo.Size()+1)); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func listFiles(directory string) []string {
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+
+	return names
+}
+
+func crashTestDocuments(firstId uint64, numDocs int) []Document {
+	vocabulary := []string{"a", "b", "c", "d"}
+	docs := make([]Document, 0, numDocs)
+
+	for id := firstId; id < firstId+uint64(numDocs); id++ {
+		idBytes := make([]byte, 8)
+		binary.BigEndian.PutUint64(idBytes, id)
+
+		body := make([]string, 0, 4)
+		for i := 0; i <= int(id%4); i++ {
+			body = append(body, vocabulary[(int(id)+i)%len(vocabulary)])
+		}
+
+		docs = append(docs, Document{
+			{Name: "id", FieldType: ByteFieldType, Value: idBytes},
+			{Name: "body", FieldType: TextFieldType, Value: []byte(strings.Join(body, " "))},
+		})
+	}
+
+	return docs
+}
+
+// Reads every file of the index and returns the ids of the live docs
+func readLiveIds(t *testing.T, indexReader *IndexReader) []uint64 {
+	ids := make([]uint64, 0)
+
+	for _, segmentReader := range indexReader.SegmentReaders {
+		for _, fieldName := range segmentReader.Info.Fields {
+			dictionaryReader, err := segmentReader.DictionaryReader(fieldName)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			fieldFreqsReader, err := segmentReader.FieldFreqsReader(fieldName)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var fieldPositionsReader *FieldPositionsReader
+			if segmentReader.Info.HasPositions(fieldName) {
+				fieldPositionsReader, err = segmentReader.FieldPositionsReader(fieldName)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for i := 0; i < dictionaryReader.kvReader.Len(); i++ {
+				_, value := dictionaryReader.kvReader.At(i)
+				termInfo := decodeTermInfo(value)
+
+				it := fieldFreqsReader.TermFreqsIterator(termInfo)
+				for docId := DocumentId(0); it.Next(docId); docId = it.DocId() + 1 {
+					assert.Less(t, uint32(it.DocId()), segmentReader.Info.DocCount)
+				}
+
+				if fieldPositionsReader == nil {
+					continue
+				}
+
+				positionsIterator := fieldPositionsReader.TermPositionsIterator(fieldFreqsReader.TermFreqsIterator(termInfo), termInfo)
+				for docId := DocumentId(0); positionsIterator.Next(docId); docId = positionsIterator.DocId() + 1 {
+					assert.Len(t, positionsIterator.Positions(), int(positionsIterator.TermFreq()))
+				}
+			}
+		}
+
+		for localDocId := uint32(0); localDocId < segmentReader.Info.DocCount; localDocId++ {
+			if segmentReader.DeletedDocIds.Contains(localDocId) {
+				continue
+			}
+
+			value, err := indexReader.Value("id", ToGlobalDocId(segmentReader.Id, localDocId))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ids = append(ids, binary.BigEndian.Uint64(value))
+		}
+	}
+
+	slices.Sort(ids)
+
+	return ids
+}
+
+func TestCrashDuringCommit(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	directory := filepath.Join("testdata", "crash")
+
+	for iteration := 0; iteration < 100; iteration++ {
+		os.RemoveAll(directory)
+		if err := os.MkdirAll(directory, 0700); err != nil {
+			t.Fatal(err)
+		}
+
+		// Two segments, with deleted docs
+		indexWriter, err := NewIndexWriter(directory)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		indexWriter.SetMergePolicy(&NoMergePolicy{})
+
+		for _, firstId := range []uint64{0, 20} {
+			if err := indexWriter.AddDocuments(crashTestDocuments(firstId, 20)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		deletedIds := make([][]byte, 0)
+		for id := uint64(0); id < 40; id += 3 {
+			deletedIds = append(deletedIds, binary.BigEndian.AppendUint64(nil, id))
+		}
+
+		if err := indexWriter.DeleteDocuments("id", deletedIds); err != nil {
+			t.Fatal(err)
+		}
+
+		indexReader, err := NewIndexReader(directory)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		previousIds := readLiveIds(t, indexReader)
+		indexReader.Close()
+
+		// A new segment, then a merge of the three segments
+		simulator := newCrashSimulator(random, directory, 1+random.Intn(60))
+		uninstall := simulator.install()
+
+		indexWriter.SetMergePolicy(&LogMergePolicy{MergeFactor: 3, MinMergeDocs: 1})
+
+		func() {
+			defer func() {
+				if r := recover(); r != nil && r != errCrash {
+					panic(r)
+				}
+			}()
+
+			if err := indexWriter.AddDocuments(crashTestDocuments(40, 20)); err != nil {
+				t.Fatal(err)
+			}
+		}()
+
+		uninstall()
+
+		if err := indexWriter.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		simulator.powerLoss()
+
+		indexReader, err = NewIndexReader(directory)
+		if err != nil {
+			t.Fatalf("iteration %d, crash at %d: %v", iteration, simulator.crashAt, err)
+		}
+
+		ids := readLiveIds(t, indexReader)
+		indexReader.Close()
+
+		newIds := slices.Clone(previousIds)
+		for id := uint64(40); id < 60; id++ {
+			newIds = append(newIds, id)
+		}
+
+		if !slices.Equal(previousIds, ids) && !slices.Equal(newIds, ids) {
+			t.Fatalf("iteration %d, crash at %d: unexpected ids %v", iteration, simulator.crashAt, ids)
+		}
+	}
+}
@@ -22,7 +22,7 @@ func (writer *DeletedWriter) DeletedDocs(deletedDocIdsBySegment map[uint32]*roar
 }
 
 func (writer *DeletedWriter) Write(directory string, deletedId string) error {
-	kvStoreWriter, err := newKVStoreWriter(filepath.Join(directory, "deleted."+deletedId))
+	kvStoreWriter, err := newKVStoreWriter(filepath.Join(directory, "deleted."+deletedId), deletedCodec)
 	if err != nil {
 		return err
 	}
@@ -50,6 +50,8 @@ func (writer *DeletedWriter) Write(directory string, deletedId string) error {
 
 type DeletedReader interface {
 	GetDeletedDocIdsForSegment(segmentId uint32) (*roaring.Bitmap, error)
+	// Deleted docs of all segments
+	GetDeletedDocIdsBySegment() (map[uint32]*roaring.Bitmap, error)
 }
 
 type NullDeletedReader struct {
@@ -63,12 +65,16 @@ func (reader *NullDeletedReader) GetDeletedDocIdsForSegment(segmentId uint32) (*
 	return nil, nil
 }
 
+func (reader *NullDeletedReader) GetDeletedDocIdsBySegment() (map[uint32]*roaring.Bitmap, error) {
+	return make(map[uint32]*roaring.Bitmap), nil
+}
+
 type FileDeletedReader struct {
 	kvStoreReader *KVStoreReader
 }
 
-func newFileDeletedReader(directory, deletedId string) (*FileDeletedReader, error) {
-	kvStoreReader, err := newKVStoreReader(filepath.Join(directory, "deleted."+deletedId))
+func newFileDeletedReader(directory, deletedId string, version uint32) (*FileDeletedReader, error) {
+	kvStoreReader, err := newKVStoreReader(filepath.Join(directory, "deleted."+deletedId), deletedCodec, version)
 	if err != nil {
 		return nil, err
 	}
@@ -76,6 +82,10 @@ func newFileDeletedReader(directory, deletedId string) (*FileDeletedReader, erro
 	return &FileDeletedReader{kvStoreReader: kvStoreReader}, nil
 }
 
+func (reader *FileDeletedReader) Close() error {
+	return reader.kvStoreReader.Close()
+}
+
 func (reader *FileDeletedReader) GetDeletedDocIdsForSegment(segmentId uint32) (*roaring.Bitmap, error) {
 	value := reader.kvStoreReader.Get(utils.Uint32ToBytes(segmentId))
 	if value == nil {
@@ -90,3 +100,20 @@ func (reader *FileDeletedReader) GetDeletedDocIdsForSegment(segmentId uint32) (*
 
 	return deletedDocs, nil
 }
+
+func (reader *FileDeletedReader) GetDeletedDocIdsBySegment() (map[uint32]*roaring.Bitmap, error) {
+	deletedDocIdsBySegment := make(map[uint32]*roaring.Bitmap, reader.kvStoreReader.Len())
+
+	for i := 0; i < reader.kvStoreReader.Len(); i++ {
+		key, value := reader.kvStoreReader.At(i)
+
+		deletedDocs := roaring.NewBitmap()
+		if err := deletedDocs.UnmarshalBinary(value); err != nil {
+			return nil, err
+		}
+
+		deletedDocIdsBySegment[utils.BytesToUint32(key)] = deletedDocs
+	}
+
+	return deletedDocIdsBySegment, nil
+}
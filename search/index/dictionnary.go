@@ -1,6 +1,7 @@
 package index
 
 import (
+	"bytes"
 	"encoding/binary"
 	"path/filepath"
 )
@@ -9,26 +10,61 @@ type TermInfo struct {
 	DocFreq              uint32
 	FreqsFileStartOffset uint64
 	FreqsFileEndOffset   uint64
+	// Offsets of the term positions in the positions file
+	PositionsFileStartOffset uint64
+	PositionsFileEndOffset   uint64
+	// Offsets of the term offsets in the offsets file, if the field has one
+	OffsetsFileStartOffset uint64
+	OffsetsFileEndOffset   uint64
 }
 
+/*
+Term info:
+  - [0] doc freq (uint32)
+  - [4] frequencies start and end offsets (uint64)
+  - [20] positions start and end offsets (uint64)
+  - [36] offsets start and end offsets (uint64), if the field has offsets
+
+The term infos of the segments written before the positions stop at 20.
+*/
+const (
+	termInfoWithoutPositionsSize = 20
+	termInfoSize                 = 36
+	termInfoWithOffsetsSize      = 52
+)
+
 type DictionaryWriter struct {
 	buffer   []byte
 	kvWriter *KVStoreWriter
 }
 
-func newDictionaryWriter(directory, segmentId, fieldName string) (*DictionaryWriter, error) {
-	writer, err := newKVStoreWriter(filepath.Join(directory, "segment."+segmentId+"."+fieldName+".dictionary"))
+// The term infos include the offsets in the offsets file if offsets is true
+func newDictionaryWriter(directory, segmentId, fieldName string, offsets bool) (*DictionaryWriter, error) {
+	writer, err := newKVStoreWriter(filepath.Join(directory, "segment."+segmentId+"."+fieldName+".dictionary"), dictionaryCodec)
 	if err != nil {
 		return nil, err
 	}
 
-	return &DictionaryWriter{buffer: make([]byte, 20), kvWriter: writer}, err
+	size := termInfoSize
+	if offsets {
+		size = termInfoWithOffsetsSize
+	}
+
+	return &DictionaryWriter{buffer: make([]byte, size), kvWriter: writer}, err
 }
 
 func (writer *DictionaryWriter) Write(term []byte, termInfo *TermInfo) error {
 	binary.BigEndian.PutUint32(writer.buffer, termInfo.DocFreq)
 	binary.BigEndian.PutUint64(writer.buffer[4:], termInfo.FreqsFileStartOffset)
 	binary.BigEndian.PutUint64(writer.buffer[12:], termInfo.FreqsFileEndOffset)
+	binary.BigEndian.PutUint64(writer.buffer[20:], termInfo.PositionsFileStartOffset)
+	binary.BigEndian.PutUint64(writer.buffer[28:], termInfo.PositionsFileEndOffset)
+
+	if len(writer.buffer) == termInfoWithOffsetsSize {
+		binary.BigEndian.PutUint64(writer.buffer[36:], termInfo.OffsetsFileStartOffset)
+		binary.BigEndian.PutUint64(writer.buffer[44:], termInfo.OffsetsFileEndOffset)
+	}
+
 	return writer.kvWriter.Append(term, writer.buffer)
 }
 
@@ -40,8 +76,8 @@ type DictionaryReader struct {
 	kvReader *KVStoreReader
 }
 
-func newDictionaryReader(directory, segmentId, fieldName string) (*DictionaryReader, error) {
-	kvReader, err := newKVStoreReader(filepath.Join(directory, "segment."+segmentId+"."+fieldName+".dictionary"))
+func newDictionaryReader(directory, segmentId, fieldName string, version uint32) (*DictionaryReader, error) {
+	kvReader, err := newKVStoreReader(filepath.Join(directory, "segment."+segmentId+"."+fieldName+".dictionary"), dictionaryCodec, version)
 	if err != nil {
 		return nil, err
 	}
@@ -49,6 +85,10 @@ func newDictionaryReader(directory, segmentId, fieldName string) (*DictionaryRea
 	return &DictionaryReader{kvReader: kvReader}, nil
 }
 
+func (reader *DictionaryReader) Close() error {
+	return reader.kvReader.Close()
+}
+
 func (reader *DictionaryReader) Get(term []byte) *TermInfo {
 	value := reader.kvReader.Get(term)
 
@@ -56,13 +96,64 @@ func (reader *DictionaryReader) Get(term []byte) *TermInfo {
 		return nil
 	}
 
-	docFreq := binary.BigEndian.Uint32(value)
-	freqsStartOffset := binary.BigEndian.Uint64(value[4:])
-	freqsEndOffset := binary.BigEndian.Uint64(value[12:])
+	return decodeTermInfo(value)
+}
+
+// TermsWithPrefix returns the terms starting with prefix, in order. Returns
+// false if there are more than maxTerms of them.
+func (reader *DictionaryReader) TermsWithPrefix(prefix []byte, maxTerms int) ([][]byte, bool) {
+	terms := make([][]byte, 0)
 
-	return &TermInfo{
-		DocFreq:              docFreq,
-		FreqsFileStartOffset: freqsStartOffset,
-		FreqsFileEndOffset:   freqsEndOffset,
+	for i := reader.kvReader.Search(prefix); i < reader.kvReader.Len(); i++ {
+		term, _ := reader.kvReader.At(i)
+		if !bytes.HasPrefix(term, prefix) {
+			break
+		}
+
+		if len(terms) == maxTerms {
+			return nil, false
+		}
+
+		terms = append(terms, term)
 	}
+
+	return terms, true
+}
+
+// TermInfosInRange returns the term infos of the terms from lower to upper,
+// bounds included
+func (reader *DictionaryReader) TermInfosInRange(lower, upper []byte) []*TermInfo {
+	termInfos := make([]*TermInfo, 0)
+
+	for i := reader.kvReader.Search(lower); i < reader.kvReader.Len(); i++ {
+		term, value := reader.kvReader.At(i)
+		if bytes.Compare(term, upper) > 0 {
+			break
+		}
+
+		termInfos = append(termInfos, decodeTermInfo(value))
+	}
+
+	return termInfos
+}
+
+// Decodes a term info of any of the sizes above, depending on its length
+func decodeTermInfo(value []byte) *TermInfo {
+	termInfo := &TermInfo{
+		DocFreq:              binary.BigEndian.Uint32(value),
+		FreqsFileStartOffset: binary.BigEndian.Uint64(value[4:]),
+		FreqsFileEndOffset:   binary.BigEndian.Uint64(value[12:]),
+	}
+
+	if len(value) >= termInfoSize {
+		termInfo.PositionsFileStartOffset = binary.BigEndian.Uint64(value[20:])
+		termInfo.PositionsFileEndOffset = binary.BigEndian.Uint64(value[28:])
+	}
+
+	if len(value) >= termInfoWithOffsetsSize {
+		termInfo.OffsetsFileStartOffset = binary.BigEndian.Uint64(value[36:])
+		termInfo.OffsetsFileEndOffset = binary.BigEndian.Uint64(value[44:])
+	}
+
+	return termInfo
 }
@@ -0,0 +1,463 @@
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+)
+
+// DocValuesType is the layout of the doc values of a field, see
+// Schema.SetFieldDocValues
+type DocValuesType byte
+
+const (
+	NoDocValues DocValuesType = iota
+	// A number per doc: the 8 bytes of the value of a numeric field, read as
+	// a uint64
+	NumericDocValues
+	// A value per doc, with the ordinal of the value among the sorted values
+	// of the segment
+	SortedDocValues
+	// Any number of values per doc, with their ordinals among the sorted
+	// values of the segment
+	SortedSetDocValues
+)
+
+func docValuesPath(directory, segmentId, fieldName string) string {
+	return filepath.Join(directory, "segment."+segmentId+"."+fieldName+".docvalues")
+}
+
+/*
+Doc values file:
+  - [0] type (byte)
+  - [1] doc count (uint32)
+
+Numeric:
+  - [5] bitset of the docs with a value, (doc count + 7) / 8 bytes
+  - Value of each doc (uint64), 0 for the docs without a value
+
+Sorted:
+  - [5] value count (uint32)
+  - [9] Ordinal + 1 of the value of each doc (uint32), 0 for the docs without
+    a value
+  - Values
+
+Sorted set:
+  - [5] value count (uint32)
+  - [9] Start of the ordinals of each doc, and the end of the last one
+    (uint32)
+  - Ordinals of each doc, sorted (uint32)
+  - Values
+
+Values, sorted and unique:
+  - Start of each value, and the end of the last one (uint32)
+  - Values
+*/
+const docValuesHeaderSize = 5
+
+// Writes the doc values of a field. values[docId] are the values of the doc.
+func writeDocValues(directory, segmentId, fieldName string, docValuesType DocValuesType, values [][][]byte) error {
+	docCount := len(values)
+
+	buffer := make([]byte, 0, docValuesHeaderSize+8*docCount)
+	buffer = append(buffer, byte(docValuesType))
+	buffer = binary.BigEndian.AppendUint32(buffer, uint32(docCount))
+
+	switch docValuesType {
+	case NumericDocValues:
+		bitset := make([]byte, (docCount+7)/8)
+		for docId, docValues := range values {
+			if len(docValues) > 0 {
+				bitset[docId/8] |= 1 << (docId % 8)
+			}
+		}
+
+		buffer = append(buffer, bitset...)
+
+		for _, docValues := range values {
+			number := uint64(0)
+			if len(docValues) > 0 {
+				number = binary.BigEndian.Uint64(docValues[0])
+			}
+
+			buffer = binary.BigEndian.AppendUint64(buffer, number)
+		}
+
+	case SortedDocValues, SortedSetDocValues:
+		sortedValues := make([][]byte, 0, docCount)
+		for _, docValues := range values {
+			sortedValues = append(sortedValues, docValues...)
+		}
+
+		slices.SortFunc(sortedValues, bytes.Compare)
+		sortedValues = slices.CompactFunc(sortedValues, bytes.Equal)
+
+		ord := func(value []byte) uint32 {
+			i, _ := slices.BinarySearchFunc(sortedValues, value, bytes.Compare)
+			return uint32(i)
+		}
+
+		buffer = binary.BigEndian.AppendUint32(buffer, uint32(len(sortedValues)))
+
+		if docValuesType == SortedDocValues {
+			for _, docValues := range values {
+				ordPlusOne := uint32(0)
+				if len(docValues) > 0 {
+					ordPlusOne = ord(docValues[0]) + 1
+				}
+
+				buffer = binary.BigEndian.AppendUint32(buffer, ordPlusOne)
+			}
+		} else {
+			docOrds := make([][]uint32, docCount)
+			start := uint32(0)
+
+			for docId, docValues := range values {
+				buffer = binary.BigEndian.AppendUint32(buffer, start)
+
+				for _, value := range docValues {
+					docOrds[docId] = append(docOrds[docId], ord(value))
+				}
+
+				slices.Sort(docOrds[docId])
+				docOrds[docId] = slices.Compact(docOrds[docId])
+				start += uint32(len(docOrds[docId]))
+			}
+
+			buffer = binary.BigEndian.AppendUint32(buffer, start)
+
+			for _, ords := range docOrds {
+				for _, ord := range ords {
+					buffer = binary.BigEndian.AppendUint32(buffer, ord)
+				}
+			}
+		}
+
+		start := uint32(0)
+		for _, value := range sortedValues {
+			buffer = binary.BigEndian.AppendUint32(buffer, start)
+			start += uint32(len(value))
+		}
+
+		buffer = binary.BigEndian.AppendUint32(buffer, start)
+
+		for _, value := range sortedValues {
+			buffer = append(buffer, value...)
+		}
+
+	default:
+		return fmt.Errorf("unknown doc values type %d", docValuesType)
+	}
+
+	file, err := createCodecFile(docValuesPath(directory, segmentId, fieldName), docValuesCodec)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(buffer); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// DocValuesWriter
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+// DocValuesWriter writes the doc values of the fields with a doc values type
+// in the schema
+type DocValuesWriter struct {
+	docCount uint32
+	docId    DocumentId
+	// err is the first invalid value, returned by Write
+	err    error
+	schema *Schema
+	// values[fieldName][docId]
+	values map[string][][][]byte
+}
+
+func newDocValuesWriter(schema *Schema) *DocValuesWriter {
+	return &DocValuesWriter{
+		schema: schema,
+		values: make(map[string][][][]byte),
+	}
+}
+
+func (writer *DocValuesWriter) Doc(docId DocumentId) {
+	writer.docId = docId
+	writer.docCount = uint32(docId) + 1
+}
+
+func (writer *DocValuesWriter) Field(fieldName string, value []byte) {
+	docValuesType := writer.schema.FieldDocValues(fieldName)
+	if docValuesType == NoDocValues || writer.err != nil {
+		return
+	}
+
+	values := writer.values[fieldName]
+	for len(values) <= int(writer.docId) {
+		values = append(values, nil)
+	}
+
+	writer.values[fieldName] = values
+
+	switch {
+	case docValuesType == NumericDocValues && len(value) != 8:
+		writer.err = fmt.Errorf("field %s: numeric doc value of %d bytes, expected 8", fieldName, len(value))
+	case docValuesType != SortedSetDocValues && len(values[writer.docId]) > 0:
+		writer.err = fmt.Errorf("field %s: doc %d has more than one value", fieldName, writer.docId)
+	default:
+		values[writer.docId] = append(values[writer.docId], value)
+	}
+}
+
+func (writer *DocValuesWriter) EndField() {
+}
+
+func (writer *DocValuesWriter) Term(token *Token) {
+}
+
+func (writer *DocValuesWriter) Write(directory, segmentId string) error {
+	if writer.err != nil {
+		return writer.err
+	}
+
+	for fieldName, values := range writer.values {
+		// Docs after the last doc with the field have no values
+		for len(values) < int(writer.docCount) {
+			values = append(values, nil)
+		}
+
+		if err := writeDocValues(directory, segmentId, fieldName, writer.schema.FieldDocValues(fieldName), values); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// DocValuesReader
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+var errInvalidDocValues = errors.New("invalid doc values")
+
+// DocValuesReader reads the doc values of a field of a segment. Each method
+// must only be called for the doc values types given in its comment.
+type DocValuesReader struct {
+	Type       DocValuesType
+	docCount   uint32
+	fileReader FileReader
+
+	// Numeric
+	bitset  []byte
+	numbers []byte
+
+	// Sorted and sorted set
+	docStarts   []byte
+	ords        []byte
+	valueCount  uint32
+	valueStarts []byte
+	values      []byte
+}
+
+func newDocValuesReader(directory, segmentId, fieldName string, version uint32) (*DocValuesReader, error) {
+	fileReader, err := newFileReader(docValuesPath(directory, segmentId, fieldName), docValuesCodec, version)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := &DocValuesReader{fileReader: *fileReader}
+
+	if err := reader.parse(fileReader.data); err != nil {
+		_ = fileReader.Close()
+		return nil, fmt.Errorf("%s: %w", docValuesPath(directory, segmentId, fieldName), err)
+	}
+
+	return reader, nil
+}
+
+// Splits the data in its sections, checking their lengths
+func (reader *DocValuesReader) parse(data []byte) error {
+	// Returns the next n bytes of data
+	next := func(n uint64) ([]byte, error) {
+		if uint64(len(data)) < n {
+			return nil, errInvalidDocValues
+		}
+
+		section := data[:n]
+		data = data[n:]
+
+		return section, nil
+	}
+
+	header, err := next(docValuesHeaderSize)
+	if err != nil {
+		return err
+	}
+
+	reader.Type = DocValuesType(header[0])
+	reader.docCount = binary.BigEndian.Uint32(header[1:])
+	docCount := uint64(reader.docCount)
+
+	switch reader.Type {
+	case NumericDocValues:
+		if reader.bitset, err = next((docCount + 7) / 8); err != nil {
+			return err
+		}
+
+		if reader.numbers, err = next(8 * docCount); err != nil {
+			return err
+		}
+
+	case SortedDocValues, SortedSetDocValues:
+		valueCount, err := next(4)
+		if err != nil {
+			return err
+		}
+
+		reader.valueCount = binary.BigEndian.Uint32(valueCount)
+
+		if reader.Type == SortedDocValues {
+			if reader.ords, err = next(4 * docCount); err != nil {
+				return err
+			}
+
+			for docId := uint64(0); docId < docCount; docId++ {
+				if binary.BigEndian.Uint32(reader.ords[4*docId:]) > reader.valueCount {
+					return errInvalidDocValues
+				}
+			}
+		} else {
+			if reader.docStarts, err = next(4 * (docCount + 1)); err != nil {
+				return err
+			}
+
+			ordCount := uint64(binary.BigEndian.Uint32(reader.docStarts[4*docCount:]))
+			if reader.ords, err = next(4 * ordCount); err != nil {
+				return err
+			}
+
+			for docId := uint64(0); docId < docCount; docId++ {
+				if binary.BigEndian.Uint32(reader.docStarts[4*docId:]) > binary.BigEndian.Uint32(reader.docStarts[4*(docId+1):]) {
+					return errInvalidDocValues
+				}
+			}
+
+			for i := uint64(0); i < ordCount; i++ {
+				if binary.BigEndian.Uint32(reader.ords[4*i:]) >= reader.valueCount {
+					return errInvalidDocValues
+				}
+			}
+		}
+
+		if reader.valueStarts, err = next(4 * (uint64(reader.valueCount) + 1)); err != nil {
+			return err
+		}
+
+		valuesLength := uint64(binary.BigEndian.Uint32(reader.valueStarts[4*reader.valueCount:]))
+		if reader.values, err = next(valuesLength); err != nil {
+			return err
+		}
+
+		for ord := uint64(0); ord < uint64(reader.valueCount); ord++ {
+			if binary.BigEndian.Uint32(reader.valueStarts[4*ord:]) > binary.BigEndian.Uint32(reader.valueStarts[4*(ord+1):]) {
+				return errInvalidDocValues
+			}
+		}
+
+	default:
+		return fmt.Errorf("unknown doc values type %d", reader.Type)
+	}
+
+	if len(data) > 0 {
+		return errInvalidDocValues
+	}
+
+	return nil
+}
+
+func (reader *DocValuesReader) Close() error {
+	return reader.fileReader.Close()
+}
+
+// Numeric returns the value of the doc, or false if it has none. Numeric doc
+// values.
+func (reader *DocValuesReader) Numeric(docId DocumentId) (uint64, bool) {
+	if reader.bitset[docId/8]&(1<<(docId%8)) == 0 {
+		return 0, false
+	}
+
+	return binary.BigEndian.Uint64(reader.numbers[8*uint64(docId):]), true
+}
+
+// Ord returns the ordinal of the value of the doc, or false if it has none.
+// Sorted doc values.
+func (reader *DocValuesReader) Ord(docId DocumentId) (uint32, bool) {
+	ordPlusOne := binary.BigEndian.Uint32(reader.ords[4*uint64(docId):])
+	return ordPlusOne - 1, ordPlusOne > 0
+}
+
+// Ords appends the ordinals of the values of the doc to ords, in increasing
+// order. Sorted set doc values.
+func (reader *DocValuesReader) Ords(docId DocumentId, ords []uint32) []uint32 {
+	start := binary.BigEndian.Uint32(reader.docStarts[4*uint64(docId):])
+	end := binary.BigEndian.Uint32(reader.docStarts[4*(uint64(docId)+1):])
+
+	for i := start; i < end; i++ {
+		ords = append(ords, binary.BigEndian.Uint32(reader.ords[4*uint64(i):]))
+	}
+
+	return ords
+}
+
+// ValueCount returns the number of distinct values of the segment. Sorted and
+// sorted set doc values.
+func (reader *DocValuesReader) ValueCount() uint32 {
+	return reader.valueCount
+}
+
+// Value returns the value of an ordinal. The value is valid until the reader
+// is closed. Sorted and sorted set doc values.
+func (reader *DocValuesReader) Value(ord uint32) []byte {
+	start := binary.BigEndian.Uint32(reader.valueStarts[4*uint64(ord):])
+	end := binary.BigEndian.Uint32(reader.valueStarts[4*(uint64(ord)+1):])
+
+	return reader.values[start:end]
+}
+
+// Values returns the values of the doc, in increasing order, whatever the
+// doc values type. The values of numeric doc values are their 8 bytes.
+func (reader *DocValuesReader) Values(docId DocumentId) [][]byte {
+	switch reader.Type {
+	case NumericDocValues:
+		if _, ok := reader.Numeric(docId); !ok {
+			return nil
+		}
+
+		return [][]byte{reader.numbers[8*uint64(docId) : 8*uint64(docId)+8]}
+
+	case SortedDocValues:
+		ord, ok := reader.Ord(docId)
+		if !ok {
+			return nil
+		}
+
+		return [][]byte{reader.Value(ord)}
+
+	default:
+		ords := reader.Ords(docId, nil)
+		values := make([][]byte, len(ords))
+
+		for i, ord := range ords {
+			values[i] = reader.Value(ord)
+		}
+
+		return values
+	}
+}
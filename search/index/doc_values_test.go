@@ -0,0 +1,160 @@
+package index
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func docValuesTestDocument(id int64, category string, tags ...string) Document {
+	doc := Document{
+		{Name: "id", FieldType: ByteFieldType, Value: EncodeInt64(id)},
+	}
+
+	// Docs with an odd id have no price
+	if id%2 == 0 {
+		doc = append(doc, Field{Name: "price", FieldType: Int64FieldType, Value: EncodeInt64(-id)})
+	}
+
+	if category != "" {
+		doc = append(doc, Field{Name: "category", FieldType: ByteFieldType, Value: []byte(category)})
+	}
+
+	for _, tag := range tags {
+		doc = append(doc, Field{Name: "tags", FieldType: ByteFieldType, Value: []byte(tag)})
+	}
+
+	return doc
+}
+
+// Returns the doc values of the docs of the segment, as strings
+func readDocValues(t *testing.T, segmentReader *SegmentReader, fieldName string) [][]string {
+	docValuesReader, err := segmentReader.DocValuesReader(fieldName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	docValues := make([][]string, segmentReader.Info.DocCount)
+	for docId := range docValues {
+		for _, value := range docValuesReader.Values(DocumentId(docId)) {
+			docValues[docId] = append(docValues[docId], string(value))
+		}
+	}
+
+	return docValues
+}
+
+func TestDocValues(t *testing.T) {
+	directory := filepath.Join("testdata", "doc_values")
+	os.RemoveAll(directory)
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	indexWriter, err := NewIndexWriter(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer indexWriter.Close()
+
+	indexWriter.SetMergePolicy(&NoMergePolicy{})
+
+	schema := NewSchema(StandardAnalyzerName)
+	schema.SetFieldDocValues("price", NumericDocValues)
+	schema.SetFieldDocValues("category", SortedDocValues)
+	schema.SetFieldDocValues("tags", SortedSetDocValues)
+	indexWriter.SetSchema(schema)
+
+	if err := indexWriter.AddDocuments([]Document{
+		docValuesTestDocument(0, "shoes", "red", "blue", "red"),
+		docValuesTestDocument(1, "hats"),
+		docValuesTestDocument(2, "", "green"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := indexWriter.AddDocuments([]Document{
+		docValuesTestDocument(3, "coats", "blue"),
+		docValuesTestDocument(4, "hats", "yellow"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	indexReader, err := NewIndexReader(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer indexReader.Close()
+
+	segmentReader := indexReader.SegmentReaders[0]
+	assert.Equal(t, []string{"category", "price", "tags"}, segmentReader.Info.DocValuesFields)
+
+	price, err := segmentReader.DocValuesReader("price")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value, ok := price.Numeric(2)
+	assert.True(t, ok)
+	assert.Equal(t, binary.BigEndian.Uint64(EncodeInt64(-2)), value)
+
+	_, ok = price.Numeric(1)
+	assert.False(t, ok)
+
+	category, err := segmentReader.DocValuesReader("category")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Ordinals follow the order of the values
+	assert.Equal(t, uint32(2), category.ValueCount())
+	ord, ok := category.Ord(0)
+	assert.True(t, ok)
+	assert.Equal(t, "shoes", string(category.Value(ord)))
+	ord, _ = category.Ord(1)
+	assert.Equal(t, uint32(0), ord)
+	_, ok = category.Ord(2)
+	assert.False(t, ok)
+
+	tags, err := segmentReader.DocValuesReader("tags")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, []uint32{0, 2}, tags.Ords(0, nil))
+	assert.Equal(t, [][]string{{"blue", "red"}, nil, {"green"}}, readDocValues(t, segmentReader, "tags"))
+
+	// Merged without the deleted docs
+	if err := indexWriter.DeleteDocuments("id", [][]byte{EncodeInt64(1)}); err != nil {
+		t.Fatal(err)
+	}
+
+	indexWriter.SetMergePolicy(&LogMergePolicy{MergeFactor: 2, MinMergeDocs: 10})
+	if err := indexWriter.Merge(); err != nil {
+		t.Fatal(err)
+	}
+
+	indexReader, err = indexReader.Reopen()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer indexReader.Close()
+
+	assert.Len(t, indexReader.SegmentReaders, 1)
+	segmentReader = indexReader.SegmentReaders[0]
+
+	assert.Equal(t, [][]string{{string(EncodeInt64(0))}, {string(EncodeInt64(-2))}, nil, {string(EncodeInt64(-4))}}, readDocValues(t, segmentReader, "price"))
+	assert.Equal(t, [][]string{{"shoes"}, nil, {"coats"}, {"hats"}}, readDocValues(t, segmentReader, "category"))
+	assert.Equal(t, [][]string{{"blue", "red"}, {"green"}, {"blue"}, {"yellow"}}, readDocValues(t, segmentReader, "tags"))
+	assert.NoError(t, CheckIndex(directory))
+
+	// A single value per doc
+	err = indexWriter.AddDocuments([]Document{docValuesTestDocument(5, "hats"), append(docValuesTestDocument(7, "hats"), Field{Name: "category", FieldType: ByteFieldType, Value: []byte("coats")})})
+	assert.EqualError(t, err, "field category: doc 1 has more than one value")
+}
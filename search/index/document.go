@@ -7,6 +7,11 @@ type DocumentId uint32
 const (
 	TextFieldType FieldType = iota
 	ByteFieldType
+	// Numeric fields, with values encoded by EncodeInt64, EncodeFloat64 and
+	// EncodeDate. They can be matched by ranges of values.
+	Int64FieldType
+	Float64FieldType
+	DateFieldType
 )
 
 type Field struct {
@@ -1,8 +1,10 @@
 package index
 
 import (
+	"errors"
 	"fmt"
 	"path/filepath"
+	"sync"
 )
 
 type FieldLengthReader struct {
@@ -23,22 +25,41 @@ func (reader *FieldLengthReader) GetId(docId DocumentId) (byte, error) {
 type DocFieldLengthReader struct {
 	directory         string
 	arrayStoreReaders map[string]*ArrayStoreReader
+	mutex             sync.Mutex
 	segmentId         string
+	version           uint32
 }
 
-func newDocFieldLengthReader(directory, segmentId string) *DocFieldLengthReader {
+func newDocFieldLengthReader(directory, segmentId string, version uint32) *DocFieldLengthReader {
 	return &DocFieldLengthReader{
 		directory:         directory,
 		arrayStoreReaders: make(map[string]*ArrayStoreReader, 100),
 		segmentId:         segmentId,
+		version:           version,
 	}
 }
 
+func (reader *DocFieldLengthReader) Close() error {
+	reader.mutex.Lock()
+	defer reader.mutex.Unlock()
+
+	var err error
+
+	for _, arrayStoreReader := range reader.arrayStoreReaders {
+		err = errors.Join(err, arrayStoreReader.Close())
+	}
+
+	return err
+}
+
 func (reader *DocFieldLengthReader) FieldLengthReader(fieldName string) (*FieldLengthReader, error) {
+	reader.mutex.Lock()
+	defer reader.mutex.Unlock()
+
 	arrayStoreReader, exists := reader.arrayStoreReaders[fieldName]
 	if !exists {
 		var err error
-		arrayStoreReader, err = newArrayStoreReader(filepath.Join(reader.directory, "segment."+reader.segmentId+"."+fieldName+".lengths"), 1)
+		arrayStoreReader, err = newArrayStoreReader(filepath.Join(reader.directory, "segment."+reader.segmentId+"."+fieldName+".lengths"), lengthsCodec, 1, reader.version)
 		if err != nil {
 			return nil, err
 		}
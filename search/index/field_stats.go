@@ -2,16 +2,18 @@ package index
 
 import (
 	"encoding/binary"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 )
 
 type FieldStatsWriter struct {
-	file *os.File
+	file *codecFileWriter
 }
 
 func newFieldStatsWriter(directory, segment, fieldName string) (*FieldStatsWriter, error) {
-	file, err := createFile(filepath.Join(directory, "segment."+segment+"."+fieldName+".stats"))
+	file, err := createCodecFile(filepath.Join(directory, "segment."+segment+"."+fieldName+".stats"), statsCodec)
 	if err != nil {
 		return nil, err
 	}
@@ -31,29 +33,46 @@ func (writer *FieldStatsWriter) Write(docCount uint32, sumTermFreq uint64) error
 	return err
 }
 
+func (writer *FieldStatsWriter) Close() error {
+	return writer.file.Close()
+}
+
 type FieldStatsReader struct {
-	file *os.File
+	file    *os.File
+	version uint32
 }
 
-func newFieldStatsReader(directory, segment, fieldName string) (*FieldStatsReader, error) {
+func newFieldStatsReader(directory, segment, fieldName string, version uint32) (*FieldStatsReader, error) {
 	file, err := os.Open(filepath.Join(directory, "segment."+segment+"."+fieldName+".stats"))
 	if err != nil {
 		return nil, err
 	}
 
 	return &FieldStatsReader{
-		file: file,
+		file:    file,
+		version: version,
 	}, nil
 }
 
+func (reader *FieldStatsReader) Close() error {
+	return reader.file.Close()
+}
+
 func (reader *FieldStatsReader) Read() (uint32, uint64, error) {
-	buffer := make([]byte, 12)
+	data, err := io.ReadAll(reader.file)
+	if err != nil {
+		return 0, 0, err
+	}
 
-	_, err := reader.file.Read(buffer)
+	buffer, err := readCodecFile(reader.file.Name(), data, statsCodec, reader.version)
 	if err != nil {
 		return 0, 0, err
 	}
 
+	if len(buffer) < 12 {
+		return 0, 0, fmt.Errorf("%s: truncated stats", reader.file.Name())
+	}
+
 	docCount := binary.BigEndian.Uint32(buffer)
 	sumTermFreq := binary.BigEndian.Uint64(buffer[4:])
 	return docCount, sumTermFreq, nil
@@ -10,6 +10,75 @@ func createFile(filename string) (*os.File, error) {
 	return os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
 }
 
+// Durability
+//
+// A commit only references files that are on the disk: the files of the new
+// segments and deleted docs are synced when they are closed, then the
+// directory is synced so that their names are on the disk too. Then the
+// commit is written to .commit, synced, renamed to commit and the directory is
+// synced again. A crash at any point leaves either the previous or the new
+// commit, with all its files.
+//
+// syncFile, syncDirectory and renameFile are variables so that tests can
+// simulate crashes.
+
+var syncFile = func(file *os.File) error {
+	return file.Sync()
+}
+
+var syncDirectory = func(directory string) error {
+	file, err := os.Open(directory)
+	if err != nil {
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+var renameFile = os.Rename
+
+// closeSyncedFile syncs the file to the disk, then closes it
+func closeSyncedFile(file *os.File) error {
+	if err := syncFile(file); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// closeMappedFile unmaps data, then closes file
+func closeMappedFile(data mmap.MMap, file *os.File) error {
+	if len(data) > 0 {
+		if err := data.Unmap(); err != nil {
+			_ = file.Close()
+			return err
+		}
+	}
+
+	return file.Close()
+}
+
+// mapFile maps the whole file in memory. An empty file can't be mapped, so it
+// gives an empty mapping.
+func mapFile(file *os.File) (mmap.MMap, error) {
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if fileInfo.Size() == 0 {
+		return mmap.MMap{}, nil
+	}
+
+	return mmap.Map(file, mmap.RDONLY, 0)
+}
+
 // type FileWriter struct {
 // 	offset uint64
 // 	file   *os.File
@@ -40,28 +109,41 @@ func createFile(filename string) (*os.File, error) {
 // }
 
 type FileReader struct {
-	data mmap.MMap
-	file *os.File
+	// After the header
+	data    []byte
+	dataMap mmap.MMap
+	file    *os.File
 }
 
-func newFileReader(filename string) (*FileReader, error) {
+func newFileReader(filename, codec string, version uint32) (*FileReader, error) {
 	file, err := os.Open(filename)
 	if err != nil {
 		return nil, err
 	}
 
-	data, err := mmap.Map(file, mmap.RDONLY, 0)
+	dataMap, err := mapFile(file)
 	if err != nil {
 		_ = file.Close()
 		return nil, err
 	}
 
+	data, err := readCodecFile(filename, dataMap, codec, version)
+	if err != nil {
+		_ = closeMappedFile(dataMap, file)
+		return nil, err
+	}
+
 	return &FileReader{
-		data: data,
-		file: file,
+		data:    data,
+		dataMap: dataMap,
+		file:    file,
 	}, nil
 }
 
 func (reader *FileReader) Slice(start, end uint64) []byte {
 	return reader.data[start:end]
 }
+
+func (reader *FileReader) Close() error {
+	return closeMappedFile(reader.dataMap, reader.file)
+}
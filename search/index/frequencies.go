@@ -1,29 +1,24 @@
 package index
 
 import (
-	"bufio"
 	"encoding/binary"
-	"os"
 	"path/filepath"
 )
 
 type FieldFreqsWriter struct {
-	file   *os.File
+	file   *codecFileWriter
 	offset int64
-	writer *bufio.Writer
 }
 
 func newFieldFreqsWriter(directory, segment, fieldName string) (*FieldFreqsWriter, error) {
-	file, err := createFile(filepath.Join(directory, "segment."+segment+"."+fieldName+".frequencies"))
+	file, err := createCodecFile(filepath.Join(directory, "segment."+segment+"."+fieldName+".frequencies"), frequenciesCodec)
 	if err != nil {
 		return nil, err
 	}
 
-	writer := bufio.NewWriter(file)
-
+	// Offsets are relative to the end of the header
 	return &FieldFreqsWriter{
-		file:   file,
-		writer: writer,
+		file: file,
 	}, nil
 }
 
@@ -84,7 +79,7 @@ func (writer *FieldFreqsWriter) WriteBlock(docIds []uint32, termFreqs []uint64,
 	buffer[17] = minFieldLengthId
 	binary.BigEndian.PutUint32(buffer[18:], uint32(len(buffer)))
 
-	_, err := writer.writer.Write(buffer)
+	_, err := writer.file.Write(buffer)
 	if err != nil {
 		return 0, 0, err
 	}
@@ -93,10 +88,6 @@ func (writer *FieldFreqsWriter) WriteBlock(docIds []uint32, termFreqs []uint64,
 }
 
 func (w *FieldFreqsWriter) Close() error {
-	if err := w.writer.Flush(); err != nil {
-		return err
-	}
-
 	return w.file.Close()
 }
 
@@ -104,8 +95,8 @@ type FieldFreqsReader struct {
 	fileReader FileReader
 }
 
-func newFieldFreqsReader(directory, segment, fieldName string) (*FieldFreqsReader, error) {
-	fileReader, err := newFileReader(filepath.Join(directory, "segment."+segment+"."+fieldName+".frequencies"))
+func newFieldFreqsReader(directory, segment, fieldName string, version uint32) (*FieldFreqsReader, error) {
+	fileReader, err := newFileReader(filepath.Join(directory, "segment."+segment+"."+fieldName+".frequencies"), frequenciesCodec, version)
 	if err != nil {
 		return nil, err
 	}
@@ -115,6 +106,10 @@ func newFieldFreqsReader(directory, segment, fieldName string) (*FieldFreqsReade
 	}, nil
 }
 
+func (reader *FieldFreqsReader) Close() error {
+	return reader.fileReader.Close()
+}
+
 func (reader *FieldFreqsReader) TermFreqsIterator(termInfo *TermInfo) *TermFreqsIterator {
 	return newTermFreqsIterator(reader.fileReader, termInfo)
 }
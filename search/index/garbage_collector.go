@@ -0,0 +1,166 @@
+package index
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Garbage collection
+//
+// Segment and deleted files are never modified, a commit replaces them with
+// new ones. The files of the previous commits, and the files of a write that
+// failed before its commit, are removed by the garbage collection of the
+// writer. It keeps the files of the commits still used in this process by an
+// open IndexReader or a snapshot of the writer.
+//
+// Readers in other processes are not tracked. They open all the files of their
+// commit when they are created instead, and the files stay readable once they
+// are removed. On the systems where open files can't be removed, the removal
+// fails and the next collection retries it. A reader that reads a commit
+// whose files are removed before it opens them opens the next commit.
+
+// openCommits tracks the commits used by the readers and snapshots of this
+// process
+var openCommits = &commitRegistry{commits: make(map[string][]*Commit)}
+
+type commitRegistry struct {
+	// commits[directory] are the commits in use, once per user
+	commits map[string][]*Commit
+	mutex   sync.Mutex
+}
+
+func registryKey(directory string) string {
+	absDirectory, err := filepath.Abs(directory)
+	if err != nil {
+		return filepath.Clean(directory)
+	}
+
+	return absDirectory
+}
+
+// acquire reads the current commit of the directory and keeps its files
+// until release is called
+func (registry *commitRegistry) acquire(directory string) (*Commit, error) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	commit, err := readCommit(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	key := registryKey(directory)
+	registry.commits[key] = append(registry.commits[key], commit)
+
+	return commit, nil
+}
+
+func (registry *commitRegistry) release(directory string, commit *Commit) error {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	key := registryKey(directory)
+	commits := registry.commits[key]
+
+	for i, openCommit := range commits {
+		if openCommit == commit {
+			commits = append(commits[:i], commits[i+1:]...)
+
+			if len(commits) == 0 {
+				delete(registry.commits, key)
+			} else {
+				registry.commits[key] = commits
+			}
+
+			return nil
+		}
+	}
+
+	return errors.New("commit not acquired")
+}
+
+// collectGarbage removes the segment and deleted files that are not
+// referenced by the current commit nor by a commit in use. Files unknown to
+// the index are left as is.
+func (registry *commitRegistry) collectGarbage(directory string) error {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	commit, err := readCommit(directory)
+	if err != nil {
+		return err
+	}
+
+	segmentIds := make(map[uint32]struct{})
+	deletedIds := make(map[uint32]struct{})
+
+	commits := append([]*Commit{commit}, registry.commits[registryKey(directory)]...)
+
+	for _, commit := range commits {
+		for _, segmentId := range commit.SegmentIds {
+			segmentIds[segmentId] = struct{}{}
+		}
+
+		if commit.DeletedId != nil {
+			deletedIds[*commit.DeletedId] = struct{}{}
+		}
+	}
+
+	dirEntries, err := os.ReadDir(directory)
+	if err != nil {
+		return err
+	}
+
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+
+		kind, id, ok := parseFileName(name)
+		if !ok {
+			continue
+		}
+
+		switch kind {
+		case "segment":
+			if _, exists := segmentIds[id]; exists {
+				continue
+			}
+		case "deleted":
+			if _, exists := deletedIds[id]; exists {
+				continue
+			}
+		}
+
+		if removeErr := os.Remove(filepath.Join(directory, name)); removeErr != nil && !os.IsNotExist(removeErr) {
+			err = errors.Join(err, removeErr)
+		}
+	}
+
+	return err
+}
+
+// parseFileName returns the kind ("segment", "deleted" or ".commit") and the
+// id of a file of the index. Returns false for the commit, the lock and the
+// files unknown to the index.
+func parseFileName(name string) (string, uint32, bool) {
+	if name == ".commit" {
+		return name, 0, true
+	}
+
+	kind, rest, found := strings.Cut(name, ".")
+	if !found || (kind != "segment" && kind != "deleted") {
+		return "", 0, false
+	}
+
+	idString, _, _ := strings.Cut(rest, ".")
+
+	id, err := strconv.ParseUint(idString, 10, 32)
+	if err != nil {
+		return "", 0, false
+	}
+
+	return kind, uint32(id), true
+}
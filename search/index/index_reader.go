@@ -2,9 +2,12 @@ package index
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
-	"strconv"
+	"reflect"
+	"sync/atomic"
 
 	"github.com/RoaringBitmap/roaring/v2"
 )
@@ -39,6 +42,11 @@ func readCommit(directory string) (*Commit, error) {
 		if err := decoder.Decode(&commit); err != nil {
 			return nil, err
 		}
+
+		if err := checkFormatVersion(commitFile.Name(), commit.Version); err != nil {
+			return nil, err
+		}
+
 		return &commit, nil
 	}
 
@@ -58,45 +66,198 @@ func toLocalDocId(docId uint64) DocumentId {
 	return DocumentId(uint32(docId))
 }
 
+// IndexReader gives access to the segments of a commit. It opens all the files
+// of the segments and keeps them open until Close is called, so that writers
+// of other processes can remove them in the meantime.
 type IndexReader struct {
+	// The files of the commit are kept by the writer until Close
+	commit    *Commit
+	directory string
+	// Number of users of the index reader
+	refCount atomic.Int32
+	// Analyzers of the text fields at query time, from the analyzers saved
+	// by the segments. Defaults to the standard analyzer for the fields of
+	// the segments written before the analyzers were saved.
+	Schema         *Schema
 	SegmentReaders []*SegmentReader
 }
 
 func NewIndexReader(directory string) (*IndexReader, error) {
-	commit, err := readCommit(directory)
-	if err != nil {
-		return nil, err
+	return openIndexReader(directory, nil)
+}
+
+// Reopen returns a reader of the latest commit. The segments of the reader
+// that are still in the commit are not reopened: their files are shared with
+// the new reader, and only the deleted docs are reloaded when they changed.
+// Both readers must be closed.
+func (reader *IndexReader) Reopen() (*IndexReader, error) {
+	return openIndexReader(reader.directory, reader)
+}
+
+// Opens the latest commit, reusing the segments of previous if not nil
+func openIndexReader(directory string, previous *IndexReader) (*IndexReader, error) {
+	for {
+		commit, err := openCommits.acquire(directory)
+		if err != nil {
+			return nil, err
+		}
+
+		indexReader, err := openCommit(directory, commit, previous)
+		if err == nil {
+			return indexReader, nil
+		}
+
+		err = errors.Join(err, openCommits.release(directory, commit))
+
+		// A writer of another process removed the files of the commit after
+		// it was read: the next commit is opened instead
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+
+		latestCommit, readErr := readCommit(directory)
+		if readErr != nil || reflect.DeepEqual(latestCommit, commit) {
+			return nil, err
+		}
 	}
+}
 
-	segmentReaders := make([]*SegmentReader, 0, len(commit.SegmentIds))
+func openCommit(directory string, commit *Commit, previous *IndexReader) (*IndexReader, error) {
+	previousSegmentReaders := make(map[uint32]*SegmentReader)
+	sameDeleted := false
+
+	if previous != nil {
+		for _, segmentReader := range previous.SegmentReaders {
+			previousSegmentReaders[segmentReader.Id] = segmentReader
+		}
+
+		// Deleted files are never modified
+		previousDeletedId := previous.commit.DeletedId
+
+		sameDeleted = (previousDeletedId == nil && commit.DeletedId == nil) ||
+			(previousDeletedId != nil && commit.DeletedId != nil && *previousDeletedId == *commit.DeletedId)
+	}
 
-	var deletedReader DeletedReader
+	var deletedDocIdsBySegment map[uint32]*roaring.Bitmap
 
-	if commit.DeletedId == nil {
-		deletedReader = newNullDeletedReader()
-	} else {
-		deletedReader, err = newFileDeletedReader(directory, strconv.FormatUint(uint64(*commit.DeletedId), 10))
+	if !sameDeleted {
+		var err error
+		deletedDocIdsBySegment, err = readDeletedDocIdsBySegment(directory, commit)
 		if err != nil {
 			return nil, err
 		}
 	}
 
+	segmentReaders := make([]*SegmentReader, 0, len(commit.SegmentIds))
+
 	for _, segmentId := range commit.SegmentIds {
-		deletedDocIdsForSegment, err := deletedReader.GetDeletedDocIdsForSegment(segmentId)
+		previousSegmentReader, exists := previousSegmentReaders[segmentId]
+
+		if exists && sameDeleted {
+			previousSegmentReader.incRef()
+			segmentReaders = append(segmentReaders, previousSegmentReader)
+			continue
+		}
+
+		deletedDocIdsForSegment, exists := deletedDocIdsBySegment[segmentId]
+		if !exists {
+			deletedDocIdsForSegment = roaring.NewBitmap()
+		}
+
+		if previousSegmentReader != nil {
+			segmentReaders = append(segmentReaders, previousSegmentReader.withDeletedDocIds(deletedDocIdsForSegment))
+			continue
+		}
+
+		segmentReader, err := newSegmentReader(directory, segmentId, deletedDocIdsForSegment)
 		if err != nil {
+			for _, segmentReader := range segmentReaders {
+				_ = segmentReader.decRef()
+			}
+
 			return nil, err
 		}
 
-		if deletedDocIdsForSegment == nil {
-			deletedDocIdsForSegment = roaring.NewBitmap()
+		segmentReaders = append(segmentReaders, segmentReader)
+	}
+
+	schema, err := segmentsSchema(segmentReaders)
+	if err != nil {
+		for _, segmentReader := range segmentReaders {
+			_ = segmentReader.decRef()
 		}
 
-		segmentReaders = append(segmentReaders, newSegmentReader(directory, segmentId, deletedDocIdsForSegment))
+		return nil, err
 	}
 
-	return &IndexReader{
+	indexReader := &IndexReader{
+		commit:         commit,
+		directory:      directory,
+		Schema:         schema,
 		SegmentReaders: segmentReaders,
-	}, nil
+	}
+
+	indexReader.refCount.Store(1)
+
+	return indexReader, nil
+}
+
+// Builds the schema of the analyzers saved by the segments. Returns an error if
+// two segments use different analyzers for a field or if an analyzer is not
+// registered.
+func segmentsSchema(segmentReaders []*SegmentReader) (*Schema, error) {
+	schema := NewSchema(StandardAnalyzerName)
+
+	analyzers := make(map[string]string)
+
+	for _, segmentReader := range segmentReaders {
+		for fieldName, analyzer := range segmentReader.Info.Analyzers {
+			previous, exists := analyzers[fieldName]
+			if exists && previous != analyzer {
+				return nil, fmt.Errorf("field %s: analyzers %s and %s", fieldName, previous, analyzer)
+			}
+
+			if !exists {
+				if _, err := analyzerByName(analyzer); err != nil {
+					return nil, fmt.Errorf("field %s: %w", fieldName, err)
+				}
+
+				analyzers[fieldName] = analyzer
+				schema.SetFieldAnalyzer(fieldName, analyzer)
+			}
+		}
+	}
+
+	return schema, nil
+}
+
+// IncRef prevents the reader from being closed by the next call to Close.
+// Each call to IncRef must be followed by a call to Close.
+func (reader *IndexReader) IncRef() {
+	reader.refCount.Add(1)
+}
+
+// Close releases the reader. When all its users released it, it unmaps and
+// closes all the files it opened. The reader must not be used after its last
+// release.
+func (reader *IndexReader) Close() error {
+	refCount := reader.refCount.Add(-1)
+
+	if refCount > 0 {
+		return nil
+	}
+
+	if refCount < 0 {
+		return errors.New("index reader already closed")
+	}
+
+	var err error
+
+	for _, segmentReader := range reader.SegmentReaders {
+		err = errors.Join(err, segmentReader.decRef())
+	}
+
+	return errors.Join(err, openCommits.release(reader.directory, reader.commit))
 }
 
 func (reader *IndexReader) SearchByExactValues(fieldName string, values [][]byte) ([]uint64, error) {
@@ -144,6 +305,8 @@ func (reader *IndexReader) SearchByExactValues(fieldName string, values [][]byte
 
 }
 
+// Value returns the stored value of the field of the doc. The value is valid
+// until the reader is closed.
 func (reader *IndexReader) Value(fieldName string, docId uint64) ([]byte, error) {
 	segmentId := ToSegmentId(docId)
 	localDocId := toLocalDocId(docId)
@@ -2,41 +2,221 @@ package index
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"sync"
 
 	"github.com/RoaringBitmap/roaring/v2"
-	"golang.org/x/exp/rand"
 )
 
+const writeLockFilename = "write.lock"
+
+// ErrLocked is returned by NewIndexWriter when another writer holds the lock of
+// the index directory
+var ErrLocked = errors.New("index directory is locked by another writer")
+
+var errIndexWriterClosed = errors.New("index writer is closed")
+
+// IndexWriter is the only writer of an index directory: it holds the lock
+// of the directory until Close is called.
 type IndexWriter struct {
+	// Next segment or deleted id. Also saved in each commit so that ids
+	// are never reused, even by the writes that failed before their commit.
+	counter   uint32
 	directory string
-	mutex     sync.RWMutex
-	tokenizer *StandardTokenizer
+	// Minimum ratio of deleted docs for ForceMergeDeletes to rewrite a
+	// segment
+	forceMergeDeletesThreshold float64
+	// nil once closed
+	lockFile    *os.File
+	mergePolicy MergePolicy
+	mutex       sync.RWMutex
+	schema      *Schema
 }
 
 type Commit struct {
 	SegmentIds []uint32 `json:"segmentIds"`
 	DeletedId  *uint32  `json:"deletedId,omitempty"`
+	// Lower bound of the ids of the next segments and deleted files. 0 in
+	// the indexes written before it was added, where segment ids are random.
+	Counter uint32 `json:"counter,omitempty"`
+	// Format version of the writer of the commit
+	Version uint32 `json:"version,omitempty"`
+	// Format version of the deleted file, which may be carried over from
+	// an older commit. Missing in the commits written before it was added,
+	// where it is the version of the commit.
+	DeletedVersion *uint32 `json:"deletedVersion,omitempty"`
+}
+
+func (commit *Commit) deletedVersion() uint32 {
+	if commit.DeletedVersion != nil {
+		return *commit.DeletedVersion
+	}
+
+	return commit.Version
 }
 
-func NewIndexWriter(directory string) *IndexWriter {
+// NewIndexWriter locks the directory, removes the files left by a previous
+// writer and returns a writer. Returns ErrLocked if another writer, in this
+// process or another one, holds the lock.
+func NewIndexWriter(directory string) (*IndexWriter, error) {
+	lockFile, err := lockDirectory(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	// Files left by a writer that failed or crashed
+	if err := openCommits.collectGarbage(directory); err != nil {
+		return nil, errors.Join(err, unlockDirectory(lockFile))
+	}
+
+	commit, err := readCommit(directory)
+	if err != nil {
+		return nil, errors.Join(err, unlockDirectory(lockFile))
+	}
+
+	counter := commit.Counter
+	if commit.DeletedId != nil {
+		counter = max(counter, *commit.DeletedId+1)
+	}
+
 	return &IndexWriter{
-		directory: directory,
-		tokenizer: NewStandardTokenizer(),
+		counter:                    counter,
+		directory:                  directory,
+		forceMergeDeletesThreshold: 0.1,
+		lockFile:                   lockFile,
+		mergePolicy:                NewLogMergePolicy(),
+		schema:                     NewSchema(StandardAnalyzerName),
+	}, nil
+}
+
+// Close releases the lock of the directory. The writer can't be used
+// afterwards.
+func (writer *IndexWriter) Close() error {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	if writer.lockFile == nil {
+		return errIndexWriterClosed
 	}
+
+	err := unlockDirectory(writer.lockFile)
+	writer.lockFile = nil
+
+	return err
+}
+
+// SetSchema sets the analyzers of the text fields of the documents added
+// after the call. Defaults to the standard analyzer for all fields. The
+// analyzer of a field can't change once the index has the field.
+func (writer *IndexWriter) SetSchema(schema *Schema) {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	writer.schema = schema
+}
+
+// SetMergePolicy sets the policy used to merge segments after each commit
+// and by Merge. Use NoMergePolicy to disable merges.
+func (writer *IndexWriter) SetMergePolicy(mergePolicy MergePolicy) {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	writer.mergePolicy = mergePolicy
 }
 
 func (writer *IndexWriter) AddDocuments(docs []Document) error {
 	writer.mutex.Lock()
 	defer writer.mutex.Unlock()
 
+	if writer.lockFile == nil {
+		return errIndexWriterClosed
+	}
+
+	commit, err := readCommit(writer.directory)
+	if err != nil {
+		return err
+	}
+
+	newSegmentId, err := writer.writeSegment(commit, docs)
+	if err != nil {
+		return err
+	}
+
+	segmentIds := append(commit.SegmentIds, newSegmentId)
+
+	if err := writer.commit(commit, segmentIds, commit.DeletedId); err != nil {
+		return err
+	}
+
+	return writer.maybeMerge()
+}
+
+// UpdateDocuments replaces the documents that have the same value for
+// idField as one of docs. Documents without a previous version are added.
+// Deletes and adds are visible at once, in a single commit.
+func (writer *IndexWriter) UpdateDocuments(idField string, docs []Document) error {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	if writer.lockFile == nil {
+		return errIndexWriterClosed
+	}
+
+	values := make([][]byte, 0, len(docs))
+
+	for i, doc := range docs {
+		index := slices.IndexFunc(doc, func(field Field) bool {
+			return field.Name == idField
+		})
+
+		if index == -1 {
+			return fmt.Errorf("document %d has no %s field", i, idField)
+		}
+
+		values = append(values, doc[index].Value)
+	}
+
+	commit, err := readCommit(writer.directory)
+	if err != nil {
+		return err
+	}
+
+	// Deletes first, so the new documents are not deleted
+	deletedId, err := writer.deleteDocuments(commit, idField, values)
+	if err != nil {
+		return err
+	}
+
+	newSegmentId, err := writer.writeSegment(commit, docs)
+	if err != nil {
+		return err
+	}
+
+	segmentIds := append(commit.SegmentIds, newSegmentId)
+
+	if err := writer.commit(commit, segmentIds, deletedId); err != nil {
+		return err
+	}
+
+	return writer.maybeMerge()
+}
+
+// Writes docs in a new segment, not referenced by the commit yet, and returns
+// its id
+func (writer *IndexWriter) writeSegment(commit *Commit, docs []Document) (uint32, error) {
 	segmentComponentWriters := make([]SegmentComponentWriter, 0, 10)
 
-	segmentComponentWriters = append(segmentComponentWriters, newInvertedIndexWriter(), newStoreWriter())
+	segmentInfoWriter := newSegmentInfoWriter(writer.schema)
+
+	segmentComponentWriters = append(segmentComponentWriters, newInvertedIndexWriter(writer.schema), newStoreWriter(), segmentInfoWriter, newDocValuesWriter(writer.schema))
+
+	// tokenStreams[fieldName] runs the analyzer of the field in the schema
+	tokenStreams := make(map[string]TokenStream)
 
 	for docId, doc := range docs {
 		for _, segmentComponentWriter := range segmentComponentWriters {
@@ -51,15 +231,27 @@ func (writer *IndexWriter) AddDocuments(docs []Document) error {
 			switch field.FieldType {
 			case TextFieldType:
 				{
-					writer.tokenizer.Reset(field.Value)
+					tokenStream, exists := tokenStreams[field.Name]
+					if !exists {
+						analyzer, err := writer.schema.Analyzer(field.Name)
+						if err != nil {
+							return 0, fmt.Errorf("field %s: %w", field.Name, err)
+						}
+
+						tokenStream = analyzer.NewTokenStream()
+						tokenStreams[field.Name] = tokenStream
+						segmentInfoWriter.textField(field.Name)
+					}
+
+					tokenStream.Reset(field.Value)
 					for {
-						token, ok := writer.tokenizer.NextToken()
+						token, ok := tokenStream.NextToken()
 						if !ok {
 							break
 						}
 
 						for _, segmentComponentWriter := range segmentComponentWriters {
-							segmentComponentWriter.Term(token.Text)
+							segmentComponentWriter.Term(token)
 						}
 
 					}
@@ -67,12 +259,28 @@ func (writer *IndexWriter) AddDocuments(docs []Document) error {
 				}
 			case ByteFieldType:
 				{
+					token := &Token{Text: field.Value, Start: 0, End: len(field.Value)}
+
 					for _, segmentComponentWriter := range segmentComponentWriters {
-						segmentComponentWriter.Term(field.Value)
+						segmentComponentWriter.Term(token)
+					}
+				}
+			case Int64FieldType, Float64FieldType, DateFieldType:
+				{
+					if err := checkNumericValue(&field); err != nil {
+						return 0, err
+					}
+
+					for _, term := range numericTerms(field.Value) {
+						token := &Token{Text: term, Start: 0, End: len(field.Value)}
+
+						for _, segmentComponentWriter := range segmentComponentWriters {
+							segmentComponentWriter.Term(token)
+						}
 					}
 				}
 			default:
-				return fmt.Errorf("unknown field type %d", field.FieldType)
+				return 0, fmt.Errorf("unknown field type %d", field.FieldType)
 			}
 
 			for _, segmentComponentWriter := range segmentComponentWriters {
@@ -81,118 +289,501 @@ func (writer *IndexWriter) AddDocuments(docs []Document) error {
 		}
 	}
 
-	newSegmentId := rand.Uint32()
+	if err := writer.checkAnalyzers(commit, segmentInfoWriter.textFields); err != nil {
+		return 0, err
+	}
+
+	newSegmentId := writer.nextId(commit)
 
 	for _, segmentComponentWriter := range segmentComponentWriters {
 		err := segmentComponentWriter.Write(writer.directory, strconv.FormatUint(uint64(newSegmentId), 10))
 		if err != nil {
-			return err
+			return 0, err
 		}
 
 	}
 
-	commit, err := readCommit(writer.directory)
-	if err != nil {
-		return err
+	return newSegmentId, nil
+}
+
+// checkAnalyzers rejects the text fields whose analyzer in the schema differs
+// from the one saved by the segments of the commit, since queries on the field
+// can only use one analyzer.
+func (writer *IndexWriter) checkAnalyzers(commit *Commit, textFields map[string]struct{}) error {
+	if len(textFields) == 0 {
+		return nil
 	}
 
-	segmentIds := commit.SegmentIds
+	for _, segmentId := range commit.SegmentIds {
+		segmentInfo, err := readSegmentInfo(writer.directory, strconv.FormatUint(uint64(segmentId), 10))
+		if err != nil {
+			return err
+		}
 
-	segmentIds = append(segmentIds, newSegmentId)
+		for fieldName := range textFields {
+			analyzer, exists := segmentInfo.Analyzers[fieldName]
+			if exists && analyzer != writer.schema.AnalyzerName(fieldName) {
+				return fmt.Errorf("field %s: analyzer %s, the index uses %s", fieldName, writer.schema.AnalyzerName(fieldName), analyzer)
+			}
+		}
+	}
 
-	return writer.commit(segmentIds, commit.DeletedId)
+	return nil
 }
 
-func (writer *IndexWriter) commit(segmentIds []uint32, deletedId *uint32) error {
+// nextId returns the id of a new segment or deleted file. Ids come from the
+// counter. The ids of the commit, and of the files still in the directory,
+// are skipped: they may have been picked at random by an older version.
+func (writer *IndexWriter) nextId(commit *Commit) uint32 {
+	for {
+		id := writer.counter
+		writer.counter++
+
+		if slices.Contains(commit.SegmentIds, id) {
+			continue
+		}
+
+		idString := strconv.FormatUint(uint64(id), 10)
+
+		// The segments of an older version may have no info file
+		if filesExist(filepath.Join(writer.directory, "segment."+idString+".*")) ||
+			filesExist(filepath.Join(writer.directory, "deleted."+idString+".*")) {
+			continue
+		}
+
+		return id
+	}
+}
+
+func filesExist(pattern string) bool {
+	matches, err := filepath.Glob(pattern)
+	return err != nil || len(matches) > 0
+}
+
+// commit publishes a new commit, then removes the files that are no longer
+// used. The files it references must already be synced. See file_store.go
+// for the order of the syncs.
+func (writer *IndexWriter) commit(previous *Commit, segmentIds []uint32, deletedId *uint32) error {
+	// Names of the new files
+	if err := syncDirectory(writer.directory); err != nil {
+		return err
+	}
+
 	tempFilePath := filepath.Join(writer.directory, ".commit")
 	tempFile, err := os.Create(tempFilePath)
 	if err != nil {
 		return err
 	}
 
-	defer tempFile.Close()
-
 	commit := Commit{
 		SegmentIds: segmentIds,
 		DeletedId:  deletedId,
+		Counter:    writer.counter,
+		Version:    formatVersion,
+	}
+
+	if deletedId != nil {
+		// The deleted file of the previous commit keeps its version
+		deletedVersion := formatVersion
+		if previous.DeletedId != nil && *previous.DeletedId == *deletedId {
+			deletedVersion = previous.deletedVersion()
+		}
+
+		commit.DeletedVersion = &deletedVersion
 	}
 
 	encoder := json.NewEncoder(tempFile)
 
 	err = encoder.Encode(commit)
 	if err != nil {
+		_ = tempFile.Close()
+		return err
+	}
+
+	if err := closeSyncedFile(tempFile); err != nil {
 		return err
 	}
 
 	commitFilePath := filepath.Join(writer.directory, "commit")
-	err = os.Rename(tempFilePath, commitFilePath)
+	err = renameFile(tempFilePath, commitFilePath)
 	if err != nil {
 		return err
 	}
 
+	if err := syncDirectory(writer.directory); err != nil {
+		return err
+	}
+
+	// The commit succeeded. Files that can't be removed now are removed by
+	// the next collection.
+	_ = openCommits.collectGarbage(writer.directory)
+
 	return nil
 }
 
+// CollectGarbage removes the segment and deleted files that are not used by
+// the current commit, nor by an open IndexReader or a snapshot of this
+// process. It already runs after each commit.
+func (writer *IndexWriter) CollectGarbage() error {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	if writer.lockFile == nil {
+		return errIndexWriterClosed
+	}
+
+	return openCommits.collectGarbage(writer.directory)
+}
+
+// Snapshot returns the current commit and keeps its files until
+// ReleaseSnapshot is called, e.g. to copy them for a backup while the writer
+// keeps committing.
+func (writer *IndexWriter) Snapshot() (*Commit, error) {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	if writer.lockFile == nil {
+		return nil, errIndexWriterClosed
+	}
+
+	return openCommits.acquire(writer.directory)
+}
+
+// ReleaseSnapshot releases a commit returned by Snapshot. Its files are
+// removed by the next collection if they are no longer used.
+func (writer *IndexWriter) ReleaseSnapshot(commit *Commit) error {
+	return openCommits.release(writer.directory, commit)
+}
+
 func (writer *IndexWriter) DeleteDocuments(fieldName string, values [][]byte) error {
-	indexReader, err := NewIndexReader(writer.directory)
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	if writer.lockFile == nil {
+		return errIndexWriterClosed
+	}
+
+	commit, err := readCommit(writer.directory)
 	if err != nil {
 		return err
 	}
 
-	// TODO: This is a merge behavior here, we'll need to have the right structure for this and for other merges, definitevely not inline here
+	deletedId, err := writer.deleteDocuments(commit, fieldName, values)
+	if err != nil {
+		return err
+	}
+
+	return writer.commit(commit, commit.SegmentIds, deletedId)
+}
+
+// Writes the next deleted file of the commit, with the documents of the
+// commit matching values in addition to the already deleted ones, and returns
+// its id
+func (writer *IndexWriter) deleteDocuments(commit *Commit, fieldName string, values [][]byte) (*uint32, error) {
+	indexReader, err := NewIndexReader(writer.directory)
+	if err != nil {
+		return nil, err
+	}
+
+	defer indexReader.Close()
 
 	docIdsToDelete, err := indexReader.SearchByExactValues(fieldName, values)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	commit, err := readCommit(writer.directory)
+	deletedDocIdsBySegment, err := readDeletedDocIdsBySegment(writer.directory, commit)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	var deletedReader DeletedReader
-	var nextDeletedId uint32
+	for _, docId := range docIdsToDelete {
+		segmentId := ToSegmentId(docId)
 
+		deletedDocIdsForSegment, exists := deletedDocIdsBySegment[segmentId]
+		if !exists {
+			deletedDocIdsForSegment = roaring.NewBitmap()
+			deletedDocIdsBySegment[segmentId] = deletedDocIdsForSegment
+		}
+
+		deletedDocIdsForSegment.Add(uint32(toLocalDocId(docId)))
+	}
+
+	nextDeletedId, err := writer.writeDeleted(commit, deletedDocIdsBySegment)
+	if err != nil {
+		return nil, err
+	}
+
+	return &nextDeletedId, nil
+}
+
+func readDeletedDocIdsBySegment(directory string, commit *Commit) (map[uint32]*roaring.Bitmap, error) {
 	if commit.DeletedId == nil {
-		deletedReader = newNullDeletedReader()
-	} else {
-		deletedReader, err = newFileDeletedReader(writer.directory, strconv.FormatUint(uint64(*commit.DeletedId), 10))
+		return newNullDeletedReader().GetDeletedDocIdsBySegment()
+	}
+
+	deletedReader, err := newFileDeletedReader(directory, strconv.FormatUint(uint64(*commit.DeletedId), 10), commit.deletedVersion())
+	if err != nil {
+		return nil, err
+	}
+
+	defer deletedReader.Close()
+
+	return deletedReader.GetDeletedDocIdsBySegment()
+}
+
+// Writes the next deleted file of the commit and returns its id
+func (writer *IndexWriter) writeDeleted(commit *Commit, deletedDocIdsBySegment map[uint32]*roaring.Bitmap) (uint32, error) {
+	nextDeletedId := writer.nextId(commit)
+
+	deletedWriter := newDeletedWriter()
+
+	deletedWriter.DeletedDocs(deletedDocIdsBySegment)
+
+	if err := deletedWriter.Write(writer.directory, strconv.FormatUint(uint64(nextDeletedId), 10)); err != nil {
+		return 0, err
+	}
+
+	return nextDeletedId, nil
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// Merge
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+// Merge merges segments as long as the merge policy finds merges to do.
+// AddDocuments already calls it after each commit, it's only needed after
+// changing the merge policy.
+func (writer *IndexWriter) Merge() error {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	if writer.lockFile == nil {
+		return errIndexWriterClosed
+	}
+
+	return writer.maybeMerge()
+}
+
+func (writer *IndexWriter) maybeMerge() error {
+	for {
+		merged, err := writer.runMerges(writer.mergePolicy.FindMerges)
 		if err != nil {
 			return err
 		}
 
-		nextDeletedId = *commit.DeletedId + 1
+		if !merged {
+			return nil
+		}
 	}
+}
 
-	deletedDocIdsBySegment := make(map[uint32]*roaring.Bitmap)
+// Runs the merges returned by findMerges and commits them all at once.
+// Returns false if there was nothing to merge.
+func (writer *IndexWriter) runMerges(findMerges func(segments []*SegmentMergeInfo) [][]uint32) (bool, error) {
+	commit, err := readCommit(writer.directory)
+	if err != nil {
+		return false, err
+	}
 
-	for _, docId := range docIdsToDelete {
-		segmentId := ToSegmentId(docId)
+	segmentIds, deletedId, err := writer.writeMerges(commit, findMerges)
+	if err != nil || segmentIds == nil {
+		return false, err
+	}
 
-		deletedDocIdsForSegment, exists := deletedDocIdsBySegment[segmentId]
-		if !exists {
-			deletedDocIdsForSegment, err = deletedReader.GetDeletedDocIdsForSegment(segmentId)
-			if err != nil {
-				return err
+	// After the reader of writeMerges is closed, so that the merged segments
+	// are removed
+	return true, writer.commit(commit, segmentIds, deletedId)
+}
+
+// Writes the merged segments and the deleted file, not referenced by the
+// commit yet, and returns the segment ids and the deleted id of the next
+// commit. Returns nil segment ids if there is nothing to merge.
+func (writer *IndexWriter) writeMerges(commit *Commit, findMerges func(segments []*SegmentMergeInfo) [][]uint32) ([]uint32, *uint32, error) {
+	indexReader, err := NewIndexReader(writer.directory)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer indexReader.Close()
+
+	segmentReadersById := make(map[uint32]*SegmentReader, len(indexReader.SegmentReaders))
+	segmentMergeInfos := make([]*SegmentMergeInfo, 0, len(indexReader.SegmentReaders))
+
+	for _, segmentReader := range indexReader.SegmentReaders {
+		segmentReadersById[segmentReader.Id] = segmentReader
+		segmentMergeInfos = append(segmentMergeInfos, &SegmentMergeInfo{
+			Id:              segmentReader.Id,
+			DocCount:        segmentReader.Info.DocCount,
+			DeletedDocCount: uint32(segmentReader.DeletedDocIds.GetCardinality()),
+			Version:         segmentReader.Info.Version,
+		})
+	}
+
+	merges := findMerges(segmentMergeInfos)
+	if len(merges) == 0 {
+		return nil, nil, nil
+	}
+
+	// mergedSegmentIds[segmentId] is the id of the segment replacing it, or
+	// nil if the segment is dropped
+	mergedSegmentIds := make(map[uint32]*uint32)
+
+	for _, merge := range merges {
+		segmentReaders := make([]*SegmentReader, 0, len(merge))
+
+		for _, segmentId := range merge {
+			segmentReader, exists := segmentReadersById[segmentId]
+			if !exists {
+				return nil, nil, fmt.Errorf("merge policy returned unknown segment %d", segmentId)
 			}
-			if deletedDocIdsForSegment == nil {
-				deletedDocIdsForSegment = roaring.NewBitmap()
+
+			if _, exists := mergedSegmentIds[segmentId]; exists {
+				return nil, nil, fmt.Errorf("merge policy returned segment %d twice", segmentId)
 			}
 
-			deletedDocIdsBySegment[segmentId] = deletedDocIdsForSegment
+			mergedSegmentIds[segmentId] = nil
+			segmentReaders = append(segmentReaders, segmentReader)
 		}
 
-		deletedDocIdsForSegment.Add(uint32(toLocalDocId(docId)))
+		newSegmentId := writer.nextId(commit)
+
+		segmentInfo, err := mergeSegments(writer.directory, segmentReaders, newSegmentId)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// All docs are deleted
+		if segmentInfo == nil {
+			continue
+		}
+
+		// The new segment takes the place of the first merged segment
+		mergedSegmentIds[merge[0]] = &newSegmentId
 	}
 
-	deletedWriter := newDeletedWriter()
+	segmentIds := make([]uint32, 0, len(indexReader.SegmentReaders))
 
-	deletedWriter.DeletedDocs(deletedDocIdsBySegment)
+	for _, segmentReader := range indexReader.SegmentReaders {
+		newSegmentId, merged := mergedSegmentIds[segmentReader.Id]
+		if !merged {
+			segmentIds = append(segmentIds, segmentReader.Id)
+		} else if newSegmentId != nil {
+			segmentIds = append(segmentIds, *newSegmentId)
+		}
+	}
 
-	deletedWriter.Write(writer.directory, strconv.FormatUint(uint64(nextDeletedId), 10))
+	deletedId := commit.DeletedId
 
-	writer.commit(commit.SegmentIds, &nextDeletedId)
+	if deletedId != nil {
+		deletedDocIdsBySegment, err := readDeletedDocIdsBySegment(writer.directory, commit)
+		if err != nil {
+			return nil, nil, err
+		}
 
-	return nil
+		// Deleted docs of merged segments are gone
+		for segmentId := range mergedSegmentIds {
+			delete(deletedDocIdsBySegment, segmentId)
+		}
+
+		nextDeletedId, err := writer.writeDeleted(commit, deletedDocIdsBySegment)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		deletedId = &nextDeletedId
+	}
+
+	return segmentIds, deletedId, nil
+}
+
+// Upgrade rewrites the segments written in an older format version in the
+// current one. Older versions are still read, but their support may be
+// dropped by a later version.
+func (writer *IndexWriter) Upgrade() error {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	if writer.lockFile == nil {
+		return errIndexWriterClosed
+	}
+
+	merged, err := writer.runMerges(func(segments []*SegmentMergeInfo) [][]uint32 {
+		merges := make([][]uint32, 0)
+
+		for _, segment := range segments {
+			if segment.Version < formatVersion {
+				merges = append(merges, []uint32{segment.Id})
+			}
+		}
+
+		return merges
+	})
+
+	if err != nil || merged {
+		return err
+	}
+
+	// The merges rewrite the deleted file, which may be older than the
+	// segments
+	commit, err := readCommit(writer.directory)
+	if err != nil {
+		return err
+	}
+
+	if commit.DeletedId == nil || commit.deletedVersion() == formatVersion {
+		return nil
+	}
+
+	deletedDocIdsBySegment, err := readDeletedDocIdsBySegment(writer.directory, commit)
+	if err != nil {
+		return err
+	}
+
+	deletedId, err := writer.writeDeleted(commit, deletedDocIdsBySegment)
+	if err != nil {
+		return err
+	}
+
+	return writer.commit(commit, commit.SegmentIds, &deletedId)
+}
+
+// SetForceMergeDeletesThreshold sets the minimum ratio of deleted docs, between
+// 0 and 1, from which ForceMergeDeletes rewrites a segment. Defaults to 0.1.
+func (writer *IndexWriter) SetForceMergeDeletesThreshold(threshold float64) {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	writer.forceMergeDeletesThreshold = threshold
+}
+
+// ForceMergeDeletes rewrites each segment whose ratio of deleted docs is
+// at least the threshold. The deleted docs are dropped from the postings, the
+// field lengths and the stored fields, and the remaining docs are renumbered.
+// Segments where all docs are deleted are removed.
+func (writer *IndexWriter) ForceMergeDeletes() error {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	if writer.lockFile == nil {
+		return errIndexWriterClosed
+	}
+
+	_, err := writer.runMerges(func(segments []*SegmentMergeInfo) [][]uint32 {
+		merges := make([][]uint32, 0)
+
+		for _, segment := range segments {
+			if segment.DeletedDocCount == 0 {
+				continue
+			}
+
+			if float64(segment.DeletedDocCount)/float64(segment.DocCount) >= writer.forceMergeDeletesThreshold {
+				merges = append(merges, []uint32{segment.Id})
+			}
+		}
+
+		return merges
+	})
+
+	return err
 }
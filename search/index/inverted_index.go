@@ -11,9 +11,11 @@ import (
 type Posting struct {
 	docId    DocumentId
 	position uint64
+	offsets  Offsets
 }
 
 type InvertedIndexWriter struct {
+	docCount  uint32
 	docId     DocumentId
 	fieldName string
 	fieldId   int
@@ -26,10 +28,13 @@ type InvertedIndexWriter struct {
 
 	// fieldLengtths[fieldName][docId]
 	fieldLengths map[string][]uint64
+
+	schema *Schema
 }
 
-func newInvertedIndexWriter() *InvertedIndexWriter {
+func newInvertedIndexWriter(schema *Schema) *InvertedIndexWriter {
 	return &InvertedIndexWriter{
+		schema:       schema,
 		fieldIds:     make(map[string]int),
 		fieldNames:   make([]string, 0, 5),
 		postings:     make([]map[string][]*Posting, 0, 5),
@@ -39,12 +44,18 @@ func newInvertedIndexWriter() *InvertedIndexWriter {
 
 func (writer *InvertedIndexWriter) Doc(docId DocumentId) {
 	writer.docId = docId
+	writer.docCount = uint32(docId) + 1
 }
 
 func (w *InvertedIndexWriter) Field(fieldName string, value []byte) {
 	w.fieldName = fieldName
 	w.position = 0
 
+	// The values of a field given several times in a doc follow each other
+	if fieldLengths := w.fieldLengths[fieldName]; len(fieldLengths) == int(w.docId)+1 {
+		w.position = fieldLengths[w.docId]
+	}
+
 	fieldId, exists := w.fieldIds[fieldName]
 	if !exists {
 		fieldId = len(w.fieldIds)
@@ -60,17 +71,28 @@ func (w *InvertedIndexWriter) EndField() {
 	fieldLengths, exists := w.fieldLengths[w.fieldName]
 	if !exists {
 		fieldLengths = make([]uint64, 0)
-		w.fieldLengths[w.fieldName] = fieldLengths
 	}
 
-	w.fieldLengths[w.fieldName] = append(fieldLengths, w.position)
+	// Docs without the field have a length of 0
+	for len(fieldLengths) < int(w.docId) {
+		fieldLengths = append(fieldLengths, 0)
+	}
+
+	if len(fieldLengths) == int(w.docId)+1 {
+		fieldLengths[w.docId] = w.position
+	} else {
+		fieldLengths = append(fieldLengths, w.position)
+	}
+
+	w.fieldLengths[w.fieldName] = fieldLengths
 }
 
-func (w *InvertedIndexWriter) Term(term []byte) {
-	termString := string(term)
+func (w *InvertedIndexWriter) Term(token *Token) {
+	termString := string(token.Text)
 	posting := &Posting{
 		docId:    w.docId,
 		position: w.position,
+		offsets:  Offsets{Start: uint32(token.Start), End: uint32(token.End)},
 	}
 
 	w.postings[w.fieldId][termString] = append(w.postings[w.fieldId][termString], posting)
@@ -78,204 +100,261 @@ func (w *InvertedIndexWriter) Term(term []byte) {
 }
 
 func (w *InvertedIndexWriter) Write(directory, segmentId string) error {
-	var fieldName string
-	var term string
-
-	var fieldSumTermFreq uint64
-	fieldDocIds := roaring.NewBitmap()
-	var fieldStatsWriter *FieldStatsWriter
-	var fieldFreqsWriter *FieldFreqsWriter
-	var dictWriter *DictionaryWriter
-	var arrayStoreWriter *ArrayStoreWriter
-
 	termDocIds := make([]uint32, 0, 100)
 	termFreqs := make([]uint64, 0, 100)
-	var termFreq uint64
-	termInfo := &TermInfo{}
-
-	var fieldLengths []uint64
+	termPositions := make([]uint64, 0, 100)
+	termOffsets := make([]Offsets, 0, 100)
 
-	startField := func(fieldName string) error {
-		fieldSumTermFreq = 0
-		fieldDocIds.Clear()
-
-		var err error
+	for fieldId, fieldPostings := range w.postings {
+		fieldName := w.fieldNames[fieldId]
+		offsets := w.schema.FieldOffsets(fieldName)
 
-		fieldFreqsWriter, err = newFieldFreqsWriter(directory, segmentId, fieldName)
-		if err != nil {
-			return err
+		fieldLengthIds := make([]byte, w.docCount)
+		for docId, length := range w.fieldLengths[fieldName] {
+			fieldLengthIds[docId] = fieldLengthToId(length)
 		}
 
-		fieldStatsWriter, err = newFieldStatsWriter(directory, segmentId, fieldName)
+		fieldPostingsWriter, err := newFieldPostingsWriter(directory, segmentId, fieldName, fieldLengthIds, true, offsets)
 		if err != nil {
 			return err
 		}
 
-		dictWriter, err = newDictionaryWriter(directory, segmentId, fieldName)
-		if err != nil {
-			return err
+		sortedTerms := make([]string, 0, len(fieldPostings))
+		for term := range fieldPostings {
+			sortedTerms = append(sortedTerms, term)
 		}
+		slices.Sort(sortedTerms)
 
-		arrayStoreWriter, err = newArrayStoreWriter(filepath.Join(directory, "segment."+segmentId+"."+fieldName+".lengths"))
-		if err != nil {
-			return err
-		}
+		for _, term := range sortedTerms {
+			termDocIds = termDocIds[:0]
+			termFreqs = termFreqs[:0]
+			termPositions = termPositions[:0]
+			termOffsets = termOffsets[:0]
 
-		fieldLengths = w.fieldLengths[fieldName]
+			for _, posting := range fieldPostings[term] {
+				if len(termDocIds) == 0 || termDocIds[len(termDocIds)-1] != uint32(posting.docId) {
+					termDocIds = append(termDocIds, uint32(posting.docId))
+					termFreqs = append(termFreqs, 0)
+				}
 
-		return nil
-	}
+				termFreqs[len(termFreqs)-1]++
+				termPositions = append(termPositions, posting.position)
 
-	endField := func() error {
-		if err := fieldStatsWriter.Write(uint32(fieldDocIds.GetCardinality()), fieldSumTermFreq); err != nil {
+				if offsets {
+					termOffsets = append(termOffsets, posting.offsets)
+				}
+			}
+
+			if err := fieldPostingsWriter.WriteTerm([]byte(term), termDocIds, termFreqs, termPositions, termOffsets); err != nil {
+				return err
+			}
+		}
+
+		if err := fieldPostingsWriter.Close(); err != nil {
 			return err
 		}
+	}
 
-		buffer := make([]byte, len(fieldLengths))
+	return nil
+}
 
-		for docId, length := range fieldLengths {
-			buffer[docId] = fieldLengthToId(length)
-		}
+// FieldPostingsWriter writes the dictionary, frequencies, lengths and stats
+// files of a field, its positions file if it has positions and its offsets
+// file if it has offsets. Terms must be written in order.
+type FieldPostingsWriter struct {
+	arrayStoreWriter     *ArrayStoreWriter
+	dictWriter           *DictionaryWriter
+	fieldDocIds          *roaring.Bitmap
+	fieldFreqsWriter     *FieldFreqsWriter
+	fieldLengthIds       []byte
+	fieldOffsetsWriter   *FieldOffsetsWriter
+	fieldPositionsWriter *FieldPositionsWriter
+	fieldStatsWriter     *FieldStatsWriter
+	fieldSumTermFreq     uint64
+	termInfo             *TermInfo
+}
 
-		arrayStoreWriter.Append(buffer)
+// fieldLengthIds[docId] is the field length id of every doc of the segment
+func newFieldPostingsWriter(directory, segmentId, fieldName string, fieldLengthIds []byte, positions, offsets bool) (*FieldPostingsWriter, error) {
+	fieldFreqsWriter, err := newFieldFreqsWriter(directory, segmentId, fieldName)
+	if err != nil {
+		return nil, err
+	}
 
-		if err := fieldFreqsWriter.Close(); err != nil {
-			return err
+	var fieldPositionsWriter *FieldPositionsWriter
+	if positions {
+		fieldPositionsWriter, err = newFieldPositionsWriter(directory, segmentId, fieldName)
+		if err != nil {
+			return nil, err
 		}
+	}
 
-		if err := dictWriter.Close(); err != nil {
-			return err
-		}
+	fieldStatsWriter, err := newFieldStatsWriter(directory, segmentId, fieldName)
+	if err != nil {
+		return nil, err
+	}
 
-		return nil
+	var fieldOffsetsWriter *FieldOffsetsWriter
+	if offsets {
+		fieldOffsetsWriter, err = newFieldOffsetsWriter(directory, segmentId, fieldName)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	startTerm := func() error {
-		termDocIds = termDocIds[:0]
-		termFreqs = termFreqs[:0]
+	dictWriter, err := newDictionaryWriter(directory, segmentId, fieldName, offsets)
+	if err != nil {
+		return nil, err
+	}
 
-		return nil
+	arrayStoreWriter, err := newArrayStoreWriter(filepath.Join(directory, "segment."+segmentId+"."+fieldName+".lengths"), lengthsCodec)
+	if err != nil {
+		return nil, err
 	}
 
-	endTerm := func() error {
-		firstOffset := uint64(0)
-		firstOffsetSet := false
-		endOffset := uint64(0)
+	return &FieldPostingsWriter{
+		arrayStoreWriter:     arrayStoreWriter,
+		dictWriter:           dictWriter,
+		fieldDocIds:          roaring.NewBitmap(),
+		fieldFreqsWriter:     fieldFreqsWriter,
+		fieldLengthIds:       fieldLengthIds,
+		fieldOffsetsWriter:   fieldOffsetsWriter,
+		fieldPositionsWriter: fieldPositionsWriter,
+		fieldStatsWriter:     fieldStatsWriter,
+		termInfo:             &TermInfo{},
+	}, nil
+}
 
-		for i := 0; i < len(termDocIds); i += 128 {
-			end := i + 128
-			if end > len(termDocIds) {
-				end = len(termDocIds)
-			}
+// termPositions holds the positions of each doc of termDocIds, one doc after
+// the other, and termOffsets their offsets. termPositions is ignored if the
+// field has no positions, and termOffsets if it has no offsets.
+func (w *FieldPostingsWriter) WriteTerm(term []byte, termDocIds []uint32, termFreqs []uint64, termPositions []uint64, termOffsets []Offsets) error {
+	firstOffset := uint64(0)
+	firstOffsetSet := false
+	endOffset := uint64(0)
+	firstPositionsOffset := uint64(0)
+	endPositionsOffset := uint64(0)
+	firstOffsetsOffset := uint64(0)
+	endOffsetsOffset := uint64(0)
+	positionsStart := 0
+
+	for i := 0; i < len(termDocIds); i += 128 {
+		end := i + 128
+		if end > len(termDocIds) {
+			end = len(termDocIds)
+		}
 
-			docIdsInBatch := termDocIds[i:end]
-			termFreqsInBatch := termFreqs[i:end]
+		docIdsInBatch := termDocIds[i:end]
+		termFreqsInBatch := termFreqs[i:end]
 
-			var minFieldLength uint64
-			minFieldLength = math.MaxUint64
+		var minFieldLengthId byte
+		minFieldLengthId = math.MaxUint8
 
-			for _, docId := range docIdsInBatch {
-				fieldLength := fieldLengths[docId]
-				if fieldLength < minFieldLength {
-					minFieldLength = fieldLength
-				}
+		for _, docId := range docIdsInBatch {
+			fieldLengthId := w.fieldLengthIds[docId]
+			if fieldLengthId < minFieldLengthId {
+				minFieldLengthId = fieldLengthId
 			}
+		}
 
-			startOffset, _endOffset, err := fieldFreqsWriter.WriteBlock(docIdsInBatch, termFreqsInBatch, fieldLengthToId(minFieldLength))
+		startOffset, _endOffset, err := w.fieldFreqsWriter.WriteBlock(docIdsInBatch, termFreqsInBatch, minFieldLengthId)
+		if err != nil {
+			return err
+		}
+
+		positionsEnd := positionsStart
+		for _, termFreq := range termFreqsInBatch {
+			positionsEnd += int(termFreq)
+		}
+
+		if w.fieldPositionsWriter != nil {
+			startPositionsOffset, _endPositionsOffset, err := w.fieldPositionsWriter.WriteBlock(termFreqsInBatch, termPositions[positionsStart:positionsEnd])
 			if err != nil {
 				return err
 			}
 
 			if !firstOffsetSet {
-				firstOffset = startOffset
-				firstOffsetSet = true
+				firstPositionsOffset = startPositionsOffset
 			}
 
-			endOffset = _endOffset
+			endPositionsOffset = _endPositionsOffset
 		}
 
-		termInfo.DocFreq = uint32(len(termDocIds))
-		termInfo.FreqsFileStartOffset = firstOffset
-		termInfo.FreqsFileEndOffset = endOffset
-
-		return dictWriter.Write([]byte(term), termInfo)
-	}
-
-	startDoc := func(docId DocumentId) error {
-		fieldDocIds.Add(uint32(docId))
-		termDocIds = append(termDocIds, uint32(docId))
-		termFreq = 0
-		return nil
-	}
-
-	endDoc := func() error {
-		termFreqs = append(termFreqs, termFreq)
-		return nil
-	}
+		if w.fieldOffsetsWriter != nil {
+			startOffsetsOffset, _endOffsetsOffset, err := w.fieldOffsetsWriter.WriteBlock(termFreqsInBatch, termOffsets[positionsStart:positionsEnd])
+			if err != nil {
+				return err
+			}
 
-	startPosition := func() error {
-		fieldSumTermFreq++
-		termFreq++
-		return nil
-	}
+			if !firstOffsetSet {
+				firstOffsetsOffset = startOffsetsOffset
+			}
 
-	for fieldId, fieldPostings := range w.postings {
-		fieldName = w.fieldNames[fieldId]
-		if err := startField(fieldName); err != nil {
-			return err
+			endOffsetsOffset = _endOffsetsOffset
 		}
 
-		sortedTerms := make([]string, 0, len(fieldPostings))
-		for term := range fieldPostings {
-			sortedTerms = append(sortedTerms, term)
+		positionsStart = positionsEnd
+
+		if !firstOffsetSet {
+			firstOffset = startOffset
+			firstOffsetSet = true
 		}
-		slices.Sort(sortedTerms)
 
-		for _, _term := range sortedTerms {
-			term = _term
-			termPostings := fieldPostings[term]
+		endOffset = _endOffset
+	}
 
-			if err := startTerm(); err != nil {
-				return err
-			}
+	for i, docId := range termDocIds {
+		w.fieldDocIds.Add(docId)
+		w.fieldSumTermFreq += termFreqs[i]
+	}
 
-			docId := termPostings[0].docId
+	w.termInfo.DocFreq = uint32(len(termDocIds))
+	w.termInfo.FreqsFileStartOffset = firstOffset
+	w.termInfo.FreqsFileEndOffset = endOffset
+	w.termInfo.PositionsFileStartOffset = firstPositionsOffset
+	w.termInfo.PositionsFileEndOffset = endPositionsOffset
+	w.termInfo.OffsetsFileStartOffset = firstOffsetsOffset
+	w.termInfo.OffsetsFileEndOffset = endOffsetsOffset
 
-			if err := startDoc(docId); err != nil {
-				return err
-			}
+	return w.dictWriter.Write(term, w.termInfo)
+}
 
-			for _, posting := range termPostings {
-				if posting.docId != docId {
-					if err := endDoc(); err != nil {
-						return err
-					}
+func (w *FieldPostingsWriter) Close() error {
+	if err := w.fieldStatsWriter.Write(uint32(w.fieldDocIds.GetCardinality()), w.fieldSumTermFreq); err != nil {
+		return err
+	}
 
-					docId = posting.docId
+	if err := w.fieldStatsWriter.Close(); err != nil {
+		return err
+	}
 
-					if err := startDoc(posting.docId); err != nil {
-						return err
-					}
-				}
+	if err := w.arrayStoreWriter.Append(w.fieldLengthIds); err != nil {
+		return err
+	}
 
-				if err := startPosition(); err != nil {
-					return err
-				}
-			}
+	if err := w.arrayStoreWriter.Close(); err != nil {
+		return err
+	}
 
-			if err := endDoc(); err != nil {
-				return err
-			}
+	if err := w.fieldFreqsWriter.Close(); err != nil {
+		return err
+	}
 
-			if err := endTerm(); err != nil {
-				return err
-			}
+	if w.fieldPositionsWriter != nil {
+		if err := w.fieldPositionsWriter.Close(); err != nil {
+			return err
 		}
+	}
 
-		if err := endField(); err != nil {
+	if w.fieldOffsetsWriter != nil {
+		if err := w.fieldOffsetsWriter.Close(); err != nil {
 			return err
 		}
 	}
 
+	if err := w.dictWriter.Close(); err != nil {
+		return err
+	}
+
 	return nil
 }
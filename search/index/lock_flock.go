@@ -0,0 +1,41 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package index
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// lockDirectory takes an exclusive flock on the lock file of the directory.
+// The lock is released by the kernel if the process dies.
+func lockDirectory(directory string) (*os.File, error) {
+	file, err := os.OpenFile(filepath.Join(directory, writeLockFilename), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		_ = file.Close()
+
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, ErrLocked
+		}
+
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// The lock file is not removed: another writer may already have opened it.
+func unlockDirectory(file *os.File) error {
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_UN); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	return file.Close()
+}
@@ -0,0 +1,33 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package index
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// lockDirectory creates the lock file of the directory, and fails if it
+// already exists. Unlike flock, the lock file is left behind if the process
+// dies and must then be removed by hand.
+func lockDirectory(directory string) (*os.File, error) {
+	file, err := os.OpenFile(filepath.Join(directory, writeLockFilename), os.O_CREATE|os.O_RDWR|os.O_EXCL, 0600)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return nil, ErrLocked
+		}
+
+		return nil, err
+	}
+
+	return file, nil
+}
+
+func unlockDirectory(file *os.File) error {
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Remove(file.Name())
+}
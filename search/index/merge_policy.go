@@ -0,0 +1,117 @@
+package index
+
+import "slices"
+
+// SegmentMergeInfo describes a segment of the current commit to a
+// MergePolicy.
+type SegmentMergeInfo struct {
+	Id              uint32
+	DocCount        uint32
+	DeletedDocCount uint32
+	// Format version of the files of the segment
+	Version uint32
+}
+
+func (info *SegmentMergeInfo) LiveDocCount() uint32 {
+	return info.DocCount - info.DeletedDocCount
+}
+
+// MergePolicy decides which segments are merged together. FindMerges is
+// called with the segments of the current commit and returns the ids of the
+// segments to merge, one slice per merge. A segment must not appear in more
+// than one merge. Returning no merge stops the merging.
+type MergePolicy interface {
+	FindMerges(segments []*SegmentMergeInfo) [][]uint32
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// NoMergePolicy
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+// NoMergePolicy never merges segments
+type NoMergePolicy struct {
+}
+
+func (policy *NoMergePolicy) FindMerges(segments []*SegmentMergeInfo) [][]uint32 {
+	return nil
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// LogMergePolicy
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+// LogMergePolicy groups segments into levels by the logarithm of their number
+// of live docs, in base MergeFactor. Whenever a level has MergeFactor
+// segments, they are merged into one segment of the next level. The number of
+// segments is thus logarithmic in the number of docs, and each doc is
+// rewritten a logarithmic number of times.
+type LogMergePolicy struct {
+	// Number of segments merged at once
+	MergeFactor int
+	// Segments smaller than this are all in the lowest level
+	MinMergeDocs uint32
+	// Segments with more live docs than this are never merged. 0 means no
+	// limit.
+	MaxMergeDocs uint32
+}
+
+func NewLogMergePolicy() *LogMergePolicy {
+	return &LogMergePolicy{
+		MergeFactor:  10,
+		MinMergeDocs: 1000,
+	}
+}
+
+// Integer logarithm of the number of live docs: math.Log gives levels one too
+// low for some powers of MergeFactor, e.g. 1000 in base 10
+func (policy *LogMergePolicy) level(segment *SegmentMergeInfo) int {
+	docCount := uint64(max(segment.LiveDocCount(), policy.MinMergeDocs, 1))
+	mergeFactor := uint64(policy.MergeFactor)
+
+	level := 0
+	for docCount >= mergeFactor {
+		docCount /= mergeFactor
+		level++
+	}
+
+	return level
+}
+
+func (policy *LogMergePolicy) FindMerges(segments []*SegmentMergeInfo) [][]uint32 {
+	if policy.MergeFactor < 2 {
+		return nil
+	}
+
+	// segmentsByLevel[level] in commit order
+	segmentsByLevel := make(map[int][]uint32)
+	levels := make([]int, 0, 10)
+
+	for _, segment := range segments {
+		if policy.MaxMergeDocs > 0 && segment.LiveDocCount() > policy.MaxMergeDocs {
+			continue
+		}
+
+		level := policy.level(segment)
+
+		if _, exists := segmentsByLevel[level]; !exists {
+			levels = append(levels, level)
+		}
+
+		segmentsByLevel[level] = append(segmentsByLevel[level], segment.Id)
+	}
+
+	slices.Sort(levels)
+
+	merges := make([][]uint32, 0)
+
+	for _, level := range levels {
+		segmentIds := segmentsByLevel[level]
+
+		for len(segmentIds) >= policy.MergeFactor {
+			merges = append(merges, segmentIds[:policy.MergeFactor])
+			segmentIds = segmentIds[policy.MergeFactor:]
+		}
+	}
+
+	return merges
+}
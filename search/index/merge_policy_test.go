@@ -0,0 +1,51 @@
+package index
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogMergePolicy(t *testing.T) {
+	policy := &LogMergePolicy{MergeFactor: 3, MinMergeDocs: 10, MaxMergeDocs: 5000}
+
+	segments := []*SegmentMergeInfo{
+		{Id: 1, DocCount: 9000},
+		{Id: 2, DocCount: 150, DeletedDocCount: 40},
+		{Id: 3, DocCount: 5},
+		{Id: 4, DocCount: 120},
+		{Id: 5, DocCount: 1},
+		{Id: 6, DocCount: 8, DeletedDocCount: 8},
+		{Id: 7, DocCount: 100},
+		{Id: 8, DocCount: 2},
+	}
+
+	// Segment 1 is too large, 3, 5, 6 and 8 are below MinMergeDocs and 2, 4
+	// and 7 are at the level of 100 live docs
+	assert.Equal(t, [][]uint32{{3, 5, 6}, {2, 4, 7}}, policy.FindMerges(segments))
+
+	assert.Empty(t, policy.FindMerges(segments[:3]))
+}
+
+func TestLogMergePolicyLevel(t *testing.T) {
+	policy := NewLogMergePolicy()
+
+	for docCount, level := range map[uint32]int{
+		1:             3,
+		999:           3,
+		1000:          3,
+		9999:          3,
+		10000:         4,
+		100000:        5,
+		1000000:       6,
+		10000000:      7,
+		100000000:     8,
+		1000000000:    9,
+		4294967295:    9,
+		1000000 - 1:   5,
+		10000000 + 1:  7,
+		100000000 - 1: 7,
+	} {
+		assert.Equal(t, level, policy.level(&SegmentMergeInfo{DocCount: docCount}), "%d docs", docCount)
+	}
+}
@@ -0,0 +1,115 @@
+package index
+
+import (
+	"unicode/utf8"
+
+	"github.com/larose/lynx/search/internal/norm"
+)
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// NFKCCharFilter
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+// NFKCCharFilter applies the Unicode NFKC normalization to the input, so that
+// equivalent characters have the same bytes: composed and decomposed accents,
+// full-width and half-width forms, ligatures, etc.
+type NFKCCharFilter struct {
+}
+
+func (f *NFKCCharFilter) Filter(input []byte) []byte {
+	return norm.NFKC.Bytes(input)
+}
+
+func (f *NFKCCharFilter) FilterWithOffsets(input []byte) ([]byte, *OffsetCorrection) {
+	if norm.NFKC.IsNormal(input) {
+		return input, nil
+	}
+
+	output := make([]byte, 0, len(input))
+	correction := &OffsetCorrection{}
+
+	var iter norm.Iter
+	iter.Init(norm.NFKC, input)
+
+	for !iter.Done() {
+		inputStart := iter.Pos()
+		outputStart := len(output)
+
+		output = append(output, iter.Next()...)
+		correction.Add(outputStart, len(output), inputStart, iter.Pos())
+	}
+
+	return output, correction
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// ASCIIFoldingFilter
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+// ASCIIFoldingFilter removes the accents and other diacritics of the tokens
+// and replaces the Latin letters without an ASCII decomposition by their
+// closest ASCII equivalent, e.g. "café" becomes "cafe" and "straße" becomes
+// "strasse". Other characters are left as is.
+type ASCIIFoldingFilter struct {
+}
+
+var asciiFoldings = map[rune]string{
+	'Æ': "AE", 'æ': "ae",
+	'Ð': "D", 'ð': "d",
+	'Đ': "D", 'đ': "d",
+	'Ħ': "H", 'ħ': "h",
+	'ı': "i",
+	'Ĳ': "IJ", 'ĳ': "ij",
+	'Ł': "L", 'ł': "l",
+	'Ŀ': "L", 'ŀ': "l",
+	'Ŋ': "N", 'ŋ': "n",
+	'Ø': "O", 'ø': "o",
+	'Œ': "OE", 'œ': "oe",
+	'ß': "ss",
+	'Þ': "TH", 'þ': "th",
+	'Ŧ': "T", 'ŧ': "t",
+}
+
+func (f *ASCIIFoldingFilter) Filter(token *Token) bool {
+	isASCII := true
+	for _, b := range token.Text {
+		if b >= utf8.RuneSelf {
+			isASCII = false
+			break
+		}
+	}
+
+	if isASCII {
+		return true
+	}
+
+	decomposed := norm.NFD.Bytes(token.Text)
+	folded := make([]byte, 0, len(decomposed))
+
+	for i := 0; i < len(decomposed); {
+		r, size := utf8.DecodeRune(decomposed[i:])
+		i += size
+
+		if isCombiningDiacriticalMark(r) {
+			continue
+		}
+
+		if folding, exists := asciiFoldings[r]; exists {
+			folded = append(folded, folding...)
+			continue
+		}
+
+		folded = utf8.AppendRune(folded, r)
+	}
+
+	// Recompose the characters that have no ASCII equivalent
+	token.Text = norm.NFC.Bytes(folded)
+	return true
+}
+
+// Only the marks of the Combining Diacritical Marks block are removed: the
+// marks of other scripts, e.g. the vowel signs of Devanagari, are part of the
+// letters.
+func isCombiningDiacriticalMark(r rune) bool {
+	return 0x0300 <= r && r <= 0x036F
+}
@@ -0,0 +1,20 @@
+package index
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNFKCCharFilter(t *testing.T) {
+	// Decomposed é, full-width letters and punctuation, ligature
+	terms := Analyze(NewStandardAnalyzer(), []byte("café ＷＩＫＩ，ｐｅｄｉａ ﬁle"))
+
+	assert.Equal(t, []string{"café", "wiki", "pedia", "file"}, toStrings(terms))
+}
+
+func TestASCIIFoldingFilter(t *testing.T) {
+	terms := Analyze(NewFoldingAnalyzer(), []byte("Café CAFE café Straße Øresund Łódź Ærø naïve São Paulo Ελλάδα किताब 東京"))
+
+	assert.Equal(t, []string{"cafe", "cafe", "cafe", "strasse", "oresund", "lodz", "aero", "naive", "sao", "paulo", "ελλαδα", "किताब", "東京"}, toStrings(terms))
+}
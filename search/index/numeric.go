@@ -0,0 +1,171 @@
+package index
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Numeric fields
+//
+// The value of an Int64FieldType, Float64FieldType or DateFieldType field is
+// 8 bytes, see EncodeInt64, EncodeFloat64 and EncodeDate. The encoding keeps
+// the order of the numbers in the order of the bytes, so the values are
+// stored and compared as uint64s.
+//
+// Each value is indexed as a trie of numericTermsPerValue terms: the value
+// with its last 0, 8, 16, ..., 56 bits dropped. A range of values is then
+// matched by a few terms of each precision, see NumericTermRanges, instead of
+// a term per value.
+
+// Bits dropped between two precisions of the trie
+const numericPrecisionStep = 8
+
+const numericTermsPerValue = 64 / numericPrecisionStep
+
+func EncodeInt64(value int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(value)^(1<<63))
+}
+
+func DecodeInt64(value []byte) int64 {
+	return int64(binary.BigEndian.Uint64(value) ^ (1 << 63))
+}
+
+// EncodeFloat64 orders -Inf < negative numbers < -0 < +0 < positive numbers <
+// +Inf. NaNs are ordered after +Inf, or before -Inf when their sign bit is
+// set.
+func EncodeFloat64(value float64) []byte {
+	bits := math.Float64bits(value)
+	if bits>>63 == 1 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+
+	return binary.BigEndian.AppendUint64(nil, bits)
+}
+
+func DecodeFloat64(value []byte) float64 {
+	bits := binary.BigEndian.Uint64(value)
+	if bits>>63 == 1 {
+		bits &^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+
+	return math.Float64frombits(bits)
+}
+
+// EncodeDate keeps the milliseconds since the Unix epoch
+func EncodeDate(value time.Time) []byte {
+	return EncodeInt64(value.UnixMilli())
+}
+
+// DecodeDate returns the date in UTC
+func DecodeDate(value []byte) time.Time {
+	return time.UnixMilli(DecodeInt64(value)).UTC()
+}
+
+func checkNumericValue(field *Field) error {
+	if len(field.Value) != 8 {
+		return fmt.Errorf("field %s: numeric value of %d bytes, expected 8", field.Name, len(field.Value))
+	}
+
+	return nil
+}
+
+// Returns the term of the value without its last shift bits: the shift,
+// followed by the bytes of the remaining bits
+func numericTerm(value uint64, shift int) []byte {
+	term := make([]byte, 1, 9)
+	term[0] = byte(shift)
+	return append(term, binary.BigEndian.AppendUint64(nil, value)[:(64-shift)/8]...)
+}
+
+// NumericTerm returns the term of the full precision of an encoded value, e.g.
+// for a TermNode on a numeric field
+func NumericTerm(value []byte) []byte {
+	return numericTerm(binary.BigEndian.Uint64(value), 0)
+}
+
+// Returns the terms of each precision of an encoded value
+func numericTerms(value []byte) [][]byte {
+	terms := make([][]byte, numericTermsPerValue)
+	for i := range terms {
+		terms[i] = numericTerm(binary.BigEndian.Uint64(value), i*numericPrecisionStep)
+	}
+
+	return terms
+}
+
+// TermRange is a range of terms, bounds included
+type TermRange struct {
+	Lower []byte
+	Upper []byte
+}
+
+// NumericTermRanges returns the ranges of trie terms matching the encoded
+// values from lower to upper, bounds included. Each range is within a single
+// precision, and the ranges don't overlap.
+func NumericTermRanges(lower, upper []byte) []TermRange {
+	min := binary.BigEndian.Uint64(lower)
+	max := binary.BigEndian.Uint64(upper)
+
+	ranges := make([]TermRange, 0, 2*numericTermsPerValue)
+
+	if min > max {
+		return ranges
+	}
+
+	addRange := func(min, max uint64, shift int) {
+		ranges = append(ranges, TermRange{Lower: numericTerm(min, shift), Upper: numericTerm(max, shift)})
+	}
+
+	// At each precision, the values of the partial blocks at both ends of the
+	// range are matched at this precision, and the full blocks in between at
+	// the next ones
+	for shift := 0; ; shift += numericPrecisionStep {
+		// Size of a block of the next precision, 0 at the last one
+		var diff uint64
+		if shift+numericPrecisionStep < 64 {
+			diff = 1 << (shift + numericPrecisionStep)
+		}
+
+		mask := uint64(1<<numericPrecisionStep-1) << shift
+
+		hasLower := min&mask != 0
+		hasUpper := max&mask != mask
+
+		nextMin := min
+		if hasLower {
+			nextMin += diff
+		}
+		nextMin &^= mask
+
+		nextMax := max
+		if hasUpper {
+			nextMax -= diff
+		}
+		nextMax &^= mask
+
+		lowerWrapped := nextMin < min
+		upperWrapped := nextMax > max
+
+		if diff == 0 || nextMin > nextMax || lowerWrapped || upperWrapped {
+			addRange(min, max, shift)
+			return ranges
+		}
+
+		if hasLower {
+			addRange(min, min|mask, shift)
+		}
+
+		if hasUpper {
+			addRange(max&^mask, max, shift)
+		}
+
+		min = nextMin
+		max = nextMax
+	}
+}
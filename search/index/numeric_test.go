@@ -0,0 +1,97 @@
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNumericEncoding(t *testing.T) {
+	ints := []int64{math.MinInt64, -1000, -1, 0, 1, 255, 256, math.MaxInt64}
+	for i, value := range ints {
+		assert.Equal(t, value, DecodeInt64(EncodeInt64(value)))
+
+		if i > 0 {
+			assert.Equal(t, -1, bytes.Compare(EncodeInt64(ints[i-1]), EncodeInt64(value)), value)
+		}
+	}
+
+	floats := []float64{math.Inf(-1), -math.MaxFloat64, -1.5, -math.SmallestNonzeroFloat64, math.Copysign(0, -1), 0, math.SmallestNonzeroFloat64, 1, 1.5, math.MaxFloat64, math.Inf(1)}
+	for i, value := range floats {
+		assert.Equal(t, math.Float64bits(value), math.Float64bits(DecodeFloat64(EncodeFloat64(value))))
+
+		if i > 0 {
+			assert.Equal(t, -1, bytes.Compare(EncodeFloat64(floats[i-1]), EncodeFloat64(value)), value)
+		}
+	}
+
+	date := time.Date(2024, 2, 29, 13, 14, 15, 16_000_000, time.UTC)
+	assert.Equal(t, date, DecodeDate(EncodeDate(date)))
+}
+
+// Returns the number of trie terms of the value in the ranges
+func inTermRanges(ranges []TermRange, value uint64) int {
+	count := 0
+
+	for _, term := range numericTerms(binary.BigEndian.AppendUint64(nil, value)) {
+		for _, termRange := range ranges {
+			if bytes.Compare(termRange.Lower, term) <= 0 && bytes.Compare(term, termRange.Upper) <= 0 {
+				count++
+			}
+		}
+	}
+
+	return count
+}
+
+func TestNumericTermRanges(t *testing.T) {
+	random := rand.New(rand.NewSource(3))
+
+	bounds := [][2]uint64{
+		{0, math.MaxUint64},
+		{0, 0},
+		{math.MaxUint64, math.MaxUint64},
+		{255, 256},
+		{256, 65535},
+		{1, math.MaxUint64 - 1},
+	}
+
+	for i := 0; i < 100; i++ {
+		min := random.Uint64()
+		max := min + uint64(random.Int63n(1<<uint(random.Intn(40)+1)))
+		if max < min {
+			max = math.MaxUint64
+		}
+
+		bounds = append(bounds, [2]uint64{min, max})
+	}
+
+	for _, bound := range bounds {
+		min, max := bound[0], bound[1]
+		ranges := NumericTermRanges(binary.BigEndian.AppendUint64(nil, min), binary.BigEndian.AppendUint64(nil, max))
+
+		assert.LessOrEqual(t, len(ranges), 2*numericTermsPerValue)
+
+		values := []uint64{min, max, min - 1, max + 1, min + (max-min)/2, 0, math.MaxUint64}
+		for j := 0; max > min && j < 20; j++ {
+			values = append(values, min+random.Uint64()%(max-min))
+		}
+
+		for _, value := range values {
+			expected := 0
+			if min <= value && value <= max {
+				expected = 1
+			}
+
+			// Exactly one term of the values in the range matches
+			assert.Equal(t, expected, inTermRanges(ranges, value), "%d in [%d, %d]", value, min, max)
+		}
+	}
+
+	assert.Empty(t, NumericTermRanges(EncodeInt64(1), EncodeInt64(0)))
+}
@@ -0,0 +1,184 @@
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"log"
+	"path/filepath"
+)
+
+// Offsets are the byte offsets of a token in the value of its field
+type Offsets struct {
+	Start uint32
+	End   uint32
+}
+
+type FieldOffsetsWriter struct {
+	file   *codecFileWriter
+	offset int64
+}
+
+func newFieldOffsetsWriter(directory, segment, fieldName string) (*FieldOffsetsWriter, error) {
+	file, err := createCodecFile(filepath.Join(directory, "segment."+segment+"."+fieldName+".offsets"), offsetsCodec)
+	if err != nil {
+		return nil, err
+	}
+
+	// Offsets are relative to the end of the header
+	return &FieldOffsetsWriter{
+		file: file,
+	}, nil
+}
+
+/*
+Block (one per frequencies block):
+  - [0] length bytes (uint32)
+  - Term offsets of each doc of the frequencies block, in the order of the
+    positions: start (delta encoded) and length
+*/
+const offsetsHeaderSize = 4
+
+func (writer *FieldOffsetsWriter) WriteBlock(termFreqs []uint64, offsets []Offsets) (uint64, uint64, error) {
+	blockStartOffset := writer.offset
+
+	buffer := make([]byte, offsetsHeaderSize, len(offsets)*3+offsetsHeaderSize)
+
+	start := 0
+	for _, termFreq := range termFreqs {
+		previousStart := uint32(0)
+
+		for _, termOffsets := range offsets[start : start+int(termFreq)] {
+			buffer = binary.AppendUvarint(buffer, uint64(termOffsets.Start-previousStart))
+			buffer = binary.AppendUvarint(buffer, uint64(termOffsets.End-termOffsets.Start))
+			previousStart = termOffsets.Start
+		}
+
+		start += int(termFreq)
+	}
+
+	binary.BigEndian.PutUint32(buffer, uint32(len(buffer)))
+
+	writer.offset = blockStartOffset + int64(len(buffer))
+
+	_, err := writer.file.Write(buffer)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return uint64(blockStartOffset), uint64(writer.offset), nil
+}
+
+func (w *FieldOffsetsWriter) Close() error {
+	return w.file.Close()
+}
+
+type FieldOffsetsReader struct {
+	fileReader FileReader
+}
+
+func newFieldOffsetsReader(directory, segment, fieldName string, version uint32) (*FieldOffsetsReader, error) {
+	fileReader, err := newFileReader(filepath.Join(directory, "segment."+segment+"."+fieldName+".offsets"), offsetsCodec, version)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FieldOffsetsReader{
+		fileReader: *fileReader,
+	}, nil
+}
+
+func (reader *FieldOffsetsReader) Close() error {
+	return reader.fileReader.Close()
+}
+
+func (reader *FieldOffsetsReader) TermOffsetsIterator(freqsIterator *TermFreqsIterator, termInfo *TermInfo) *TermOffsetsIterator {
+	return newTermOffsetsIterator(freqsIterator, reader.fileReader, termInfo)
+}
+
+// TermOffsetsIterator iterates over the same docs as its TermFreqsIterator
+// and gives access to the term offsets of the current doc. It can share its
+// TermFreqsIterator with a TermPositionsIterator.
+type TermOffsetsIterator struct {
+	*TermFreqsIterator
+
+	reader *bytes.Reader
+
+	// index of the offsets block at the current position of reader
+	nextBlockIndex int
+
+	// Block data
+	decodedBlockIndex int
+	blockOffsets      []Offsets
+	// blockDocStarts[indexInBlockId] is the index of the first offsets of
+	// the doc in blockOffsets
+	blockDocStarts []int
+}
+
+func newTermOffsetsIterator(freqsIterator *TermFreqsIterator, fileReader FileReader, termInfo *TermInfo) *TermOffsetsIterator {
+	data := fileReader.Slice(termInfo.OffsetsFileStartOffset, termInfo.OffsetsFileEndOffset)
+
+	return &TermOffsetsIterator{
+		TermFreqsIterator: freqsIterator,
+		reader:            bytes.NewReader(data),
+		decodedBlockIndex: -1,
+		blockOffsets:      make([]Offsets, 0, 128),
+		blockDocStarts:    make([]int, 0, 128),
+	}
+}
+
+// Offsets returns the offsets of the term in the current doc, in the order of
+// its positions. Must only be called after Next returned true. The slice is
+// valid until the next call to Next.
+func (it *TermOffsetsIterator) Offsets() []Offsets {
+	freqsIterator := it.TermFreqsIterator
+
+	if it.decodedBlockIndex != freqsIterator.blockIndex {
+		var length uint32
+
+		for it.nextBlockIndex < freqsIterator.blockIndex {
+			if err := binary.Read(it.reader, binary.BigEndian, &length); err != nil {
+				log.Fatal(err)
+			}
+
+			if _, err := it.reader.Seek(int64(length)-offsetsHeaderSize, io.SeekCurrent); err != nil {
+				log.Fatal(err)
+			}
+
+			it.nextBlockIndex++
+		}
+
+		if err := binary.Read(it.reader, binary.BigEndian, &length); err != nil {
+			log.Fatal(err)
+		}
+
+		it.blockOffsets = it.blockOffsets[:0]
+		it.blockDocStarts = it.blockDocStarts[:0]
+
+		for _, termFreq := range freqsIterator.blockFreqs {
+			it.blockDocStarts = append(it.blockDocStarts, len(it.blockOffsets))
+
+			start := uint64(0)
+			for i := uint64(0); i < termFreq; i++ {
+				delta, err := binary.ReadUvarint(it.reader)
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				length, err := binary.ReadUvarint(it.reader)
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				start += delta
+				it.blockOffsets = append(it.blockOffsets, Offsets{Start: uint32(start), End: uint32(start + length)})
+			}
+		}
+
+		it.decodedBlockIndex = it.nextBlockIndex
+		it.nextBlockIndex++
+	}
+
+	start := it.blockDocStarts[freqsIterator.indexInBlockId]
+	return it.blockOffsets[start : start+int(freqsIterator.TermFreq())]
+}
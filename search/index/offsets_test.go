@@ -0,0 +1,118 @@
+package index
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Returns the offsets of the term in each doc of the segment containing it
+func readTermOffsets(t *testing.T, segmentReader *SegmentReader, fieldName, term string) [][]Offsets {
+	dictionaryReader, err := segmentReader.DictionaryReader(fieldName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fieldFreqsReader, err := segmentReader.FieldFreqsReader(fieldName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fieldOffsetsReader, err := segmentReader.FieldOffsetsReader(fieldName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	termInfo := dictionaryReader.Get([]byte(term))
+	it := fieldOffsetsReader.TermOffsetsIterator(fieldFreqsReader.TermFreqsIterator(termInfo), termInfo)
+
+	offsets := make([][]Offsets, 0)
+	for docId := DocumentId(0); it.Next(docId); docId = it.DocId() + 1 {
+		offsets = append(offsets, append([]Offsets(nil), it.Offsets()...))
+	}
+
+	return offsets
+}
+
+func TestOffsets(t *testing.T) {
+	directory := filepath.Join("testdata", "offsets")
+	os.RemoveAll(directory)
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	indexWriter, err := NewIndexWriter(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer indexWriter.Close()
+
+	indexWriter.SetMergePolicy(&NoMergePolicy{})
+
+	schema := NewSchema(StandardAnalyzerName)
+	schema.SetFieldOffsets("body", true)
+	indexWriter.SetSchema(schema)
+
+	for _, body := range []string{"The quick fox, the lazy fox", "Ünïcode fox"} {
+		doc := Document{
+			{Name: "body", FieldType: TextFieldType, Value: []byte(body)},
+			{Name: "title", FieldType: TextFieldType, Value: []byte("fox")},
+		}
+
+		if err := indexWriter.AddDocuments([]Document{doc}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	indexReader, err := NewIndexReader(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer indexReader.Close()
+
+	assert.Len(t, indexReader.SegmentReaders, 2)
+	assert.Equal(t, []string{"body"}, indexReader.SegmentReaders[0].Info.OffsetFields)
+	assert.Equal(t, [][]Offsets{{{10, 13}, {24, 27}}}, readTermOffsets(t, indexReader.SegmentReaders[0], "body", "fox"))
+	assert.Equal(t, [][]Offsets{{{0, 9}}}, readTermOffsets(t, indexReader.SegmentReaders[1], "body", "ünïcode"))
+
+	// Merged with their offsets
+	indexWriter.SetMergePolicy(&LogMergePolicy{MergeFactor: 2, MinMergeDocs: 10})
+
+	if err := indexWriter.Merge(); err != nil {
+		t.Fatal(err)
+	}
+
+	indexReader, err = indexReader.Reopen()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer indexReader.Close()
+
+	assert.Len(t, indexReader.SegmentReaders, 1)
+	assert.Equal(t, []string{"body"}, indexReader.SegmentReaders[0].Info.OffsetFields)
+	assert.Equal(t, [][]Offsets{{{10, 13}, {24, 27}}, {{10, 13}}}, readTermOffsets(t, indexReader.SegmentReaders[0], "body", "fox"))
+	assert.NoError(t, CheckIndex(directory))
+
+	// Dropped when merged with a segment without offsets
+	indexWriter.SetSchema(NewSchema(StandardAnalyzerName))
+
+	if err := indexWriter.AddDocuments([]Document{{{Name: "body", FieldType: TextFieldType, Value: []byte("fox")}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	indexReader, err = indexReader.Reopen()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer indexReader.Close()
+
+	assert.Len(t, indexReader.SegmentReaders, 1)
+	assert.Empty(t, indexReader.SegmentReaders[0].Info.OffsetFields)
+	assert.NoError(t, CheckIndex(directory))
+}
@@ -0,0 +1,345 @@
+package index
+
+// PorterStemFilter reduces English words to their stem with the Porter
+// stemming algorithm, so that "connected", "connecting" and "connection" are
+// all indexed as "connect". Tokens must be lowercased first. Tokens with
+// other characters than a to z are left as is.
+//
+// See https://tartarus.org/martin/PorterStemmer/
+type PorterStemFilter struct {
+}
+
+func (f *PorterStemFilter) Filter(token *Token) bool {
+	for _, b := range token.Text {
+		if b < 'a' || 'z' < b {
+			return true
+		}
+	}
+
+	token.Text = porterStem(token.Text)
+	return true
+}
+
+// porterStem stems word in place. The stem is never longer than the word.
+func porterStem(word []byte) []byte {
+	if len(word) <= 2 {
+		return word
+	}
+
+	s := &porterStemmer{b: word, k: len(word) - 1}
+
+	s.step1ab()
+
+	if s.k > 0 {
+		s.step1c()
+		s.step2()
+		s.step3()
+		s.step4()
+		s.step5()
+	}
+
+	return word[:s.k+1]
+}
+
+// b[0:k+1] is the word being stemmed and j is a general offset into it
+type porterStemmer struct {
+	b []byte
+	j int
+	k int
+}
+
+// cons returns true if b[i] is a consonant
+func (s *porterStemmer) cons(i int) bool {
+	switch s.b[i] {
+	case 'a', 'e', 'i', 'o', 'u':
+		return false
+	case 'y':
+		if i == 0 {
+			return true
+		}
+
+		return !s.cons(i - 1)
+	}
+
+	return true
+}
+
+// m measures the number of consonant sequences in b[0:j+1]. With c a
+// consonant sequence and v a vowel sequence:
+//
+//	[c][v]       gives 0
+//	[c]vc[v]     gives 1
+//	[c]vcvc[v]   gives 2
+func (s *porterStemmer) m() int {
+	n := 0
+	i := 0
+
+	for {
+		if i > s.j {
+			return n
+		}
+
+		if !s.cons(i) {
+			break
+		}
+
+		i++
+	}
+
+	i++
+
+	for {
+		for {
+			if i > s.j {
+				return n
+			}
+
+			if s.cons(i) {
+				break
+			}
+
+			i++
+		}
+
+		i++
+		n++
+
+		for {
+			if i > s.j {
+				return n
+			}
+
+			if !s.cons(i) {
+				break
+			}
+
+			i++
+		}
+
+		i++
+	}
+}
+
+// vowelInStem returns true if b[0:j+1] contains a vowel
+func (s *porterStemmer) vowelInStem() bool {
+	for i := 0; i <= s.j; i++ {
+		if !s.cons(i) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// doubleC returns true if b[j-1:j+1] is a double consonant
+func (s *porterStemmer) doubleC(j int) bool {
+	if j < 1 {
+		return false
+	}
+
+	if s.b[j] != s.b[j-1] {
+		return false
+	}
+
+	return s.cons(j)
+}
+
+// cvc returns true if b[i-2:i+1] is consonant - vowel - consonant and the
+// second consonant is not w, x or y. It restores an e at the end of a short
+// word, e.g. cav(e), lov(e), hop(e), crim(e), but not snow, box or tray.
+func (s *porterStemmer) cvc(i int) bool {
+	if i < 2 || !s.cons(i) || s.cons(i-1) || !s.cons(i-2) {
+		return false
+	}
+
+	switch s.b[i] {
+	case 'w', 'x', 'y':
+		return false
+	}
+
+	return true
+}
+
+// ends returns true if b[0:k+1] ends with suffix, and sets j to the end of
+// the stem before the suffix
+func (s *porterStemmer) ends(suffix string) bool {
+	length := len(suffix)
+
+	if length > s.k+1 {
+		return false
+	}
+
+	if string(s.b[s.k-length+1:s.k+1]) != suffix {
+		return false
+	}
+
+	s.j = s.k - length
+	return true
+}
+
+// setTo replaces b[j+1:k+1] with suffix
+func (s *porterStemmer) setTo(suffix string) {
+	copy(s.b[s.j+1:], suffix)
+	s.k = s.j + len(suffix)
+}
+
+func (s *porterStemmer) r(suffix string) {
+	if s.m() > 0 {
+		s.setTo(suffix)
+	}
+}
+
+// step1ab removes plurals and -ed or -ing, e.g.
+//
+//	caresses  ->  caress
+//	ponies    ->  poni
+//	cats      ->  cat
+//	feed      ->  feed
+//	agreed    ->  agree
+//	plastered ->  plaster
+//	motoring  ->  motor
+//	hopping   ->  hop
+//	filing    ->  file
+func (s *porterStemmer) step1ab() {
+	if s.b[s.k] == 's' {
+		if s.ends("sses") {
+			s.k -= 2
+		} else if s.ends("ies") {
+			s.setTo("i")
+		} else if s.b[s.k-1] != 's' {
+			s.k--
+		}
+	}
+
+	if s.ends("eed") {
+		if s.m() > 0 {
+			s.k--
+		}
+	} else if (s.ends("ed") || s.ends("ing")) && s.vowelInStem() {
+		s.k = s.j
+
+		if s.ends("at") {
+			s.setTo("ate")
+		} else if s.ends("bl") {
+			s.setTo("ble")
+		} else if s.ends("iz") {
+			s.setTo("ize")
+		} else if s.doubleC(s.k) {
+			s.k--
+
+			switch s.b[s.k] {
+			case 'l', 's', 'z':
+				s.k++
+			}
+		} else {
+			s.j = s.k
+			if s.m() == 1 && s.cvc(s.k) {
+				s.setTo("e")
+			}
+		}
+	}
+}
+
+// step1c turns a terminal y into i when there is another vowel in the stem
+func (s *porterStemmer) step1c() {
+	if s.ends("y") && s.vowelInStem() {
+		s.b[s.k] = 'i'
+	}
+}
+
+// step2 maps double suffixes to single ones, e.g. -ization (-ize + -ation)
+// to -ize, when the stem has m > 0
+func (s *porterStemmer) step2() {
+	suffixes := porterStep2Suffixes[s.b[s.k-1]]
+
+	for _, suffix := range suffixes {
+		if s.ends(suffix[0]) {
+			s.r(suffix[1])
+			return
+		}
+	}
+}
+
+var porterStep2Suffixes = map[byte][][2]string{
+	'a': {{"ational", "ate"}, {"tional", "tion"}},
+	'c': {{"enci", "ence"}, {"anci", "ance"}},
+	'e': {{"izer", "ize"}},
+	'l': {{"bli", "ble"}, {"alli", "al"}, {"entli", "ent"}, {"eli", "e"}, {"ousli", "ous"}},
+	'o': {{"ization", "ize"}, {"ation", "ate"}, {"ator", "ate"}},
+	's': {{"alism", "al"}, {"iveness", "ive"}, {"fulness", "ful"}, {"ousness", "ous"}},
+	't': {{"aliti", "al"}, {"iviti", "ive"}, {"biliti", "ble"}},
+	'g': {{"logi", "log"}},
+}
+
+// step3 handles -ic-, -full, -ness etc.
+func (s *porterStemmer) step3() {
+	suffixes := porterStep3Suffixes[s.b[s.k]]
+
+	for _, suffix := range suffixes {
+		if s.ends(suffix[0]) {
+			s.r(suffix[1])
+			return
+		}
+	}
+}
+
+var porterStep3Suffixes = map[byte][][2]string{
+	'e': {{"icate", "ic"}, {"ative", ""}, {"alize", "al"}},
+	'i': {{"iciti", "ic"}},
+	'l': {{"ical", "ic"}, {"ful", ""}},
+	's': {{"ness", ""}},
+}
+
+// step4 removes -ant, -ence etc. when the stem has m > 1
+func (s *porterStemmer) step4() {
+	found := false
+
+	for _, suffix := range porterStep4Suffixes[s.b[s.k-1]] {
+		if !s.ends(suffix) {
+			continue
+		}
+
+		// -ion is only removed after s or t
+		if suffix == "ion" && (s.j < 0 || (s.b[s.j] != 's' && s.b[s.j] != 't')) {
+			continue
+		}
+
+		found = true
+		break
+	}
+
+	if found && s.m() > 1 {
+		s.k = s.j
+	}
+}
+
+var porterStep4Suffixes = map[byte][]string{
+	'a': {"al"},
+	'c': {"ance", "ence"},
+	'e': {"er"},
+	'i': {"ic"},
+	'l': {"able", "ible"},
+	'n': {"ant", "ement", "ment", "ent"},
+	'o': {"ion", "ou"},
+	's': {"ism"},
+	't': {"ate", "iti"},
+	'u': {"ous"},
+	'v': {"ive"},
+	'z': {"ize"},
+}
+
+// step5 removes a final -e when m > 1, and changes -ll to -l when m > 1
+func (s *porterStemmer) step5() {
+	s.j = s.k
+
+	if s.b[s.k] == 'e' {
+		a := s.m()
+		if a > 1 || (a == 1 && !s.cvc(s.k-1)) {
+			s.k--
+		}
+	}
+
+	if s.b[s.k] == 'l' && s.doubleC(s.k) && s.m() > 1 {
+		s.k--
+	}
+}
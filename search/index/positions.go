@@ -0,0 +1,170 @@
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"log"
+	"path/filepath"
+)
+
+type FieldPositionsWriter struct {
+	file   *codecFileWriter
+	offset int64
+}
+
+func newFieldPositionsWriter(directory, segment, fieldName string) (*FieldPositionsWriter, error) {
+	file, err := createCodecFile(filepath.Join(directory, "segment."+segment+"."+fieldName+".positions"), positionsCodec)
+	if err != nil {
+		return nil, err
+	}
+
+	// Offsets are relative to the end of the header
+	return &FieldPositionsWriter{
+		file: file,
+	}, nil
+}
+
+/*
+Block (one per frequencies block):
+  - [0] length bytes (uint32)
+  - Term positions of each doc of the frequencies block (delta encoded)
+*/
+const positionsHeaderSize = 4
+
+func (writer *FieldPositionsWriter) WriteBlock(termFreqs []uint64, positions []uint64) (uint64, uint64, error) {
+	blockStartOffset := writer.offset
+
+	buffer := make([]byte, positionsHeaderSize, len(positions)*2+positionsHeaderSize)
+
+	start := 0
+	for _, termFreq := range termFreqs {
+		previousPosition := uint64(0)
+
+		for _, position := range positions[start : start+int(termFreq)] {
+			buffer = binary.AppendUvarint(buffer, position-previousPosition)
+			previousPosition = position
+		}
+
+		start += int(termFreq)
+	}
+
+	binary.BigEndian.PutUint32(buffer, uint32(len(buffer)))
+
+	writer.offset = blockStartOffset + int64(len(buffer))
+
+	_, err := writer.file.Write(buffer)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return uint64(blockStartOffset), uint64(writer.offset), nil
+}
+
+func (w *FieldPositionsWriter) Close() error {
+	return w.file.Close()
+}
+
+type FieldPositionsReader struct {
+	fileReader FileReader
+}
+
+func newFieldPositionsReader(directory, segment, fieldName string, version uint32) (*FieldPositionsReader, error) {
+	fileReader, err := newFileReader(filepath.Join(directory, "segment."+segment+"."+fieldName+".positions"), positionsCodec, version)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FieldPositionsReader{
+		fileReader: *fileReader,
+	}, nil
+}
+
+func (reader *FieldPositionsReader) Close() error {
+	return reader.fileReader.Close()
+}
+
+func (reader *FieldPositionsReader) TermPositionsIterator(freqsIterator *TermFreqsIterator, termInfo *TermInfo) *TermPositionsIterator {
+	return newTermPositionsIterator(freqsIterator, reader.fileReader, termInfo)
+}
+
+// TermPositionsIterator iterates over the same docs as its TermFreqsIterator
+// and gives access to the term positions of the current doc.
+type TermPositionsIterator struct {
+	*TermFreqsIterator
+
+	reader *bytes.Reader
+
+	// index of the positions block at the current position of reader
+	nextBlockIndex int
+
+	// Block data
+	decodedBlockIndex int
+	blockPositions    []uint64
+	// blockDocStarts[indexInBlockId] is the index of the first position of
+	// the doc in blockPositions
+	blockDocStarts []int
+}
+
+func newTermPositionsIterator(freqsIterator *TermFreqsIterator, fileReader FileReader, termInfo *TermInfo) *TermPositionsIterator {
+	data := fileReader.Slice(termInfo.PositionsFileStartOffset, termInfo.PositionsFileEndOffset)
+
+	return &TermPositionsIterator{
+		TermFreqsIterator: freqsIterator,
+		reader:            bytes.NewReader(data),
+		decodedBlockIndex: -1,
+		blockPositions:    make([]uint64, 0, 128),
+		blockDocStarts:    make([]int, 0, 128),
+	}
+}
+
+// Positions returns the positions of the term in the current doc. Must only be
+// called after Next returned true. The slice is valid until the next call to
+// Next.
+func (it *TermPositionsIterator) Positions() []uint64 {
+	freqsIterator := it.TermFreqsIterator
+
+	if it.decodedBlockIndex != freqsIterator.blockIndex {
+		var length uint32
+
+		for it.nextBlockIndex < freqsIterator.blockIndex {
+			if err := binary.Read(it.reader, binary.BigEndian, &length); err != nil {
+				log.Fatal(err)
+			}
+
+			if _, err := it.reader.Seek(int64(length)-positionsHeaderSize, io.SeekCurrent); err != nil {
+				log.Fatal(err)
+			}
+
+			it.nextBlockIndex++
+		}
+
+		if err := binary.Read(it.reader, binary.BigEndian, &length); err != nil {
+			log.Fatal(err)
+		}
+
+		it.blockPositions = it.blockPositions[:0]
+		it.blockDocStarts = it.blockDocStarts[:0]
+
+		for _, termFreq := range freqsIterator.blockFreqs {
+			it.blockDocStarts = append(it.blockDocStarts, len(it.blockPositions))
+
+			position := uint64(0)
+			for i := uint64(0); i < termFreq; i++ {
+				delta, err := binary.ReadUvarint(it.reader)
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				position += delta
+				it.blockPositions = append(it.blockPositions, position)
+			}
+		}
+
+		it.decodedBlockIndex = it.nextBlockIndex
+		it.nextBlockIndex++
+	}
+
+	start := it.blockDocStarts[freqsIterator.indexInBlockId]
+	return it.blockPositions[start : start+int(freqsIterator.TermFreq())]
+}
@@ -0,0 +1,147 @@
+package index
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTermPositionsIterator(t *testing.T) {
+	directory := filepath.Join("testdata", "positions")
+	os.RemoveAll(directory)
+	os.MkdirAll(directory, 0700)
+
+	writer := newInvertedIndexWriter(NewSchema(StandardAnalyzerName))
+
+	// Doc i contains "a" at positions 0, 2, ..., 2 * (i % 3)
+	numDocs := 500
+	for docId := 0; docId < numDocs; docId++ {
+		writer.Doc(DocumentId(docId))
+		writer.Field("body", nil)
+		for i := 0; i <= docId%3; i++ {
+			writer.Term(&Token{Text: []byte("a")})
+			writer.Term(&Token{Text: []byte("b")})
+		}
+		writer.EndField()
+	}
+
+	if err := writer.Write(directory, "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	dictionaryReader, err := newDictionaryReader(directory, "1", "body", formatVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fieldFreqsReader, err := newFieldFreqsReader(directory, "1", "body", formatVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fieldPositionsReader, err := newFieldPositionsReader(directory, "1", "body", formatVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	termInfo := dictionaryReader.Get([]byte("a"))
+	it := fieldPositionsReader.TermPositionsIterator(fieldFreqsReader.TermFreqsIterator(termInfo), termInfo)
+
+	// Skips whole blocks in between
+	for _, docId := range []DocumentId{0, 1, 2, 130, 131, 400, 499} {
+		assert.True(t, it.Next(docId))
+		assert.Equal(t, docId, it.DocId())
+
+		expectedPositions := make([]uint64, 0, 3)
+		for i := 0; i <= int(docId)%3; i++ {
+			expectedPositions = append(expectedPositions, uint64(2*i))
+		}
+
+		assert.Equal(t, expectedPositions, it.Positions())
+	}
+
+	assert.False(t, it.Next(DocumentId(numDocs)))
+}
+
+func TestDecodeTermInfoWithoutPositions(t *testing.T) {
+	value := binary.BigEndian.AppendUint32(nil, 3)
+	value = binary.BigEndian.AppendUint64(value, 10)
+	value = binary.BigEndian.AppendUint64(value, 20)
+
+	assert.Equal(t, &TermInfo{DocFreq: 3, FreqsFileStartOffset: 10, FreqsFileEndOffset: 20}, decodeTermInfo(value))
+}
+
+func TestMergeWithoutPositions(t *testing.T) {
+	directory := filepath.Join("testdata", "merge_without_positions")
+	os.RemoveAll(directory)
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	indexWriter, err := NewIndexWriter(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer indexWriter.Close()
+
+	indexWriter.SetMergePolicy(&NoMergePolicy{})
+
+	for _, firstId := range []uint64{0, 20} {
+		if err := indexWriter.AddDocuments(crashTestDocuments(firstId, 20)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	commit, err := readCommit(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The body of the first segment has no positions, as in the segments
+	// written before the positions
+	segment := strconv.FormatUint(uint64(commit.SegmentIds[0]), 10)
+	segmentInfo, err := readSegmentInfo(directory, segment)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	segmentInfo.NoPositionsFields = []string{"body"}
+
+	for _, name := range []string{"info", "body.positions"} {
+		if err := os.Remove(filepath.Join(directory, "segment."+segment+"."+name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := writeSegmentInfo(directory, segment, segmentInfo); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.NoError(t, CheckIndex(directory))
+	ids := readTestIndexIds(t, directory)
+	assert.Len(t, ids, 40)
+
+	indexWriter.SetMergePolicy(&LogMergePolicy{MergeFactor: 2, MinMergeDocs: 100})
+	if err := indexWriter.Merge(); err != nil {
+		t.Fatal(err)
+	}
+
+	indexReader, err := NewIndexReader(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer indexReader.Close()
+
+	// Only the fields with positions in all the merged segments keep them
+	assert.Len(t, indexReader.SegmentReaders, 1)
+	assert.False(t, indexReader.SegmentReaders[0].Info.HasPositions("body"))
+	assert.True(t, indexReader.SegmentReaders[0].Info.HasPositions("id"))
+	assert.Equal(t, ids, readLiveIds(t, indexReader))
+	assert.NoError(t, CheckIndex(directory))
+}
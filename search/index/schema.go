@@ -0,0 +1,63 @@
+package index
+
+// Schema gives the analyzer of each text field, the fields whose token
+// offsets are indexed and the doc values of the fields. Fields without their
+// own analyzer use the default analyzer. Analyzers are referenced by their
+// registered name, see RegisterAnalyzer.
+type Schema struct {
+	defaultAnalyzer string
+	docValuesTypes  map[string]DocValuesType
+	fieldAnalyzers  map[string]string
+	offsetFields    map[string]bool
+}
+
+func NewSchema(defaultAnalyzer string) *Schema {
+	return &Schema{
+		defaultAnalyzer: defaultAnalyzer,
+		docValuesTypes:  make(map[string]DocValuesType),
+		fieldAnalyzers:  make(map[string]string),
+		offsetFields:    make(map[string]bool),
+	}
+}
+
+func (schema *Schema) SetFieldAnalyzer(fieldName string, analyzer string) {
+	schema.fieldAnalyzers[fieldName] = analyzer
+}
+
+// SetFieldOffsets sets whether the writer indexes the offsets of the tokens
+// of the field in the source value, next to their positions. They let
+// highlighting find the matches without analyzing the value again.
+func (schema *Schema) SetFieldOffsets(fieldName string, offsets bool) {
+	schema.offsetFields[fieldName] = offsets
+}
+
+func (schema *Schema) FieldOffsets(fieldName string) bool {
+	return schema.offsetFields[fieldName]
+}
+
+// SetFieldDocValues sets the doc values the writer writes for the values of
+// the field, next to the stored values. Doc values are read by local doc id
+// in constant time, e.g. to sort the results of a search.
+func (schema *Schema) SetFieldDocValues(fieldName string, docValuesType DocValuesType) {
+	schema.docValuesTypes[fieldName] = docValuesType
+}
+
+func (schema *Schema) FieldDocValues(fieldName string) DocValuesType {
+	return schema.docValuesTypes[fieldName]
+}
+
+// AnalyzerName returns the name of the analyzer of the field
+func (schema *Schema) AnalyzerName(fieldName string) string {
+	analyzer, exists := schema.fieldAnalyzers[fieldName]
+	if !exists {
+		return schema.defaultAnalyzer
+	}
+
+	return analyzer
+}
+
+// Analyzer returns the analyzer of the field. Returns an error if its name is
+// not registered.
+func (schema *Schema) Analyzer(fieldName string) (Analyzer, error) {
+	return analyzerByName(schema.AnalyzerName(fieldName))
+}
@@ -17,6 +17,6 @@ type SegmentComponentWriter interface {
 	Doc(docId DocumentId)
 	Field(fieldName string, value []byte)
 	EndField()
-	Term(term []byte)
+	Term(token *Token)
 	Write(directory, segmentId string) error
 }
@@ -0,0 +1,230 @@
+package index
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+type SegmentInfo struct {
+	DocCount uint32   `json:"docCount"`
+	Fields   []string `json:"fields"`
+	// Fields with an offsets file
+	OffsetFields []string `json:"offsetFields,omitempty"`
+	// Fields with a doc values file
+	DocValuesFields []string `json:"docValuesFields,omitempty"`
+	// Fields without a positions file: the fields of the segments written
+	// before the positions, and of the segments merged from them
+	NoPositionsFields []string `json:"noPositionsFields,omitempty"`
+	// Name of the analyzer of each text field, see RegisterAnalyzer. Missing
+	// for the segments written before the analyzers were saved.
+	Analyzers map[string]string `json:"analyzers,omitempty"`
+	// Format version of the files of the segment
+	Version uint32 `json:"version,omitempty"`
+}
+
+func readSegmentInfo(directory, segmentId string) (*SegmentInfo, error) {
+	file, err := os.Open(filepath.Join(directory, "segment."+segmentId+".info"))
+	if os.IsNotExist(err) {
+		return deriveSegmentInfo(directory, segmentId, err)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	var segmentInfo SegmentInfo
+	if err := json.NewDecoder(file).Decode(&segmentInfo); err != nil {
+		return nil, err
+	}
+
+	if err := checkFormatVersion(file.Name(), segmentInfo.Version); err != nil {
+		return nil, err
+	}
+
+	return &segmentInfo, nil
+}
+
+// deriveSegmentInfo builds the info of a segment written before the segment
+// infos from its files: the fields are the fields with a frequencies file and
+// the doc count is the largest number of field lengths, one byte per doc.
+// These segments have no positions, offsets or doc values, and their text
+// fields were analyzed by the standard analyzer of version 0. Byte fields can't
+// be told apart and get it too, which is harmless since their values are not
+// analyzed. notExistErr is returned if the segment has no field.
+func deriveSegmentInfo(directory, segmentId string, notExistErr error) (*SegmentInfo, error) {
+	prefix := "segment." + segmentId + "."
+
+	filenames, err := filepath.Glob(filepath.Join(directory, prefix+"*.frequencies"))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(filenames) == 0 {
+		return nil, notExistErr
+	}
+
+	segmentInfo := &SegmentInfo{Analyzers: make(map[string]string)}
+
+	for _, filename := range filenames {
+		fieldName := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(filename), prefix), ".frequencies")
+
+		// Files with a header were written with a segment info
+		header := make([]byte, 4)
+		if err := readFileHeader(filename, header); err != nil {
+			return nil, err
+		}
+
+		if binary.BigEndian.Uint32(header) == codecMagic {
+			return nil, fmt.Errorf("segment %s: %w", segmentId, notExistErr)
+		}
+
+		fileInfo, err := os.Stat(filepath.Join(directory, prefix+fieldName+".lengths"))
+		if err != nil {
+			return nil, err
+		}
+
+		segmentInfo.DocCount = max(segmentInfo.DocCount, uint32(fileInfo.Size()))
+		segmentInfo.Fields = append(segmentInfo.Fields, fieldName)
+		segmentInfo.Analyzers[fieldName] = StandardV0AnalyzerName
+	}
+
+	slices.Sort(segmentInfo.Fields)
+	segmentInfo.NoPositionsFields = segmentInfo.Fields
+
+	return segmentInfo, nil
+}
+
+// Reads the first len(header) bytes of the file. Shorter files have no header
+// and leave it zeroed.
+func readFileHeader(filename string, header []byte) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	if _, err := io.ReadFull(file, header); err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+
+	return nil
+}
+
+func writeSegmentInfo(directory, segmentId string, segmentInfo *SegmentInfo) error {
+	segmentInfo.Version = formatVersion
+
+	file, err := createFile(filepath.Join(directory, "segment."+segmentId+".info"))
+	if err != nil {
+		return err
+	}
+
+	if err := json.NewEncoder(file).Encode(segmentInfo); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	return closeSyncedFile(file)
+}
+
+func (segmentInfo *SegmentInfo) HasField(fieldName string) bool {
+	_, found := slices.BinarySearch(segmentInfo.Fields, fieldName)
+	return found
+}
+
+// HasOffsets returns whether the field has an offsets file
+func (segmentInfo *SegmentInfo) HasOffsets(fieldName string) bool {
+	_, found := slices.BinarySearch(segmentInfo.OffsetFields, fieldName)
+	return found
+}
+
+// HasPositions returns whether the field has a positions file
+func (segmentInfo *SegmentInfo) HasPositions(fieldName string) bool {
+	_, found := slices.BinarySearch(segmentInfo.NoPositionsFields, fieldName)
+	return !found
+}
+
+// HasDocValues returns whether the field has a doc values file
+func (segmentInfo *SegmentInfo) HasDocValues(fieldName string) bool {
+	_, found := slices.BinarySearch(segmentInfo.DocValuesFields, fieldName)
+	return found
+}
+
+type SegmentInfoWriter struct {
+	docCount   uint32
+	fields     map[string]struct{}
+	schema     *Schema
+	textFields map[string]struct{}
+}
+
+func newSegmentInfoWriter(schema *Schema) *SegmentInfoWriter {
+	return &SegmentInfoWriter{
+		fields:     make(map[string]struct{}),
+		schema:     schema,
+		textFields: make(map[string]struct{}),
+	}
+}
+
+// Records that the current field is analyzed, so that the name of its
+// analyzer is saved
+func (writer *SegmentInfoWriter) textField(fieldName string) {
+	writer.textFields[fieldName] = struct{}{}
+}
+
+func (writer *SegmentInfoWriter) Doc(docId DocumentId) {
+	writer.docCount = uint32(docId) + 1
+}
+
+func (writer *SegmentInfoWriter) Field(fieldName string, value []byte) {
+	writer.fields[fieldName] = struct{}{}
+}
+
+func (writer *SegmentInfoWriter) EndField() {
+}
+
+func (writer *SegmentInfoWriter) Term(token *Token) {
+}
+
+func (writer *SegmentInfoWriter) Write(directory, segmentId string) error {
+	fields := make([]string, 0, len(writer.fields))
+	for fieldName := range writer.fields {
+		fields = append(fields, fieldName)
+	}
+
+	slices.Sort(fields)
+
+	var offsetFields, docValuesFields []string
+	for _, fieldName := range fields {
+		if writer.schema.FieldOffsets(fieldName) {
+			offsetFields = append(offsetFields, fieldName)
+		}
+
+		if writer.schema.FieldDocValues(fieldName) != NoDocValues {
+			docValuesFields = append(docValuesFields, fieldName)
+		}
+	}
+
+	var analyzers map[string]string
+	if len(writer.textFields) > 0 {
+		analyzers = make(map[string]string, len(writer.textFields))
+		for fieldName := range writer.textFields {
+			analyzers[fieldName] = writer.schema.AnalyzerName(fieldName)
+		}
+	}
+
+	return writeSegmentInfo(directory, segmentId, &SegmentInfo{
+		DocCount:        writer.docCount,
+		Fields:          fields,
+		OffsetFields:    offsetFields,
+		DocValuesFields: docValuesFields,
+		Analyzers:       analyzers,
+	})
+}
@@ -0,0 +1,419 @@
+package index
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/larose/lynx/search/utils"
+)
+
+// mergeSegments writes the live docs of segmentReaders into a new segment.
+// Docs keep their relative order: the docs of the first segment come first,
+// then the docs of the second one, etc. Deleted docs are dropped.
+//
+// Returns nil if no doc is live, in which case nothing is written.
+func mergeSegments(directory string, segmentReaders []*SegmentReader, segmentId uint32) (*SegmentInfo, error) {
+	// docMaps[i][localDocId] is the doc id in the new segment, or -1 if the
+	// doc is deleted
+	docMaps := make([][]int64, len(segmentReaders))
+	docCount := uint32(0)
+
+	fieldSet := make(map[string]struct{})
+
+	for i, segmentReader := range segmentReaders {
+		docMap := make([]int64, segmentReader.Info.DocCount)
+
+		for localDocId := range docMap {
+			if segmentReader.DeletedDocIds.Contains(uint32(localDocId)) {
+				docMap[localDocId] = -1
+				continue
+			}
+
+			docMap[localDocId] = int64(docCount)
+			docCount++
+		}
+
+		docMaps[i] = docMap
+
+		for _, fieldName := range segmentReader.Info.Fields {
+			fieldSet[fieldName] = struct{}{}
+		}
+	}
+
+	if docCount == 0 {
+		return nil, nil
+	}
+
+	segment := strconv.FormatUint(uint64(segmentId), 10)
+
+	fields := make([]string, 0, len(fieldSet))
+	var offsetFields, docValuesFields, noPositionsFields []string
+
+	for fieldName := range fieldSet {
+		// Only keep the fields of live docs
+		stored, err := mergeFieldStore(directory, segment, fieldName, segmentReaders, docMaps)
+		if err != nil {
+			return nil, err
+		}
+
+		if !stored {
+			continue
+		}
+
+		positions := hasPositions(segmentReaders, fieldName)
+		offsets := hasOffsets(segmentReaders, fieldName)
+
+		if err := mergeFieldPostings(directory, segment, fieldName, segmentReaders, docMaps, docCount, positions, offsets); err != nil {
+			return nil, err
+		}
+
+		fields = append(fields, fieldName)
+
+		if !positions {
+			noPositionsFields = append(noPositionsFields, fieldName)
+		}
+
+		if offsets {
+			offsetFields = append(offsetFields, fieldName)
+		}
+
+		hasDocValues, err := mergeFieldDocValues(directory, segment, fieldName, segmentReaders, docMaps, docCount)
+		if err != nil {
+			return nil, err
+		}
+
+		if hasDocValues {
+			docValuesFields = append(docValuesFields, fieldName)
+		}
+	}
+
+	analyzers, err := mergeAnalyzers(segmentReaders, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	slices.Sort(fields)
+	slices.Sort(offsetFields)
+	slices.Sort(docValuesFields)
+	slices.Sort(noPositionsFields)
+
+	segmentInfo := &SegmentInfo{
+		DocCount:          docCount,
+		Fields:            fields,
+		OffsetFields:      offsetFields,
+		DocValuesFields:   docValuesFields,
+		NoPositionsFields: noPositionsFields,
+		Analyzers:         analyzers,
+	}
+
+	if err := writeSegmentInfo(directory, segment, segmentInfo); err != nil {
+		return nil, err
+	}
+
+	return segmentInfo, nil
+}
+
+func hasField(segmentReader *SegmentReader, fieldName string) bool {
+	_, found := slices.BinarySearch(segmentReader.Info.Fields, fieldName)
+	return found
+}
+
+// The merged field only has positions if the field has positions in all the
+// segments with the field
+func hasPositions(segmentReaders []*SegmentReader, fieldName string) bool {
+	for _, segmentReader := range segmentReaders {
+		if hasField(segmentReader, fieldName) && !segmentReader.Info.HasPositions(fieldName) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// The merged field only has offsets if the field has offsets in all the
+// segments with the field
+func hasOffsets(segmentReaders []*SegmentReader, fieldName string) bool {
+	for _, segmentReader := range segmentReaders {
+		if hasField(segmentReader, fieldName) && !segmentReader.Info.HasOffsets(fieldName) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Returns the analyzers of the merged fields. The segments must agree on the
+// analyzer of each field.
+func mergeAnalyzers(segmentReaders []*SegmentReader, fields []string) (map[string]string, error) {
+	var analyzers map[string]string
+
+	for _, segmentReader := range segmentReaders {
+		for fieldName, analyzer := range segmentReader.Info.Analyzers {
+			if !slices.Contains(fields, fieldName) {
+				continue
+			}
+
+			if analyzers == nil {
+				analyzers = make(map[string]string)
+			}
+
+			previous, exists := analyzers[fieldName]
+			if exists && previous != analyzer {
+				return nil, fmt.Errorf("field %s: analyzers %s and %s", fieldName, previous, analyzer)
+			}
+
+			analyzers[fieldName] = analyzer
+		}
+	}
+
+	return analyzers, nil
+}
+
+// Returns false if no live doc has a value for the field, in which case
+// nothing is written.
+func mergeFieldStore(directory, segment, fieldName string, segmentReaders []*SegmentReader, docMaps [][]int64) (bool, error) {
+	var kvStoreWriter *KVStoreWriter
+
+	for i, segmentReader := range segmentReaders {
+		if !hasField(segmentReader, fieldName) {
+			continue
+		}
+
+		fieldStoreReader, err := segmentReader.storeReader.GetFieldStoreReader(fieldName)
+		if err != nil {
+			return false, err
+		}
+
+		kvStoreReader := fieldStoreReader.kvStoreReader
+
+		for j := 0; j < kvStoreReader.Len(); j++ {
+			key, value := kvStoreReader.At(j)
+
+			newDocId := docMaps[i][utils.BytesToUint32(key)]
+			if newDocId == -1 {
+				continue
+			}
+
+			if kvStoreWriter == nil {
+				kvStoreWriter, err = newKVStoreWriter(fieldStorePath(directory, segment, fieldName), storeCodec)
+				if err != nil {
+					return false, err
+				}
+			}
+
+			if err := kvStoreWriter.Append(utils.Uint32ToBytes(uint32(newDocId)), value); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	if kvStoreWriter == nil {
+		return false, nil
+	}
+
+	return true, kvStoreWriter.Close()
+}
+
+// Writes the doc values of the live docs when the field has doc values in at
+// least one segment. The docs of the other segments have no values. Returns
+// false if no segment has doc values for the field.
+func mergeFieldDocValues(directory, segment, fieldName string, segmentReaders []*SegmentReader, docMaps [][]int64, docCount uint32) (bool, error) {
+	docValuesType := NoDocValues
+	var values [][][]byte
+
+	for i, segmentReader := range segmentReaders {
+		if !segmentReader.Info.HasDocValues(fieldName) {
+			continue
+		}
+
+		docValuesReader, err := segmentReader.DocValuesReader(fieldName)
+		if err != nil {
+			return false, err
+		}
+
+		if docValuesType == NoDocValues {
+			docValuesType = docValuesReader.Type
+			values = make([][][]byte, docCount)
+		} else if docValuesReader.Type != docValuesType {
+			return false, fmt.Errorf("field %s: doc values of types %d and %d", fieldName, docValuesType, docValuesReader.Type)
+		}
+
+		for localDocId, newDocId := range docMaps[i] {
+			if newDocId != -1 {
+				values[newDocId] = docValuesReader.Values(DocumentId(localDocId))
+			}
+		}
+	}
+
+	if docValuesType == NoDocValues {
+		return false, nil
+	}
+
+	return true, writeDocValues(directory, segment, fieldName, docValuesType, values)
+}
+
+// termCursor iterates over the terms of the dictionary of a segment
+type termCursor struct {
+	segmentIndex int
+	kvReader     *KVStoreReader
+	index        int
+	term         []byte
+	value        []byte
+}
+
+func (cursor *termCursor) next() bool {
+	cursor.index++
+	if cursor.index >= cursor.kvReader.Len() {
+		return false
+	}
+
+	cursor.term, cursor.value = cursor.kvReader.At(cursor.index)
+	return true
+}
+
+func mergeFieldPostings(directory, segment, fieldName string, segmentReaders []*SegmentReader, docMaps [][]int64, docCount uint32, positions, offsets bool) error {
+	fieldLengthIds := make([]byte, docCount)
+
+	cursors := make([]*termCursor, 0, len(segmentReaders))
+	fieldFreqsReaders := make([]*FieldFreqsReader, len(segmentReaders))
+	fieldPositionsReaders := make([]*FieldPositionsReader, len(segmentReaders))
+	fieldOffsetsReaders := make([]*FieldOffsetsReader, len(segmentReaders))
+
+	for i, segmentReader := range segmentReaders {
+		if !hasField(segmentReader, fieldName) {
+			continue
+		}
+
+		fieldLengthReader, err := segmentReader.DocLengthReader.FieldLengthReader(fieldName)
+		if err != nil {
+			return err
+		}
+
+		for localDocId, newDocId := range docMaps[i] {
+			if newDocId == -1 {
+				continue
+			}
+
+			fieldLengthIds[newDocId], err = fieldLengthReader.GetId(DocumentId(localDocId))
+			if err != nil {
+				return err
+			}
+		}
+
+		dictionaryReader, err := segmentReader.DictionaryReader(fieldName)
+		if err != nil {
+			return err
+		}
+
+		fieldFreqsReaders[i], err = segmentReader.FieldFreqsReader(fieldName)
+		if err != nil {
+			return err
+		}
+
+		if positions {
+			fieldPositionsReaders[i], err = segmentReader.FieldPositionsReader(fieldName)
+			if err != nil {
+				return err
+			}
+		}
+
+		if offsets {
+			fieldOffsetsReaders[i], err = segmentReader.FieldOffsetsReader(fieldName)
+			if err != nil {
+				return err
+			}
+		}
+
+		cursor := &termCursor{segmentIndex: i, kvReader: dictionaryReader.kvReader, index: -1}
+		if cursor.next() {
+			cursors = append(cursors, cursor)
+		}
+	}
+
+	fieldPostingsWriter, err := newFieldPostingsWriter(directory, segment, fieldName, fieldLengthIds, positions, offsets)
+	if err != nil {
+		return err
+	}
+
+	termDocIds := make([]uint32, 0, 100)
+	termFreqs := make([]uint64, 0, 100)
+	termPositions := make([]uint64, 0, 100)
+	termOffsets := make([]Offsets, 0, 100)
+	termCursors := make([]*termCursor, 0, len(cursors))
+
+	for len(cursors) > 0 {
+		// Smallest term, and all the cursors positioned on it, in segment order
+		term := cursors[0].term
+		for _, cursor := range cursors[1:] {
+			if bytes.Compare(cursor.term, term) < 0 {
+				term = cursor.term
+			}
+		}
+
+		termCursors = termCursors[:0]
+		for _, cursor := range cursors {
+			if bytes.Equal(cursor.term, term) {
+				termCursors = append(termCursors, cursor)
+			}
+		}
+
+		termDocIds = termDocIds[:0]
+		termFreqs = termFreqs[:0]
+		termPositions = termPositions[:0]
+		termOffsets = termOffsets[:0]
+
+		for _, cursor := range termCursors {
+			i := cursor.segmentIndex
+			termInfo := decodeTermInfo(cursor.value)
+
+			freqsIterator := fieldFreqsReaders[i].TermFreqsIterator(termInfo)
+
+			var positionsIterator *TermPositionsIterator
+			if positions {
+				positionsIterator = fieldPositionsReaders[i].TermPositionsIterator(freqsIterator, termInfo)
+			}
+
+			var offsetsIterator *TermOffsetsIterator
+			if offsets {
+				offsetsIterator = fieldOffsetsReaders[i].TermOffsetsIterator(freqsIterator, termInfo)
+			}
+
+			for docId := DocumentId(0); freqsIterator.Next(docId); docId = freqsIterator.DocId() + 1 {
+				newDocId := docMaps[i][freqsIterator.DocId()]
+				if newDocId == -1 {
+					continue
+				}
+
+				termDocIds = append(termDocIds, uint32(newDocId))
+				termFreqs = append(termFreqs, freqsIterator.TermFreq())
+
+				if positions {
+					termPositions = append(termPositions, positionsIterator.Positions()...)
+				}
+
+				if offsets {
+					termOffsets = append(termOffsets, offsetsIterator.Offsets()...)
+				}
+			}
+		}
+
+		// The term only occurs in deleted docs
+		if len(termDocIds) > 0 {
+			if err := fieldPostingsWriter.WriteTerm(bytes.Clone(term), termDocIds, termFreqs, termPositions, termOffsets); err != nil {
+				return err
+			}
+		}
+
+		cursors = slices.DeleteFunc(cursors, func(cursor *termCursor) bool {
+			if !bytes.Equal(cursor.term, term) {
+				return false
+			}
+
+			return !cursor.next()
+		})
+	}
+
+	return fieldPostingsWriter.Close()
+}
@@ -1,41 +1,189 @@
 package index
 
 import (
+	"errors"
 	"strconv"
+	"sync"
+	"sync/atomic"
 
 	"github.com/RoaringBitmap/roaring/v2"
 )
 
+// SegmentReader gives access to a segment, with the deleted docs of a
+// commit. Readers of the same segment in different commits share the files
+// of the segment.
 type SegmentReader struct {
-	DeletedDocIds     *roaring.Bitmap
-	dictionaryReaders map[string]*DictionaryReader
-	DocLengthReader   *DocFieldLengthReader
-	directory         string
-	Id                uint32
-	IdString          string
-	fieldFreqsReaders map[string]*FieldFreqsReader
-	storeReader       *StoreReader
+	*segmentCore
+	DeletedDocIds *roaring.Bitmap
 }
 
-func newSegmentReader(directory string, segmentId uint32, deletedDocIds *roaring.Bitmap) *SegmentReader {
+// segmentCore holds the files of a segment, which don't change between
+// commits. All the files are opened with the segment, so that they can still
+// be read once a writer, maybe of another process, removed them. They are
+// closed when no segment reader uses them anymore.
+type segmentCore struct {
+	dictionaryReaders     map[string]*DictionaryReader
+	docValuesReaders      map[string]*DocValuesReader
+	DocLengthReader       *DocFieldLengthReader
+	directory             string
+	Id                    uint32
+	IdString              string
+	Info                  *SegmentInfo
+	fieldFreqsReaders     map[string]*FieldFreqsReader
+	fieldOffsetsReaders   map[string]*FieldOffsetsReader
+	fieldPositionsReaders map[string]*FieldPositionsReader
+	fieldStats            map[string]fieldStats
+	// Guards the readers
+	mutex sync.Mutex
+	// Number of segment readers using the core
+	refCount    atomic.Int32
+	storeReader *StoreReader
+}
+
+func newSegmentReader(directory string, segmentId uint32, deletedDocIds *roaring.Bitmap) (*SegmentReader, error) {
 	segment := strconv.FormatUint(uint64(segmentId), 10)
-	return &SegmentReader{
-		DeletedDocIds:     deletedDocIds,
-		dictionaryReaders: make(map[string]*DictionaryReader),
-		directory:         directory,
-		DocLengthReader:   newDocFieldLengthReader(directory, segment),
-		Id:                segmentId,
-		IdString:          segment,
-		fieldFreqsReaders: make(map[string]*FieldFreqsReader),
-		storeReader:       newStoreReader(directory, segment),
+
+	segmentInfo, err := readSegmentInfo(directory, segment)
+	if err != nil {
+		return nil, err
+	}
+
+	core := &segmentCore{
+		dictionaryReaders:     make(map[string]*DictionaryReader),
+		docValuesReaders:      make(map[string]*DocValuesReader),
+		directory:             directory,
+		DocLengthReader:       newDocFieldLengthReader(directory, segment, segmentInfo.Version),
+		Id:                    segmentId,
+		IdString:              segment,
+		Info:                  segmentInfo,
+		fieldFreqsReaders:     make(map[string]*FieldFreqsReader),
+		fieldOffsetsReaders:   make(map[string]*FieldOffsetsReader),
+		fieldPositionsReaders: make(map[string]*FieldPositionsReader),
+		fieldStats:            make(map[string]fieldStats),
+		storeReader:           newStoreReader(directory, segment, segmentInfo.Version),
+	}
+
+	core.refCount.Store(1)
+
+	if err := core.openFiles(); err != nil {
+		return nil, errors.Join(err, core.decRef())
+	}
+
+	return &SegmentReader{segmentCore: core, DeletedDocIds: deletedDocIds}, nil
+}
+
+type fieldStats struct {
+	docCount    uint32
+	sumTermFreq uint64
+}
+
+// openFiles opens the files of all the fields of the segment
+func (reader *segmentCore) openFiles() error {
+	for _, fieldName := range reader.Info.Fields {
+		if _, err := reader.DictionaryReader(fieldName); err != nil {
+			return err
+		}
+
+		if _, err := reader.FieldFreqsReader(fieldName); err != nil {
+			return err
+		}
+
+		if reader.Info.HasPositions(fieldName) {
+			if _, err := reader.FieldPositionsReader(fieldName); err != nil {
+				return err
+			}
+		}
+
+		if reader.Info.HasOffsets(fieldName) {
+			if _, err := reader.FieldOffsetsReader(fieldName); err != nil {
+				return err
+			}
+		}
+
+		if reader.Info.HasDocValues(fieldName) {
+			if _, err := reader.DocValuesReader(fieldName); err != nil {
+				return err
+			}
+		}
+
+		if _, err := reader.DocLengthReader.FieldLengthReader(fieldName); err != nil {
+			return err
+		}
+
+		if _, err := reader.storeReader.GetFieldStoreReader(fieldName); err != nil {
+			return err
+		}
+
+		if _, _, err := reader.DocCountAndSumTermFreqForField(fieldName); err != nil {
+			return err
+		}
 	}
+
+	return nil
 }
 
-func (reader *SegmentReader) DictionaryReader(fieldName string) (*DictionaryReader, error) {
+// withDeletedDocIds returns a reader of the same segment, sharing its files,
+// with other deleted docs
+func (reader *SegmentReader) withDeletedDocIds(deletedDocIds *roaring.Bitmap) *SegmentReader {
+	reader.incRef()
+	return &SegmentReader{segmentCore: reader.segmentCore, DeletedDocIds: deletedDocIds}
+}
+
+func (reader *segmentCore) incRef() {
+	reader.refCount.Add(1)
+}
+
+// decRef closes all the files of the segment when it's no longer used
+func (reader *segmentCore) decRef() error {
+	refCount := reader.refCount.Add(-1)
+
+	if refCount > 0 {
+		return nil
+	}
+
+	if refCount < 0 {
+		return errors.New("segment already closed")
+	}
+
+	reader.mutex.Lock()
+	defer reader.mutex.Unlock()
+
+	var err error
+
+	for _, dictionaryReader := range reader.dictionaryReaders {
+		err = errors.Join(err, dictionaryReader.Close())
+	}
+
+	for _, docValuesReader := range reader.docValuesReaders {
+		err = errors.Join(err, docValuesReader.Close())
+	}
+
+	for _, fieldFreqsReader := range reader.fieldFreqsReaders {
+		err = errors.Join(err, fieldFreqsReader.Close())
+	}
+
+	for _, fieldOffsetsReader := range reader.fieldOffsetsReaders {
+		err = errors.Join(err, fieldOffsetsReader.Close())
+	}
+
+	for _, fieldPositionsReader := range reader.fieldPositionsReaders {
+		err = errors.Join(err, fieldPositionsReader.Close())
+	}
+
+	err = errors.Join(err, reader.DocLengthReader.Close())
+	err = errors.Join(err, reader.storeReader.Close())
+
+	return err
+}
+
+func (reader *segmentCore) DictionaryReader(fieldName string) (*DictionaryReader, error) {
+	reader.mutex.Lock()
+	defer reader.mutex.Unlock()
+
 	dictionaryReader, exists := reader.dictionaryReaders[fieldName]
 	if !exists {
 		var err error
-		dictionaryReader, err = newDictionaryReader(reader.directory, reader.IdString, fieldName)
+		dictionaryReader, err = newDictionaryReader(reader.directory, reader.IdString, fieldName, reader.Info.Version)
 		if err != nil {
 			return nil, err
 		}
@@ -46,25 +194,58 @@ func (reader *SegmentReader) DictionaryReader(fieldName string) (*DictionaryRead
 	return dictionaryReader, nil
 }
 
-func (reader *SegmentReader) DocCountAndSumTermFreqForField(fieldName string) (uint32, uint64, error) {
-	fieldStatsReader, err := newFieldStatsReader(reader.directory, reader.IdString, fieldName)
-	if err != nil {
-		return 0, 0, err
+// DocValuesReader must only be called for the fields with doc values, see
+// SegmentInfo.HasDocValues
+func (reader *segmentCore) DocValuesReader(fieldName string) (*DocValuesReader, error) {
+	reader.mutex.Lock()
+	defer reader.mutex.Unlock()
+
+	docValuesReader, exists := reader.docValuesReaders[fieldName]
+	if !exists {
+		var err error
+		docValuesReader, err = newDocValuesReader(reader.directory, reader.IdString, fieldName, reader.Info.Version)
+		if err != nil {
+			return nil, err
+		}
+
+		reader.docValuesReaders[fieldName] = docValuesReader
 	}
 
-	docCount, sumTermFreq, err := fieldStatsReader.Read()
-	if err != nil {
-		return 0, 0, err
+	return docValuesReader, nil
+}
+
+func (reader *segmentCore) DocCountAndSumTermFreqForField(fieldName string) (uint32, uint64, error) {
+	reader.mutex.Lock()
+	defer reader.mutex.Unlock()
+
+	stats, exists := reader.fieldStats[fieldName]
+	if !exists {
+		fieldStatsReader, err := newFieldStatsReader(reader.directory, reader.IdString, fieldName, reader.Info.Version)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		defer fieldStatsReader.Close()
+
+		stats.docCount, stats.sumTermFreq, err = fieldStatsReader.Read()
+		if err != nil {
+			return 0, 0, err
+		}
+
+		reader.fieldStats[fieldName] = stats
 	}
 
-	return docCount, sumTermFreq, nil
+	return stats.docCount, stats.sumTermFreq, nil
 }
 
-func (reader *SegmentReader) FieldFreqsReader(fieldName string) (*FieldFreqsReader, error) {
+func (reader *segmentCore) FieldFreqsReader(fieldName string) (*FieldFreqsReader, error) {
+	reader.mutex.Lock()
+	defer reader.mutex.Unlock()
+
 	fieldFreqsReader, exists := reader.fieldFreqsReaders[fieldName]
 	if !exists {
 		var err error
-		fieldFreqsReader, err = newFieldFreqsReader(reader.directory, reader.IdString, fieldName)
+		fieldFreqsReader, err = newFieldFreqsReader(reader.directory, reader.IdString, fieldName, reader.Info.Version)
 		if err != nil {
 			return nil, err
 		}
@@ -74,3 +255,41 @@ func (reader *SegmentReader) FieldFreqsReader(fieldName string) (*FieldFreqsRead
 
 	return fieldFreqsReader, nil
 }
+
+// FieldOffsetsReader must only be called for the fields with offsets, see
+// SegmentInfo.HasOffsets
+func (reader *segmentCore) FieldOffsetsReader(fieldName string) (*FieldOffsetsReader, error) {
+	reader.mutex.Lock()
+	defer reader.mutex.Unlock()
+
+	fieldOffsetsReader, exists := reader.fieldOffsetsReaders[fieldName]
+	if !exists {
+		var err error
+		fieldOffsetsReader, err = newFieldOffsetsReader(reader.directory, reader.IdString, fieldName, reader.Info.Version)
+		if err != nil {
+			return nil, err
+		}
+
+		reader.fieldOffsetsReaders[fieldName] = fieldOffsetsReader
+	}
+
+	return fieldOffsetsReader, nil
+}
+
+func (reader *segmentCore) FieldPositionsReader(fieldName string) (*FieldPositionsReader, error) {
+	reader.mutex.Lock()
+	defer reader.mutex.Unlock()
+
+	fieldPositionsReader, exists := reader.fieldPositionsReaders[fieldName]
+	if !exists {
+		var err error
+		fieldPositionsReader, err = newFieldPositionsReader(reader.directory, reader.IdString, fieldName, reader.Info.Version)
+		if err != nil {
+			return nil, err
+		}
+
+		reader.fieldPositionsReaders[fieldName] = fieldPositionsReader
+	}
+
+	return fieldPositionsReader, nil
+}
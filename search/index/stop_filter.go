@@ -0,0 +1,31 @@
+package index
+
+// EnglishStopWords are common English words that are usually not worth
+// indexing
+var EnglishStopWords = []string{
+	"a", "an", "and", "are", "as", "at", "be", "but", "by", "for", "if", "in",
+	"into", "is", "it", "no", "not", "of", "on", "or", "such", "that", "the",
+	"their", "then", "there", "these", "they", "this", "to", "was", "will",
+	"with",
+}
+
+// StopFilter drops the tokens that are in its list of stop words. The stop
+// words must be analyzed like the tokens, e.g. lowercased when the filter
+// comes after a LowerCaseFilter.
+type StopFilter struct {
+	stopWords map[string]struct{}
+}
+
+func NewStopFilter(stopWords []string) *StopFilter {
+	stopWordSet := make(map[string]struct{}, len(stopWords))
+	for _, stopWord := range stopWords {
+		stopWordSet[stopWord] = struct{}{}
+	}
+
+	return &StopFilter{stopWords: stopWordSet}
+}
+
+func (f *StopFilter) Filter(token *Token) bool {
+	_, isStopWord := f.stopWords[string(token.Text)]
+	return !isStopWord
+}
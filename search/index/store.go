@@ -1,12 +1,18 @@
 package index
 
 import (
+	"errors"
 	"path/filepath"
 	"slices"
+	"sync"
 
 	"github.com/larose/lynx/search/utils"
 )
 
+func fieldStorePath(directory, segmentId, fieldName string) string {
+	return filepath.Join(directory, "segment."+segmentId+"."+fieldName+".store")
+}
+
 type StoreWriter struct {
 	currentDocId DocumentId
 	values       map[string]map[DocumentId][]byte
@@ -36,12 +42,12 @@ func (writer *StoreWriter) Field(fieldName string, value []byte) {
 func (writer *StoreWriter) EndField() {
 }
 
-func (writer *StoreWriter) Term(term []byte) {
+func (writer *StoreWriter) Term(token *Token) {
 }
 
 func (writer *StoreWriter) Write(directory, segmentId string) error {
 	for fieldName, values := range writer.values {
-		kvStoreWriter, err := newKVStoreWriter(filepath.Join(directory, "segment."+segmentId+"."+fieldName+".store"))
+		kvStoreWriter, err := newKVStoreWriter(fieldStorePath(directory, segmentId, fieldName), storeCodec)
 		if err != nil {
 			return err
 		}
@@ -70,8 +76,8 @@ type FieldStoreReader struct {
 	kvStoreReader *KVStoreReader
 }
 
-func newFieldStoreReader(directory string, segmentId string, fieldName string) (*FieldStoreReader, error) {
-	kvStoreReader, err := newKVStoreReader(filepath.Join(directory, "segment."+segmentId+"."+fieldName+".store"))
+func newFieldStoreReader(directory string, segmentId string, fieldName string, version uint32) (*FieldStoreReader, error) {
+	kvStoreReader, err := newKVStoreReader(fieldStorePath(directory, segmentId, fieldName), storeCodec, version)
 	if err != nil {
 		return nil, err
 	}
@@ -79,6 +85,10 @@ func newFieldStoreReader(directory string, segmentId string, fieldName string) (
 	return &FieldStoreReader{kvStoreReader: kvStoreReader}, nil
 }
 
+func (reader *FieldStoreReader) Close() error {
+	return reader.kvStoreReader.Close()
+}
+
 func (reader *FieldStoreReader) Value(docId DocumentId) []byte {
 	value := reader.kvStoreReader.Get(utils.Uint32ToBytes(uint32(docId)))
 	return value
@@ -87,22 +97,41 @@ func (reader *FieldStoreReader) Value(docId DocumentId) []byte {
 type StoreReader struct {
 	directory         string
 	fieldStoreReaders map[string]*FieldStoreReader
+	mutex             sync.Mutex
 	segmentId         string
+	version           uint32
 }
 
-func newStoreReader(directory, segmentId string) *StoreReader {
+func newStoreReader(directory, segmentId string, version uint32) *StoreReader {
 	return &StoreReader{
 		directory:         directory,
 		segmentId:         segmentId,
+		version:           version,
 		fieldStoreReaders: make(map[string]*FieldStoreReader, 10),
 	}
 }
 
+func (reader *StoreReader) Close() error {
+	reader.mutex.Lock()
+	defer reader.mutex.Unlock()
+
+	var err error
+
+	for _, fieldStoreReader := range reader.fieldStoreReaders {
+		err = errors.Join(err, fieldStoreReader.Close())
+	}
+
+	return err
+}
+
 func (reader *StoreReader) GetFieldStoreReader(fieldName string) (*FieldStoreReader, error) {
+	reader.mutex.Lock()
+	defer reader.mutex.Unlock()
+
 	fieldStoreReaders, exists := reader.fieldStoreReaders[fieldName]
 	if !exists {
 		var err error
-		fieldStoreReaders, err = newFieldStoreReader(reader.directory, reader.segmentId, fieldName)
+		fieldStoreReaders, err = newFieldStoreReader(reader.directory, reader.segmentId, fieldName, reader.version)
 		if err != nil {
 			return nil, err
 		}
@@ -7,11 +7,11 @@ import (
 )
 
 type ArrayStoreWriter struct {
-	file *os.File
+	file *codecFileWriter
 }
 
-func newArrayStoreWriter(filename string) (*ArrayStoreWriter, error) {
-	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+func newArrayStoreWriter(filename, codec string) (*ArrayStoreWriter, error) {
+	file, err := createCodecFile(filename, codec)
 	if err != nil {
 		return nil, err
 	}
@@ -26,31 +26,48 @@ func (writer *ArrayStoreWriter) Append(value []byte) error {
 	return err
 }
 
+func (writer *ArrayStoreWriter) Close() error {
+	return writer.file.Close()
+}
+
 type ArrayStoreReader struct {
-	data             mmap.MMap
+	// After the header
+	data             []byte
+	dataMap          mmap.MMap
 	elementValueSize uint32
 	file             *os.File
 }
 
-func newArrayStoreReader(filename string, elementValueSize uint32) (*ArrayStoreReader, error) {
+func newArrayStoreReader(filename, codec string, elementValueSize, version uint32) (*ArrayStoreReader, error) {
 	file, err := os.Open(filename)
 	if err != nil {
 		return nil, err
 	}
 
-	data, err := mmap.Map(file, mmap.RDONLY, 0)
+	dataMap, err := mapFile(file)
 	if err != nil {
 		_ = file.Close()
 		return nil, err
 	}
 
+	data, err := readCodecFile(filename, dataMap, codec, version)
+	if err != nil {
+		_ = closeMappedFile(dataMap, file)
+		return nil, err
+	}
+
 	return &ArrayStoreReader{
 		data:             data,
+		dataMap:          dataMap,
 		elementValueSize: elementValueSize,
 		file:             file,
 	}, nil
 }
 
+func (reader *ArrayStoreReader) Close() error {
+	return closeMappedFile(reader.dataMap, reader.file)
+}
+
 func (reader *ArrayStoreReader) Get(position uint32) []byte {
 	return reader.data[position*reader.elementValueSize : (position*reader.elementValueSize)+reader.elementValueSize]
 }
@@ -1,40 +1,40 @@
 package index
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/binary"
 	"log"
 	"os"
+	"sort"
 
 	"github.com/edsrzf/mmap-go"
 )
 
 type KVStoreWriter struct {
-	dataFile    *os.File
-	dataWriter  *bufio.Writer
-	indexFile   *os.File
-	indexWriter *bufio.Writer
-	offset      uint64
+	dataFile  *codecFileWriter
+	indexFile *codecFileWriter
+	offset    uint64
 }
 
-func newKVStoreWriter(basename string) (*KVStoreWriter, error) {
-	dataFile, err := os.OpenFile(basename+".data", os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+// The .data and .index files have the headers of codec, suffixed with Data
+// and Index
+func newKVStoreWriter(basename, codec string) (*KVStoreWriter, error) {
+	dataFile, err := createCodecFile(basename+".data", codec+"Data")
 	if err != nil {
 		return nil, err
 	}
-	indexFile, err := os.OpenFile(basename+".index", os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+
+	indexFile, err := createCodecFile(basename+".index", codec+"Index")
 	if err != nil {
-		dataFile.Close()
+		_ = dataFile.file.Close()
 		return nil, err
 	}
 
+	// Offsets are relative to the end of the header
 	return &KVStoreWriter{
-		dataFile:    dataFile,
-		dataWriter:  bufio.NewWriter(dataFile),
-		indexFile:   indexFile,
-		indexWriter: bufio.NewWriter(indexFile),
-		offset:      0,
+		dataFile:  dataFile,
+		indexFile: indexFile,
+		offset:    0,
 	}, nil
 }
 
@@ -59,14 +59,14 @@ func (w *KVStoreWriter) Append(key []byte, values ...[]byte) error {
 		buffer = append(buffer, value...)
 	}
 
-	if _, err := w.dataWriter.Write(buffer); err != nil {
+	if _, err := w.dataFile.Write(buffer); err != nil {
 		return err
 	}
 
 	b := make([]byte, 8)
 	binary.BigEndian.PutUint64(b, w.offset)
 
-	if _, err := w.indexWriter.Write(b); err != nil {
+	if _, err := w.indexFile.Write(b); err != nil {
 		return err
 	}
 
@@ -76,23 +76,12 @@ func (w *KVStoreWriter) Append(key []byte, values ...[]byte) error {
 }
 
 func (w *KVStoreWriter) Close() error {
-	if err := w.dataWriter.Flush(); err != nil {
-		return err
-	}
-
 	if err := w.dataFile.Close(); err != nil {
+		_ = w.indexFile.file.Close()
 		return err
 	}
 
-	if err := w.indexWriter.Flush(); err != nil {
-		return err
-	}
-
-	if err := w.indexFile.Close(); err != nil {
-		return err
-	}
-
-	return nil
+	return w.indexFile.Close()
 }
 
 // type cursor struct {
@@ -108,20 +97,23 @@ func (w *KVStoreWriter) Close() error {
 // }
 
 type KVStoreReader struct {
-	data      mmap.MMap
+	// After the headers
+	data      []byte
 	dataFile  *os.File
-	index     mmap.MMap
+	dataMap   mmap.MMap
+	index     []byte
 	indexFile *os.File
+	indexMap  mmap.MMap
 	// cursor    cursor
 }
 
-func newKVStoreReader(basename string) (*KVStoreReader, error) {
+func newKVStoreReader(basename, codec string, version uint32) (*KVStoreReader, error) {
 	dataFile, err := os.Open(basename + ".data")
 	if err != nil {
 		return nil, err
 	}
 
-	data, err := mmap.Map(dataFile, mmap.RDONLY, 0)
+	dataMap, err := mapFile(dataFile)
 	if err != nil {
 		_ = dataFile.Close()
 		return nil, err
@@ -129,23 +121,35 @@ func newKVStoreReader(basename string) (*KVStoreReader, error) {
 
 	indexFile, err := os.Open(basename + ".index")
 	if err != nil {
-		_ = dataFile.Close()
+		_ = closeMappedFile(dataMap, dataFile)
 		return nil, err
 	}
 
-	index, err := mmap.Map(indexFile, mmap.RDONLY, 0)
+	indexMap, err := mapFile(indexFile)
 	if err != nil {
-		_ = dataFile.Close()
+		_ = closeMappedFile(dataMap, dataFile)
 		_ = indexFile.Close()
 		return nil, err
 	}
 
-	return &KVStoreReader{
-		data:      data,
+	reader := &KVStoreReader{
 		dataFile:  dataFile,
-		index:     index,
+		dataMap:   dataMap,
 		indexFile: indexFile,
-	}, nil
+		indexMap:  indexMap,
+	}
+
+	reader.data, err = readCodecFile(dataFile.Name(), dataMap, codec+"Data", version)
+	if err == nil {
+		reader.index, err = readCodecFile(indexFile.Name(), indexMap, codec+"Index", version)
+	}
+
+	if err != nil {
+		_ = reader.Close()
+		return nil, err
+	}
+
+	return reader, nil
 }
 
 // func (kv *KVStoreReader) Start() {
@@ -200,15 +204,35 @@ func (kv *KVStoreReader) Get(key []byte) []byte {
 	return nil
 }
 
-func (kv *KVStoreReader) Close() error {
-	if err := kv.dataFile.Close(); err != nil {
-		_ = kv.indexFile.Close()
-		return err
-	}
+// Search returns the position of the first item whose key is not less than
+// key, or Len if there is none
+func (kv *KVStoreReader) Search(key []byte) int {
+	return sort.Search(kv.Len(), func(i int) bool {
+		currentKey, _ := kv.At(i)
+		return bytes.Compare(currentKey, key) >= 0
+	})
+}
+
+// Len returns the number of items
+func (kv *KVStoreReader) Len() int {
+	return len(kv.index) / 8
+}
+
+// At returns the key and the value of the item at position i, in key order
+func (kv *KVStoreReader) At(i int) ([]byte, []byte) {
+	offset := binary.BigEndian.Uint64(kv.index[i*8 : (i*8)+8])
+	keyLength := binary.BigEndian.Uint32(kv.data[offset : offset+4])
+	valueLength := binary.BigEndian.Uint32(kv.data[offset+4 : offset+8])
+	keyEnd := offset + 8 + uint64(keyLength)
 
-	if err := kv.indexFile.Close(); err != nil {
+	return kv.data[offset+8 : keyEnd], kv.data[keyEnd : keyEnd+uint64(valueLength)]
+}
+
+func (kv *KVStoreReader) Close() error {
+	if err := closeMappedFile(kv.dataMap, kv.dataFile); err != nil {
+		_ = closeMappedFile(kv.indexMap, kv.indexFile)
 		return err
 	}
 
-	return nil
+	return closeMappedFile(kv.indexMap, kv.indexFile)
 }
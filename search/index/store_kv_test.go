@@ -19,7 +19,7 @@ func TestKVStore(t *testing.T) {
 	os.Remove(basename + ".data")
 	os.Remove(basename + ".index")
 
-	writer, err := newKVStoreWriter(basename)
+	writer, err := newKVStoreWriter(basename, storeCodec)
 	if err != nil {
 		t.Fatalf("failed to create KVStoreWriter: %v", err)
 	}
@@ -43,7 +43,7 @@ func TestKVStore(t *testing.T) {
 		log.Fatal(err)
 	}
 
-	reader, err := newKVStoreReader(basename)
+	reader, err := newKVStoreReader(basename, storeCodec, formatVersion)
 	if err != nil {
 		t.Fatalf("failed to create KVStoreReader: %v", err)
 	}
@@ -61,4 +61,9 @@ func TestKVStore(t *testing.T) {
 	// Test non-existing key
 	value := reader.Get([]byte("9661c61e"))
 	assert.Nil(t, value)
+
+	assert.Equal(t, 0, reader.Search([]byte("a")))
+	assert.Equal(t, 1, reader.Search([]byte("apples")))
+	assert.Equal(t, 3, reader.Search([]byte("foo")))
+	assert.Equal(t, 5, reader.Search([]byte("world")))
 }
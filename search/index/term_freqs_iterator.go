@@ -19,6 +19,8 @@ type TermFreqsIterator struct {
 	minLengthId        byte
 	length             uint32
 	nextBlockOffset    int64
+	// index of the current block, starting at 0
+	blockIndex int
 
 	// Block data
 	blockDataDecoded bool
@@ -32,6 +34,7 @@ func newTermFreqsIterator(fileReader FileReader, termInfo *TermInfo) *TermFreqsI
 	reader := bytes.NewReader(data)
 
 	return &TermFreqsIterator{
+		blockIndex:     -1,
 		indexInBlockId: -1,
 		blockDocIds:    make([]DocumentId, 0, 128),
 		blockFreqs:     make([]uint64, 0, 128),
@@ -104,6 +107,7 @@ func (it *TermFreqsIterator) NextShallow(docId DocumentId) bool {
 		binary.Read(it.reader, binary.BigEndian, &it.length)
 		it.nextBlockOffset = start + int64(it.length)
 		it.blockDataDecoded = false
+		it.blockIndex++
 	}
 
 	for {
@@ -107,7 +107,11 @@ func _index() {
 		log.Fatal(err)
 	}
 
-	indexWriter := index.NewIndexWriter(directory)
+	indexWriter, err := index.NewIndexWriter(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer indexWriter.Close()
 
 	iterator, err := newArticleIterator("wiki-articles.jsonl")
 	if err != nil {
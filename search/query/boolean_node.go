@@ -1,12 +1,17 @@
 package query
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/larose/lynx/search/index"
+)
 
 type MatchType byte
 
 const (
 	Should MatchType = iota
 	Must
+	MustNot
 )
 
 type BooleanClause struct {
@@ -14,49 +19,423 @@ type BooleanClause struct {
 	Node Node
 }
 
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// Node
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+// BooleanNode matches the documents that match all the Must clauses and none
+// of the MustNot clauses. Should clauses add to the score, and at least one of
+// them must match when there are no Must clauses.
 type BooleanNode struct {
 	Clauses []*BooleanClause
 }
 
+func (n *BooleanNode) createChildNodes(context *QueryContext) ([]ChildNode, []ChildNode, []ChildNode, error) {
+	mustNodes := make([]ChildNode, 0, len(n.Clauses))
+	shouldNodes := make([]ChildNode, 0, len(n.Clauses))
+	mustNotNodes := make([]ChildNode, 0, len(n.Clauses))
+
+	for _, clause := range n.Clauses {
+		childNode, err := clause.Node.CreateChildNode(context)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		switch clause.Type {
+		case Should:
+			shouldNodes = append(shouldNodes, childNode)
+		case Must:
+			mustNodes = append(mustNodes, childNode)
+		case MustNot:
+			mustNotNodes = append(mustNotNodes, childNode)
+		default:
+			return nil, nil, nil, fmt.Errorf("unknown match type %d", clause.Type)
+		}
+	}
+
+	return mustNodes, shouldNodes, mustNotNodes, nil
+}
+
 func (n *BooleanNode) CreateRootNode(context *QueryContext) (RootNode, error) {
 
 	if len(n.Clauses) == 1 {
-		return n.Clauses[0].Node.CreateRootNode(context)
+		clause := n.Clauses[0]
+
+		switch clause.Type {
+		case Should, Must:
+			return clause.Node.CreateRootNode(context)
+		case MustNot:
+			return &EmptyRootNode{}, nil
+		}
 	}
 
-	childNodes := make([]ChildNode, 0, 10)
+	mustNodes, shouldNodes, mustNotNodes, err := n.createChildNodes(context)
+	if err != nil {
+		return nil, err
+	}
 
-	allMust := true
-	allShould := true
+	var requiredNode RootNode
 
-	for _, clause := range n.Clauses {
-		allMust = allMust && clause.Type == Must
-		allShould = allShould && clause.Type == Should
+	switch {
+	case len(mustNodes) > 0:
+		requiredNode = &ConjunctionRootNode{
+			childNodes: mustNodes,
+		}
+	case len(shouldNodes) > 0:
+		requiredNode = &DisjunctionRootNode{
+			childNodes: shouldNodes,
+		}
+		shouldNodes = nil
+	default:
+		return &EmptyRootNode{}, nil
+	}
 
-		childNode, err := clause.Node.CreateChildNode(context)
-		if err != nil {
-			return nil, err
+	if len(shouldNodes) == 0 && len(mustNotNodes) == 0 {
+		return requiredNode, nil
+	}
+
+	return &BooleanRootNode{
+		requiredNode:  requiredNode,
+		optionalNodes: shouldNodes,
+		excludedNodes: mustNotNodes,
+	}, nil
+}
+
+func (n *BooleanNode) CreateChildNode(context *QueryContext) (ChildNode, error) {
+	if len(n.Clauses) == 1 {
+		clause := n.Clauses[0]
+
+		switch clause.Type {
+		case Should, Must:
+			return clause.Node.CreateChildNode(context)
+		case MustNot:
+			return &EmptyChildNode{}, nil
 		}
+	}
 
-		childNodes = append(childNodes, childNode)
+	mustNodes, shouldNodes, mustNotNodes, err := n.createChildNodes(context)
+	if err != nil {
+		return nil, err
 	}
 
-	if allMust {
-		return &ConjunctionRootNode{
-			childNodes: childNodes,
-		}, nil
+	var requiredNode ChildNode
+
+	switch {
+	case len(mustNodes) > 0:
+		requiredNode = &ConjunctionChildNode{
+			childNodes: mustNodes,
+		}
+	case len(shouldNodes) > 0:
+		requiredNode = &DisjunctionChildNode{
+			childNodes: shouldNodes,
+		}
+		shouldNodes = nil
+	default:
+		return &EmptyChildNode{}, nil
 	}
 
-	if allShould {
-		return &DisjunctionRootNode{
-			childNodes: childNodes,
-		}, nil
+	if len(shouldNodes) == 0 && len(mustNotNodes) == 0 {
+		return requiredNode, nil
 	}
 
-	return nil, fmt.Errorf("must be either all should or all must")
+	return &BooleanChildNode{
+		requiredNode:  requiredNode,
+		optionalNodes: shouldNodes,
+		excludedNodes: mustNotNodes,
+	}, nil
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// BooleanRootNode
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
 
+type BooleanRootNode struct {
+	requiredNode  RootNode
+	optionalNodes []ChildNode
+	excludedNodes []ChildNode
 }
 
-func (n *BooleanNode) CreateChildNode(context *QueryContext) (ChildNode, error) {
-	return nil, fmt.Errorf("not supported")
+func (n *BooleanRootNode) CreateRootDocIterator(context *ExecutionContext, segmentIndex int) RootDocIterator {
+	requiredDocIterator := n.requiredNode.CreateRootDocIterator(context, segmentIndex)
+	if requiredDocIterator == nil {
+		return nil
+	}
+
+	optionalDocIterators := make([]ChildDocIterator, 0, len(n.optionalNodes))
+	for _, optionalNode := range n.optionalNodes {
+		optionalDocIterator := optionalNode.CreateChildDocIterator(context, segmentIndex)
+		if optionalDocIterator != nil {
+			optionalDocIterators = append(optionalDocIterators, optionalDocIterator)
+		}
+	}
+
+	excludedDocIterators := make([]ChildDocIterator, 0, len(n.excludedNodes))
+	for _, excludedNode := range n.excludedNodes {
+		excludedDocIterator := excludedNode.CreateChildDocIterator(context, segmentIndex)
+		if excludedDocIterator != nil {
+			excludedDocIterators = append(excludedDocIterators, excludedDocIterator)
+		}
+	}
+
+	return newRootBooleanDocIterator(requiredDocIterator, optionalDocIterators, excludedDocIterators)
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// RootBooleanDocIterator
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+// RootBooleanDocIterator iterates over the docs of the required iterator that
+// are not matched by any excluded iterator, and adds the score of the optional
+// iterators that match them.
+type RootBooleanDocIterator struct {
+	excludedIterators  []ChildDocIterator
+	optionalIterators  []ChildDocIterator
+	optionalUpperBound float32
+	requiredIterator   RootDocIterator
+}
+
+func newRootBooleanDocIterator(requiredIterator RootDocIterator, optionalIterators []ChildDocIterator, excludedIterators []ChildDocIterator) *RootBooleanDocIterator {
+	optionalUpperBound := float32(0)
+	for _, optionalIterator := range optionalIterators {
+		optionalUpperBound += optionalIterator.GlobalUpperBound()
+	}
+
+	return &RootBooleanDocIterator{
+		excludedIterators:  excludedIterators,
+		optionalIterators:  optionalIterators,
+		optionalUpperBound: optionalUpperBound,
+		requiredIterator:   requiredIterator,
+	}
+}
+
+func (b *RootBooleanDocIterator) Next(fieldLengthNorms *index.FieldLengthNorms, lowerBound float32) (index.DocumentId, float32, bool) {
+	for {
+		// The optional iterators can add at most optionalUpperBound to the
+		// score, so the required iterator can still skip the blocks that
+		// cannot reach the rest of the lower bound.
+		docId, score, exists := b.requiredIterator.Next(fieldLengthNorms, lowerBound-b.optionalUpperBound)
+		if !exists {
+			return 0, 0, false
+		}
+
+		if b.isExcluded(docId) {
+			continue
+		}
+
+		childIndexesToRemove := make([]int, 0)
+		for i, it := range b.optionalIterators {
+			if !it.Next(docId) {
+				childIndexesToRemove = append(childIndexesToRemove, i)
+				continue
+			}
+
+			if it.DocId() == docId {
+				score += it.Score(fieldLengthNorms)
+			}
+		}
+
+		for i := len(childIndexesToRemove) - 1; i >= 0; i-- {
+			b.optionalIterators = removeElement(b.optionalIterators, childIndexesToRemove[i])
+		}
+
+		return docId, score, true
+	}
+}
+
+func (b *RootBooleanDocIterator) isExcluded(docId index.DocumentId) bool {
+	excluded := false
+
+	childIndexesToRemove := make([]int, 0)
+	for i, it := range b.excludedIterators {
+		if !it.Next(docId) {
+			childIndexesToRemove = append(childIndexesToRemove, i)
+			continue
+		}
+
+		if it.DocId() == docId {
+			excluded = true
+			break
+		}
+	}
+
+	for i := len(childIndexesToRemove) - 1; i >= 0; i-- {
+		b.excludedIterators = removeElement(b.excludedIterators, childIndexesToRemove[i])
+	}
+
+	return excluded
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// BooleanChildNode
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+type BooleanChildNode struct {
+	requiredNode  ChildNode
+	optionalNodes []ChildNode
+	excludedNodes []ChildNode
+}
+
+func (n *BooleanChildNode) CreateChildDocIterator(context *ExecutionContext, segmentIndex int) ChildDocIterator {
+	requiredDocIterator := n.requiredNode.CreateChildDocIterator(context, segmentIndex)
+	if requiredDocIterator == nil {
+		return nil
+	}
+
+	optionalDocIterators := make([]ChildDocIterator, 0, len(n.optionalNodes))
+	for _, optionalNode := range n.optionalNodes {
+		optionalDocIterator := optionalNode.CreateChildDocIterator(context, segmentIndex)
+		if optionalDocIterator != nil {
+			optionalDocIterators = append(optionalDocIterators, optionalDocIterator)
+		}
+	}
+
+	excludedDocIterators := make([]ChildDocIterator, 0, len(n.excludedNodes))
+	for _, excludedNode := range n.excludedNodes {
+		excludedDocIterator := excludedNode.CreateChildDocIterator(context, segmentIndex)
+		if excludedDocIterator != nil {
+			excludedDocIterators = append(excludedDocIterators, excludedDocIterator)
+		}
+	}
+
+	return newChildBooleanDocIterator(requiredDocIterator, optionalDocIterators, excludedDocIterators)
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// ChildBooleanDocIterator
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+// ChildBooleanDocIterator is the child counterpart of RootBooleanDocIterator.
+type ChildBooleanDocIterator struct {
+	docId             index.DocumentId
+	excludedIterators []ChildDocIterator
+	globalUpperBound  float32
+	optionalIterators []ChildDocIterator
+	requiredIterator  ChildDocIterator
+}
+
+func newChildBooleanDocIterator(requiredIterator ChildDocIterator, optionalIterators []ChildDocIterator, excludedIterators []ChildDocIterator) *ChildBooleanDocIterator {
+	globalUpperBound := requiredIterator.GlobalUpperBound()
+	for _, optionalIterator := range optionalIterators {
+		globalUpperBound += optionalIterator.GlobalUpperBound()
+	}
+
+	return &ChildBooleanDocIterator{
+		excludedIterators: excludedIterators,
+		globalUpperBound:  globalUpperBound,
+		optionalIterators: optionalIterators,
+		requiredIterator:  requiredIterator,
+	}
+}
+
+func (b *ChildBooleanDocIterator) BlockMaxDocId() index.DocumentId {
+	blockMaxDocId := b.requiredIterator.BlockMaxDocId()
+
+	for _, it := range b.optionalIterators {
+		blockMaxDocId = min(blockMaxDocId, it.BlockMaxDocId())
+	}
+
+	return blockMaxDocId
+}
+
+func (b *ChildBooleanDocIterator) BlockUpperBound() float32 {
+	upperBound := b.requiredIterator.BlockUpperBound()
+
+	for _, it := range b.optionalIterators {
+		upperBound += it.BlockUpperBound()
+	}
+
+	return upperBound
+}
+
+func (b *ChildBooleanDocIterator) DocId() index.DocumentId {
+	return b.docId
+}
+
+func (b *ChildBooleanDocIterator) GlobalUpperBound() float32 {
+	return b.globalUpperBound
+}
+
+func (b *ChildBooleanDocIterator) IDF() float32 {
+	return b.requiredIterator.IDF()
+}
+
+func (b *ChildBooleanDocIterator) Next(docId index.DocumentId) bool {
+	for {
+		if !b.requiredIterator.Next(docId) {
+			return false
+		}
+
+		docId = b.requiredIterator.DocId()
+
+		if !b.isExcluded(docId) {
+			b.docId = docId
+			return true
+		}
+
+		docId++
+	}
+}
+
+func (b *ChildBooleanDocIterator) isExcluded(docId index.DocumentId) bool {
+	excluded := false
+
+	childIndexesToRemove := make([]int, 0)
+	for i, it := range b.excludedIterators {
+		if !it.Next(docId) {
+			childIndexesToRemove = append(childIndexesToRemove, i)
+			continue
+		}
+
+		if it.DocId() == docId {
+			excluded = true
+			break
+		}
+	}
+
+	for i := len(childIndexesToRemove) - 1; i >= 0; i-- {
+		b.excludedIterators = removeElement(b.excludedIterators, childIndexesToRemove[i])
+	}
+
+	return excluded
+}
+
+func (b *ChildBooleanDocIterator) NextShallow(docId index.DocumentId) bool {
+	if !b.requiredIterator.NextShallow(docId) {
+		return false
+	}
+
+	childIndexesToRemove := make([]int, 0)
+	for i, it := range b.optionalIterators {
+		if !it.NextShallow(docId) {
+			childIndexesToRemove = append(childIndexesToRemove, i)
+		}
+	}
+
+	for i := len(childIndexesToRemove) - 1; i >= 0; i-- {
+		b.optionalIterators = removeElement(b.optionalIterators, childIndexesToRemove[i])
+	}
+
+	return true
+}
+
+func (b *ChildBooleanDocIterator) Score(fieldLengthNorms *index.FieldLengthNorms) float32 {
+	score := b.requiredIterator.Score(fieldLengthNorms)
+
+	childIndexesToRemove := make([]int, 0)
+	for i, it := range b.optionalIterators {
+		if !it.Next(b.docId) {
+			childIndexesToRemove = append(childIndexesToRemove, i)
+			continue
+		}
+
+		if it.DocId() == b.docId {
+			score += it.Score(fieldLengthNorms)
+		}
+	}
+
+	for i := len(childIndexesToRemove) - 1; i >= 0; i-- {
+		b.optionalIterators = removeElement(b.optionalIterators, childIndexesToRemove[i])
+	}
+
+	return score
 }
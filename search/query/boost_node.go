@@ -0,0 +1,150 @@
+package query
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/larose/lynx/search/index"
+)
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// Node
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+// BoostNode matches the documents of Node and multiplies their score by
+// Boost, e.g. to weight the matches in a title above the matches in a body.
+// Boost must be positive.
+type BoostNode struct {
+	Node  Node
+	Boost float32
+}
+
+func (b *BoostNode) validate() error {
+	if !(b.Boost > 0) || math.IsInf(float64(b.Boost), 1) {
+		return fmt.Errorf("boost must be positive and finite: %v", b.Boost)
+	}
+
+	return nil
+}
+
+func (b *BoostNode) CreateRootNode(context *QueryContext) (RootNode, error) {
+	if err := b.validate(); err != nil {
+		return nil, err
+	}
+
+	rootNode, err := b.Node.CreateRootNode(context)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BoostRootNode{rootNode: rootNode, boost: b.Boost}, nil
+}
+
+func (b *BoostNode) CreateChildNode(context *QueryContext) (ChildNode, error) {
+	if err := b.validate(); err != nil {
+		return nil, err
+	}
+
+	childNode, err := b.Node.CreateChildNode(context)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BoostChildNode{childNode: childNode, boost: b.Boost}, nil
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// BoostRootNode
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+type BoostRootNode struct {
+	rootNode RootNode
+	boost    float32
+}
+
+func (b *BoostRootNode) CreateRootDocIterator(context *ExecutionContext, segmentIndex int) RootDocIterator {
+	rootDocIterator := b.rootNode.CreateRootDocIterator(context, segmentIndex)
+	if rootDocIterator == nil {
+		return nil
+	}
+
+	return &RootBoostDocIterator{iterator: rootDocIterator, boost: b.boost}
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// RootBoostDocIterator
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+type RootBoostDocIterator struct {
+	iterator RootDocIterator
+	boost    float32
+}
+
+// The docs of the iterator must score at least lowerBound / boost. The bound
+// is rounded down so that no doc reaching lowerBound once boosted is skipped.
+func (b *RootBoostDocIterator) Next(fieldLengthNorms *index.FieldLengthNorms, lowerBound float32) (index.DocumentId, float32, bool) {
+	docId, score, exists := b.iterator.Next(fieldLengthNorms, math.Nextafter32(lowerBound/b.boost, 0))
+	return docId, score * b.boost, exists
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// BoostChildNode
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+type BoostChildNode struct {
+	childNode ChildNode
+	boost     float32
+}
+
+func (b *BoostChildNode) CreateChildDocIterator(context *ExecutionContext, segmentIndex int) ChildDocIterator {
+	childDocIterator := b.childNode.CreateChildDocIterator(context, segmentIndex)
+	if childDocIterator == nil {
+		return nil
+	}
+
+	return &ChildBoostDocIterator{iterator: childDocIterator, boost: b.boost}
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// ChildBoostDocIterator
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+// ChildBoostDocIterator multiplies the scores and the upper bounds of its
+// iterator by the boost. The IDF, used to pick the rarest iterators, is left
+// as is.
+type ChildBoostDocIterator struct {
+	iterator ChildDocIterator
+	boost    float32
+}
+
+func (b *ChildBoostDocIterator) BlockMaxDocId() index.DocumentId {
+	return b.iterator.BlockMaxDocId()
+}
+
+func (b *ChildBoostDocIterator) BlockUpperBound() float32 {
+	return b.iterator.BlockUpperBound() * b.boost
+}
+
+func (b *ChildBoostDocIterator) DocId() index.DocumentId {
+	return b.iterator.DocId()
+}
+
+func (b *ChildBoostDocIterator) GlobalUpperBound() float32 {
+	return b.iterator.GlobalUpperBound() * b.boost
+}
+
+func (b *ChildBoostDocIterator) IDF() float32 {
+	return b.iterator.IDF()
+}
+
+func (b *ChildBoostDocIterator) Next(docId index.DocumentId) bool {
+	return b.iterator.Next(docId)
+}
+
+func (b *ChildBoostDocIterator) NextShallow(docId index.DocumentId) bool {
+	return b.iterator.NextShallow(docId)
+}
+
+func (b *ChildBoostDocIterator) Score(fieldLengthNorms *index.FieldLengthNorms) float32 {
+	return b.iterator.Score(fieldLengthNorms) * b.boost
+}
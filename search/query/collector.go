@@ -2,13 +2,27 @@ package query
 
 import (
 	"container/heap"
+	"math"
+
+	"github.com/larose/lynx/search/index"
 )
 
 type Collector interface {
 	Collect(docId uint64, score float32)
+	// LowerBound is the score a doc must exceed to be collected. It is
+	// negative while any doc may be collected, including the docs that score
+	// 0, like the docs of a RangeNode.
 	LowerBound() float32
 }
 
+// SegmentCollector is a Collector that reads the segments of the docs it
+// collects. SetSegment is called before the docs of each segment are
+// collected.
+type SegmentCollector interface {
+	Collector
+	SetSegment(segmentReader *index.SegmentReader) error
+}
+
 type DocScore struct {
 	DocId uint64
 	Score float32
@@ -65,7 +79,7 @@ func (c *TopNCollector) Get() []*DocScore {
 
 func (c *TopNCollector) LowerBound() float32 {
 	if len(c.minHeap.items) < c.topN {
-		return 0
+		return float32(math.Inf(-1))
 	}
 
 	return c.minHeap.items[0].Key
@@ -62,13 +62,13 @@ func (d *RootConjunctionDocIterator) Next(fieldLengthNorms *index.FieldLengthNor
 		maxDocId := d.childIterators[0].DocId()
 
 		allAtMaxDocId := true
-		childIndexesToRemove := make([]int, 0)
-		for i, child := range d.childIterators {
+		for _, child := range d.childIterators {
 			hasNext := child.Next(maxDocId)
 
+			// A doc must match every child, so there are no more matches
 			if !hasNext {
-				childIndexesToRemove = append(childIndexesToRemove, i)
-				continue
+				d.childIterators = nil
+				return 0, 0, false
 			}
 
 			allAtMaxDocId = child.DocId() == maxDocId
@@ -78,31 +78,156 @@ func (d *RootConjunctionDocIterator) Next(fieldLengthNorms *index.FieldLengthNor
 			}
 		}
 
-		for i := len(childIndexesToRemove) - 1; i >= 0; i-- {
-			d.childIterators = removeElement(d.childIterators, childIndexesToRemove[i])
-		}
-
 		if !allAtMaxDocId {
 			continue
 		}
 
 		fieldLengthNorms.SetDocId(maxDocId)
 
-		childIndexesToRemove = make([]int, 0)
+		exhausted := false
 		score := float32(0)
-		for i, child := range d.childIterators {
+		for _, child := range d.childIterators {
 			score += child.Score(fieldLengthNorms)
 			hasNext := child.Next(maxDocId + 1)
 
 			if !hasNext {
-				childIndexesToRemove = append(childIndexesToRemove, i)
+				exhausted = true
 			}
 		}
 
-		for i := len(childIndexesToRemove) - 1; i >= 0; i-- {
-			d.childIterators = removeElement(d.childIterators, childIndexesToRemove[i])
+		if exhausted {
+			d.childIterators = nil
 		}
 
 		return maxDocId, score, true
 	}
 }
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// ConjunctionChildNode
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+type ConjunctionChildNode struct {
+	childNodes []ChildNode
+}
+
+func (c *ConjunctionChildNode) CreateChildDocIterator(context *ExecutionContext, segmentIndex int) ChildDocIterator {
+	childDocIterators := make([]ChildDocIterator, 0, len(c.childNodes))
+
+	for _, childNode := range c.childNodes {
+		childDocIterator := childNode.CreateChildDocIterator(context, segmentIndex)
+		if childDocIterator == nil {
+			return nil
+		}
+
+		childDocIterators = append(childDocIterators, childDocIterator)
+	}
+
+	return newChildConjunctionDocIterator(childDocIterators)
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// ChildConjunctionDocIterator
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+type ChildConjunctionDocIterator struct {
+	childIterators   []ChildDocIterator
+	docId            index.DocumentId
+	globalUpperBound float32
+	idf              float32
+}
+
+func newChildConjunctionDocIterator(childIterators []ChildDocIterator) *ChildConjunctionDocIterator {
+	globalUpperBound := float32(0)
+	idf := float32(0)
+
+	for _, child := range childIterators {
+		globalUpperBound += child.GlobalUpperBound()
+		// A conjunction is at least as rare as its rarest child
+		idf = max(idf, child.IDF())
+	}
+
+	return &ChildConjunctionDocIterator{
+		childIterators:   childIterators,
+		globalUpperBound: globalUpperBound,
+		idf:              idf,
+	}
+}
+
+// The block of a conjunction ends with the first block of its children to end.
+func (c *ChildConjunctionDocIterator) BlockMaxDocId() index.DocumentId {
+	blockMaxDocId := c.childIterators[0].BlockMaxDocId()
+
+	for _, child := range c.childIterators[1:] {
+		blockMaxDocId = min(blockMaxDocId, child.BlockMaxDocId())
+	}
+
+	return blockMaxDocId
+}
+
+func (c *ChildConjunctionDocIterator) BlockUpperBound() float32 {
+	upperBound := float32(0)
+
+	for _, child := range c.childIterators {
+		upperBound += child.BlockUpperBound()
+	}
+
+	return upperBound
+}
+
+func (c *ChildConjunctionDocIterator) DocId() index.DocumentId {
+	return c.docId
+}
+
+func (c *ChildConjunctionDocIterator) GlobalUpperBound() float32 {
+	return c.globalUpperBound
+}
+
+func (c *ChildConjunctionDocIterator) IDF() float32 {
+	return c.idf
+}
+
+func (c *ChildConjunctionDocIterator) Next(docId index.DocumentId) bool {
+	candidateDocId := docId
+
+	for {
+		allAtCandidateDocId := true
+
+		for _, child := range c.childIterators {
+			if !child.Next(candidateDocId) {
+				return false
+			}
+
+			if child.DocId() != candidateDocId {
+				candidateDocId = child.DocId()
+				allAtCandidateDocId = false
+				break
+			}
+		}
+
+		if allAtCandidateDocId {
+			c.docId = candidateDocId
+			return true
+		}
+	}
+}
+
+func (c *ChildConjunctionDocIterator) NextShallow(docId index.DocumentId) bool {
+	for _, child := range c.childIterators {
+		if !child.NextShallow(docId) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (c *ChildConjunctionDocIterator) Score(fieldLengthNorms *index.FieldLengthNorms) float32 {
+	score := float32(0)
+
+	for _, child := range c.childIterators {
+		score += child.Score(fieldLengthNorms)
+	}
+
+	return score
+}
@@ -2,7 +2,6 @@ package query
 
 import (
 	"cmp"
-	"log"
 	"slices"
 
 	"github.com/larose/lynx/search/index"
@@ -84,7 +83,13 @@ func (d *RootDisjunctionDocIterator) Next(documentContext *index.FieldLengthNorm
 
 		pivotDocId := d.childIterators[childPivotIndex].DocId()
 
-		for i := 0; i < childPivotIndex; {
+		// Children on the pivot doc are part of the pivot
+		for childPivotIndex+1 < len(d.childIterators) && d.childIterators[childPivotIndex+1].DocId() == pivotDocId {
+			childPivotIndex++
+		}
+
+		childRemoved := false
+		for i := 0; i <= childPivotIndex; {
 			hasDocs := d.childIterators[i].NextShallow(pivotDocId)
 			if hasDocs {
 				i++
@@ -93,10 +98,11 @@ func (d *RootDisjunctionDocIterator) Next(documentContext *index.FieldLengthNorm
 
 			d.childIterators = append(d.childIterators[:i], d.childIterators[i+1:]...)
 			childPivotIndex--
+			childRemoved = true
 		}
 
-		if len(d.childIterators) == 0 {
-			return 0, 0, false
+		if childRemoved {
+			continue
 		}
 
 		upperBound := float32(0)
@@ -104,27 +110,27 @@ func (d *RootDisjunctionDocIterator) Next(documentContext *index.FieldLengthNorm
 			upperBound += d.childIterators[i].BlockUpperBound()
 		}
 
-		// If current blocks cannot make it
+		// If current blocks cannot make it, skip to the end of the first block
+		// to end or to the next doc of the children after the pivot
 		if upperBound <= lowerBound {
 			maxIdf := float32(0)
 			bestChildrenIndex := 0
-			maxDocId := index.DocumentId(0)
-			for i, it := range d.childIterators {
-				if i <= childPivotIndex {
-					idf := it.IDF()
-					if idf > maxIdf {
-						maxIdf = idf
-						bestChildrenIndex = i
-					}
+			nextDocId := d.childIterators[0].BlockMaxDocId() + 1
+			for i, it := range d.childIterators[:childPivotIndex+1] {
+				idf := it.IDF()
+				if idf > maxIdf {
+					maxIdf = idf
+					bestChildrenIndex = i
 				}
 
-				blockMaxDocId := it.BlockMaxDocId()
-				if blockMaxDocId > maxDocId {
-					maxDocId = blockMaxDocId
-				}
+				nextDocId = min(nextDocId, it.BlockMaxDocId()+1)
+			}
+
+			if childPivotIndex+1 < len(d.childIterators) {
+				nextDocId = min(nextDocId, d.childIterators[childPivotIndex+1].DocId())
 			}
 
-			if !d.childIterators[bestChildrenIndex].Next(maxDocId + 1) {
+			if !d.childIterators[bestChildrenIndex].Next(nextDocId) {
 				d.childIterators = removeElement(d.childIterators, bestChildrenIndex)
 			}
 			continue
@@ -135,6 +141,10 @@ func (d *RootDisjunctionDocIterator) Next(documentContext *index.FieldLengthNorm
 			maxIdf := float32(0)
 
 			for i, it := range d.childIterators[:childPivotIndex] {
+				if it.DocId() == pivotDocId {
+					break
+				}
+
 				idf := it.IDF()
 				if idf > maxIdf {
 					maxIdf = idf
@@ -143,7 +153,7 @@ func (d *RootDisjunctionDocIterator) Next(documentContext *index.FieldLengthNorm
 
 			}
 
-			if !d.childIterators[bestChildrenIndex].Next(pivotDocId + 1) {
+			if !d.childIterators[bestChildrenIndex].Next(pivotDocId) {
 				d.childIterators = removeElement(d.childIterators, bestChildrenIndex)
 			}
 			continue
@@ -152,14 +162,19 @@ func (d *RootDisjunctionDocIterator) Next(documentContext *index.FieldLengthNorm
 		documentContext.SetDocId(pivotDocId)
 
 		score := float32(0)
+		matched := false
 		// TODO: speed up with evaluatePartial
 		childIndexesToRemove := make([]int, 0)
 		for i, it := range d.childIterators {
+			// Children that are not term iterators (e.g. phrases) can have
+			// blocks without any match
 			if !it.Next(pivotDocId) {
-				log.Fatal("Next(pivotDocId) returned false")
+				childIndexesToRemove = append(childIndexesToRemove, i)
+				continue
 			}
 
 			if it.DocId() == pivotDocId {
+				matched = true
 				score += it.Score(documentContext)
 
 				if !it.Next(pivotDocId + 1) {
@@ -172,6 +187,154 @@ func (d *RootDisjunctionDocIterator) Next(documentContext *index.FieldLengthNorm
 			d.childIterators = removeElement(d.childIterators, childIndexesToRemove[i])
 		}
 
+		if !matched {
+			continue
+		}
+
 		return pivotDocId, score, true
 	}
 }
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// DisjunctionChildNode
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+type DisjunctionChildNode struct {
+	childNodes []ChildNode
+}
+
+func (d *DisjunctionChildNode) CreateChildDocIterator(context *ExecutionContext, segmentIndex int) ChildDocIterator {
+	childDocIterators := make([]ChildDocIterator, 0, len(d.childNodes))
+
+	for _, childNode := range d.childNodes {
+		childDocIterator := childNode.CreateChildDocIterator(context, segmentIndex)
+		if childDocIterator != nil {
+			childDocIterators = append(childDocIterators, childDocIterator)
+		}
+	}
+
+	if len(childDocIterators) == 0 {
+		return nil
+	}
+
+	return newChildDisjunctionDocIterator(childDocIterators)
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// ChildDisjunctionDocIterator
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+type ChildDisjunctionDocIterator struct {
+	childIterators   []ChildDocIterator
+	docId            index.DocumentId
+	globalUpperBound float32
+	idf              float32
+}
+
+func newChildDisjunctionDocIterator(childIterators []ChildDocIterator) *ChildDisjunctionDocIterator {
+	globalUpperBound := float32(0)
+	idf := childIterators[0].IDF()
+
+	for _, child := range childIterators {
+		globalUpperBound += child.GlobalUpperBound()
+		// A disjunction is at most as rare as its most common child
+		idf = min(idf, child.IDF())
+	}
+
+	return &ChildDisjunctionDocIterator{
+		childIterators:   childIterators,
+		globalUpperBound: globalUpperBound,
+		idf:              idf,
+	}
+}
+
+// The upper bound of the block holds until the first block of the children
+// ends.
+func (d *ChildDisjunctionDocIterator) BlockMaxDocId() index.DocumentId {
+	blockMaxDocId := d.childIterators[0].BlockMaxDocId()
+
+	for _, child := range d.childIterators[1:] {
+		blockMaxDocId = min(blockMaxDocId, child.BlockMaxDocId())
+	}
+
+	return blockMaxDocId
+}
+
+func (d *ChildDisjunctionDocIterator) BlockUpperBound() float32 {
+	upperBound := float32(0)
+
+	for _, child := range d.childIterators {
+		upperBound += child.BlockUpperBound()
+	}
+
+	return upperBound
+}
+
+func (d *ChildDisjunctionDocIterator) DocId() index.DocumentId {
+	return d.docId
+}
+
+func (d *ChildDisjunctionDocIterator) GlobalUpperBound() float32 {
+	return d.globalUpperBound
+}
+
+func (d *ChildDisjunctionDocIterator) IDF() float32 {
+	return d.idf
+}
+
+func (d *ChildDisjunctionDocIterator) Next(docId index.DocumentId) bool {
+	childIndexesToRemove := make([]int, 0)
+	minDocId := index.DocumentId(0)
+	found := false
+
+	for i, child := range d.childIterators {
+		if !child.Next(docId) {
+			childIndexesToRemove = append(childIndexesToRemove, i)
+			continue
+		}
+
+		if !found || child.DocId() < minDocId {
+			minDocId = child.DocId()
+			found = true
+		}
+	}
+
+	for i := len(childIndexesToRemove) - 1; i >= 0; i-- {
+		d.childIterators = removeElement(d.childIterators, childIndexesToRemove[i])
+	}
+
+	if !found {
+		return false
+	}
+
+	d.docId = minDocId
+	return true
+}
+
+func (d *ChildDisjunctionDocIterator) NextShallow(docId index.DocumentId) bool {
+	childIndexesToRemove := make([]int, 0)
+
+	for i, child := range d.childIterators {
+		if !child.NextShallow(docId) {
+			childIndexesToRemove = append(childIndexesToRemove, i)
+		}
+	}
+
+	for i := len(childIndexesToRemove) - 1; i >= 0; i-- {
+		d.childIterators = removeElement(d.childIterators, childIndexesToRemove[i])
+	}
+
+	return len(d.childIterators) > 0
+}
+
+func (d *ChildDisjunctionDocIterator) Score(fieldLengthNorms *index.FieldLengthNorms) float32 {
+	score := float32(0)
+
+	for _, child := range d.childIterators {
+		if child.DocId() == d.docId {
+			score += child.Score(fieldLengthNorms)
+		}
+	}
+
+	return score
+}
@@ -7,9 +7,14 @@ import (
 )
 
 type ExecutionContext struct {
-	// fieldFreqsReaders[segmentIndex][fieldIndex]
+	// fieldFreqsReaders[segmentIndex][fieldIndex], nil if the segment doesn't
+	// have the field, like the other readers
 	fieldFreqsReaders [][]*index.FieldFreqsReader
 
+	// fieldPositionsReaders[segmentIndex][fieldIndex], nil if the field has
+	// no positions in the segment
+	fieldPositionsReaders [][]*index.FieldPositionsReader
+
 	// FieldLengthReaders[segmentIndex][fieldIndex]
 	FieldLengthReaders [][]*index.FieldLengthReader
 
@@ -34,6 +39,7 @@ type FieldStats struct {
 
 func GenerateExecutionContext(queryContext *QueryContext, segmentReaders []*index.SegmentReader) (*ExecutionContext, error) {
 	fieldFreqsReaders := make([][]*index.FieldFreqsReader, len(segmentReaders))
+	fieldPositionsReaders := make([][]*index.FieldPositionsReader, len(segmentReaders))
 	fieldLengthReaders := make([][]*index.FieldLengthReader, len(segmentReaders))
 	fieldStats := make([]*FieldStats, len(queryContext.Fields))
 	for i, field := range queryContext.Fields {
@@ -45,6 +51,9 @@ func GenerateExecutionContext(queryContext *QueryContext, segmentReaders []*inde
 		fieldFreqsReadersForSegment := make([]*index.FieldFreqsReader, len(queryContext.Fields))
 		fieldFreqsReaders[i] = fieldFreqsReadersForSegment
 
+		fieldPositionsReadersForSegment := make([]*index.FieldPositionsReader, len(queryContext.Fields))
+		fieldPositionsReaders[i] = fieldPositionsReadersForSegment
+
 		fieldLengthReadersForSegment := make([]*index.FieldLengthReader, len(queryContext.Fields))
 		fieldLengthReaders[i] = fieldLengthReadersForSegment
 
@@ -52,6 +61,11 @@ func GenerateExecutionContext(queryContext *QueryContext, segmentReaders []*inde
 		termInfos[i] = termsInfosByFieldAndTerm
 
 		for j, field := range queryContext.Fields {
+			// No term of the field in the segment, and no readers
+			if !segmentReader.Info.HasField(field.name) {
+				termsInfosByFieldAndTerm[j] = make([]*index.TermInfo, len(field.terms))
+				continue
+			}
 
 			fieldFreqsReader, err := segmentReader.FieldFreqsReader(field.name)
 			if err != nil {
@@ -59,6 +73,15 @@ func GenerateExecutionContext(queryContext *QueryContext, segmentReaders []*inde
 			}
 			fieldFreqsReadersForSegment[j] = fieldFreqsReader
 
+			// Phrase nodes reject the fields without positions
+			if segmentReader.Info.HasPositions(field.name) {
+				fieldPositionsReader, err := segmentReader.FieldPositionsReader(field.name)
+				if err != nil {
+					return nil, err
+				}
+				fieldPositionsReadersForSegment[j] = fieldPositionsReader
+			}
+
 			fieldLengthReader, err := segmentReader.DocLengthReader.FieldLengthReader(field.name)
 			if err != nil {
 				return nil, err
@@ -131,6 +154,7 @@ func GenerateExecutionContext(queryContext *QueryContext, segmentReaders []*inde
 
 	return &ExecutionContext{
 		fieldFreqsReaders:     fieldFreqsReaders,
+		fieldPositionsReaders: fieldPositionsReaders,
 		FieldLengthReaders:    fieldLengthReaders,
 		PrecomputedFieldNorms: precomputedFieldNorms,
 		segmentReaders:        segmentReaders,
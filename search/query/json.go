@@ -0,0 +1,484 @@
+package query
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// JSON format
+//
+// Each node is an object with a single key, its type, whose value holds the
+// fields of the node:
+//
+//	{"boolean": {"clauses": [
+//	    {"type": "must", "node": {"term": {"field": "body", "term": "hello"}}},
+//	    {"type": "mustNot", "node": {"phrase": {"field": "title", "terms": ["hello", "world"], "slop": 1}}}
+//	]}}
+//
+// Types: boolean, boost, match, matchPhrase, phrase, prefix, range, term.
+// Clause types: should, must, mustNot. Terms are strings, or base64 in
+// termBytes, termsBytes and prefixBytes when they are not valid UTF-8, e.g.
+// the values of byte fields. The bounds of ranges are always base64, in
+// lowerBytes and upperBytes. Unknown types and fields are errors.
+
+// MarshalJSON encodes a query in the JSON format
+func MarshalJSON(node Node) ([]byte, error) {
+	return marshalNode(node)
+}
+
+// UnmarshalJSON decodes a query in the JSON format
+func UnmarshalJSON(data []byte) (Node, error) {
+	return unmarshalNode(data)
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// Nodes
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+type jsonBooleanNode struct {
+	Clauses []*jsonBooleanClause `json:"clauses"`
+}
+
+type jsonBooleanClause struct {
+	Type string          `json:"type"`
+	Node json.RawMessage `json:"node"`
+}
+
+type jsonBoostNode struct {
+	Node  json.RawMessage `json:"node"`
+	Boost float32         `json:"boost"`
+}
+
+type jsonMatchNode struct {
+	Field    string `json:"field"`
+	Text     string `json:"text"`
+	Operator string `json:"operator,omitempty"`
+}
+
+type jsonMatchPhraseNode struct {
+	Field string `json:"field"`
+	Text  string `json:"text"`
+	Slop  int    `json:"slop,omitempty"`
+}
+
+type jsonPhraseNode struct {
+	Field      string   `json:"field"`
+	Terms      []string `json:"terms,omitempty"`
+	TermsBytes [][]byte `json:"termsBytes,omitempty"`
+	Slop       int      `json:"slop,omitempty"`
+}
+
+type jsonPrefixNode struct {
+	Field       string  `json:"field"`
+	Prefix      *string `json:"prefix,omitempty"`
+	PrefixBytes []byte  `json:"prefixBytes,omitempty"`
+}
+
+type jsonRangeNode struct {
+	Field        string `json:"field"`
+	LowerBytes   []byte `json:"lowerBytes,omitempty"`
+	UpperBytes   []byte `json:"upperBytes,omitempty"`
+	IncludeLower bool   `json:"includeLower,omitempty"`
+	IncludeUpper bool   `json:"includeUpper,omitempty"`
+}
+
+type jsonTermNode struct {
+	Field     string  `json:"field"`
+	Term      *string `json:"term,omitempty"`
+	TermBytes []byte  `json:"termBytes,omitempty"`
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// Marshal
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+func marshalNode(node Node) (json.RawMessage, error) {
+	var nodeType string
+	var value any
+
+	switch n := node.(type) {
+	case *BooleanNode:
+		clauses := make([]*jsonBooleanClause, len(n.Clauses))
+
+		for i, clause := range n.Clauses {
+			if clause == nil || clause.Node == nil {
+				return nil, fmt.Errorf("boolean clause %d: nil node", i)
+			}
+
+			clauseType, err := marshalMatchType(clause.Type)
+			if err != nil {
+				return nil, err
+			}
+
+			clauseNode, err := marshalNode(clause.Node)
+			if err != nil {
+				return nil, err
+			}
+
+			clauses[i] = &jsonBooleanClause{Type: clauseType, Node: clauseNode}
+		}
+
+		nodeType, value = "boolean", &jsonBooleanNode{Clauses: clauses}
+
+	case *BoostNode:
+		node, err := marshalNode(n.Node)
+		if err != nil {
+			return nil, err
+		}
+
+		nodeType, value = "boost", &jsonBoostNode{Node: node, Boost: n.Boost}
+
+	case *MatchNode:
+		operator, err := marshalMatchType(n.Operator)
+		if err != nil {
+			return nil, err
+		}
+
+		nodeType, value = "match", &jsonMatchNode{Field: n.FieldName, Text: n.Text, Operator: operator}
+
+	case *MatchPhraseNode:
+		nodeType, value = "matchPhrase", &jsonMatchPhraseNode{Field: n.FieldName, Text: n.Text, Slop: n.Slop}
+
+	case *PhraseNode:
+		phraseNode := &jsonPhraseNode{Field: n.FieldName, Slop: n.Slop}
+
+		if allValidUTF8(n.Terms) {
+			phraseNode.Terms = make([]string, len(n.Terms))
+			for i, term := range n.Terms {
+				phraseNode.Terms[i] = string(term)
+			}
+		} else {
+			phraseNode.TermsBytes = n.Terms
+		}
+
+		nodeType, value = "phrase", phraseNode
+
+	case *PrefixNode:
+		prefixNode := &jsonPrefixNode{Field: n.FieldName}
+		prefixNode.Prefix, prefixNode.PrefixBytes = marshalTerm(n.Prefix)
+
+		nodeType, value = "prefix", prefixNode
+
+	case *RangeNode:
+		nodeType, value = "range", &jsonRangeNode{
+			Field:        n.FieldName,
+			LowerBytes:   n.Lower,
+			UpperBytes:   n.Upper,
+			IncludeLower: n.IncludeLower,
+			IncludeUpper: n.IncludeUpper,
+		}
+
+	case *TermNode:
+		termNode := &jsonTermNode{Field: n.FieldName}
+		termNode.Term, termNode.TermBytes = marshalTerm(n.Term)
+
+		nodeType, value = "term", termNode
+
+	case nil:
+		return nil, errors.New("missing node")
+
+	default:
+		return nil, fmt.Errorf("node type %T has no JSON format", node)
+	}
+
+	return json.Marshal(map[string]any{nodeType: value})
+}
+
+func marshalMatchType(matchType MatchType) (string, error) {
+	switch matchType {
+	case Should:
+		return "should", nil
+	case Must:
+		return "must", nil
+	case MustNot:
+		return "mustNot", nil
+	default:
+		return "", fmt.Errorf("unknown match type %d", matchType)
+	}
+}
+
+// Returns the term as a string when it's valid UTF-8, and as bytes otherwise
+func marshalTerm(term []byte) (*string, []byte) {
+	if utf8.Valid(term) {
+		s := string(term)
+		return &s, nil
+	}
+
+	return nil, term
+}
+
+func allValidUTF8(terms [][]byte) bool {
+	for _, term := range terms {
+		if !utf8.Valid(term) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// Unmarshal
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+func unmarshalNode(data json.RawMessage) (Node, error) {
+	if len(data) == 0 {
+		return nil, errors.New("missing node")
+	}
+
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(data, &object); err != nil {
+		return nil, err
+	}
+
+	if object == nil {
+		return nil, errors.New("missing node")
+	}
+
+	if len(object) != 1 {
+		return nil, fmt.Errorf("node must have exactly one type, got %d", len(object))
+	}
+
+	var nodeType string
+	var value json.RawMessage
+	for nodeType, value = range object {
+	}
+
+	node, err := unmarshalNodeOfType(nodeType, value)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", nodeType, err)
+	}
+
+	return node, nil
+}
+
+func unmarshalNodeOfType(nodeType string, data json.RawMessage) (Node, error) {
+	switch nodeType {
+	case "boolean":
+		var value jsonBooleanNode
+		if err := decodeStrict(data, &value); err != nil {
+			return nil, err
+		}
+
+		clauses := make([]*BooleanClause, len(value.Clauses))
+
+		for i, clause := range value.Clauses {
+			if clause == nil {
+				return nil, fmt.Errorf("clauses[%d]: missing clause", i)
+			}
+
+			matchType, err := unmarshalMatchType(clause.Type)
+			if err != nil {
+				return nil, fmt.Errorf("clauses[%d]: %w", i, err)
+			}
+
+			node, err := unmarshalNode(clause.Node)
+			if err != nil {
+				return nil, fmt.Errorf("clauses[%d]: %w", i, err)
+			}
+
+			clauses[i] = &BooleanClause{Type: matchType, Node: node}
+		}
+
+		return &BooleanNode{Clauses: clauses}, nil
+
+	case "boost":
+		var value jsonBoostNode
+		if err := decodeStrict(data, &value); err != nil {
+			return nil, err
+		}
+
+		boostNode := &BoostNode{Boost: value.Boost}
+		if err := boostNode.validate(); err != nil {
+			return nil, err
+		}
+
+		node, err := unmarshalNode(value.Node)
+		if err != nil {
+			return nil, err
+		}
+
+		boostNode.Node = node
+
+		return boostNode, nil
+
+	case "match":
+		var value jsonMatchNode
+		if err := decodeStrict(data, &value); err != nil {
+			return nil, err
+		}
+
+		if value.Field == "" {
+			return nil, errors.New("missing field")
+		}
+
+		operator := Should
+		if value.Operator != "" {
+			var err error
+			operator, err = unmarshalMatchType(value.Operator)
+			if err != nil {
+				return nil, err
+			}
+
+			if operator == MustNot {
+				return nil, fmt.Errorf("invalid operator %q", value.Operator)
+			}
+		}
+
+		return &MatchNode{FieldName: value.Field, Text: value.Text, Operator: operator}, nil
+
+	case "matchPhrase":
+		var value jsonMatchPhraseNode
+		if err := decodeStrict(data, &value); err != nil {
+			return nil, err
+		}
+
+		if value.Field == "" {
+			return nil, errors.New("missing field")
+		}
+
+		if value.Slop < 0 {
+			return nil, fmt.Errorf("negative slop %d", value.Slop)
+		}
+
+		return &MatchPhraseNode{FieldName: value.Field, Text: value.Text, Slop: value.Slop}, nil
+
+	case "phrase":
+		var value jsonPhraseNode
+		if err := decodeStrict(data, &value); err != nil {
+			return nil, err
+		}
+
+		if value.Field == "" {
+			return nil, errors.New("missing field")
+		}
+
+		if value.Slop < 0 {
+			return nil, fmt.Errorf("negative slop %d", value.Slop)
+		}
+
+		var terms [][]byte
+
+		switch {
+		case value.Terms != nil && value.TermsBytes != nil:
+			return nil, errors.New("both terms and termsBytes")
+		case value.Terms != nil:
+			terms = make([][]byte, len(value.Terms))
+			for i, term := range value.Terms {
+				terms[i] = []byte(term)
+			}
+		case value.TermsBytes != nil:
+			terms = value.TermsBytes
+		}
+
+		if len(terms) == 0 {
+			return nil, errors.New("missing terms")
+		}
+
+		return &PhraseNode{FieldName: value.Field, Terms: terms, Slop: value.Slop}, nil
+
+	case "prefix":
+		var value jsonPrefixNode
+		if err := decodeStrict(data, &value); err != nil {
+			return nil, err
+		}
+
+		if value.Field == "" {
+			return nil, errors.New("missing field")
+		}
+
+		prefix, err := unmarshalTerm("prefix", value.Prefix, value.PrefixBytes)
+		if err != nil {
+			return nil, err
+		}
+
+		return &PrefixNode{FieldName: value.Field, Prefix: prefix}, nil
+
+	case "range":
+		var value jsonRangeNode
+		if err := decodeStrict(data, &value); err != nil {
+			return nil, err
+		}
+
+		if value.Field == "" {
+			return nil, errors.New("missing field")
+		}
+
+		rangeNode := &RangeNode{
+			FieldName:    value.Field,
+			Lower:        value.LowerBytes,
+			Upper:        value.UpperBytes,
+			IncludeLower: value.IncludeLower,
+			IncludeUpper: value.IncludeUpper,
+		}
+
+		if _, _, _, err := rangeNode.bounds(); err != nil {
+			return nil, err
+		}
+
+		return rangeNode, nil
+
+	case "term":
+		var value jsonTermNode
+		if err := decodeStrict(data, &value); err != nil {
+			return nil, err
+		}
+
+		if value.Field == "" {
+			return nil, errors.New("missing field")
+		}
+
+		term, err := unmarshalTerm("term", value.Term, value.TermBytes)
+		if err != nil {
+			return nil, err
+		}
+
+		return &TermNode{FieldName: value.Field, Term: term}, nil
+
+	default:
+		return nil, errors.New("unknown node type")
+	}
+}
+
+// Decodes an object, and fails on the fields value doesn't have
+func decodeStrict(data json.RawMessage, value any) error {
+	if bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+		return errors.New("missing fields")
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	return decoder.Decode(value)
+}
+
+func unmarshalMatchType(matchType string) (MatchType, error) {
+	switch matchType {
+	case "should":
+		return Should, nil
+	case "must":
+		return Must, nil
+	case "mustNot":
+		return MustNot, nil
+	case "":
+		return 0, errors.New("missing type")
+	default:
+		return 0, fmt.Errorf("unknown type %q", matchType)
+	}
+}
+
+func unmarshalTerm(name string, term *string, termBytes []byte) ([]byte, error) {
+	switch {
+	case term != nil && termBytes != nil:
+		return nil, fmt.Errorf("both %s and %sBytes", name, name)
+	case term != nil:
+		return []byte(*term), nil
+	case termBytes != nil:
+		return termBytes, nil
+	default:
+		return nil, fmt.Errorf("missing %s", name)
+	}
+}
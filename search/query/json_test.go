@@ -0,0 +1,98 @@
+package query
+
+import (
+	"testing"
+
+	"github.com/larose/lynx/search/index"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONRoundTrip(t *testing.T) {
+	nodes := []Node{
+		term("body", "hello"),
+		&TermNode{FieldName: "id", Term: []byte{0, 0, 0xff, 1}},
+		&TermNode{FieldName: "body", Term: []byte{}},
+		&BooleanNode{Clauses: []*BooleanClause{
+			clause(Must, term("body", "hello")),
+			clause(MustNot, &PhraseNode{FieldName: "title", Terms: [][]byte{[]byte("hello"), []byte("world")}, Slop: 1}),
+			clause(Should, &BooleanNode{Clauses: []*BooleanClause{
+				clause(Should, &PrefixNode{FieldName: "body", Prefix: []byte("wor")}),
+				clause(Should, &MatchNode{FieldName: "body", Text: "Hello World", Operator: Must}),
+				clause(Should, &MatchPhraseNode{FieldName: "body", Text: "big world", Slop: 2}),
+			}}),
+			clause(Should, &BoostNode{Node: term("title", "hello"), Boost: 2.5}),
+		}},
+		&BooleanNode{Clauses: []*BooleanClause{}},
+		&PhraseNode{FieldName: "id", Terms: [][]byte{{0xff}, []byte("a")}},
+		&PrefixNode{FieldName: "id", Prefix: []byte{0xfe}},
+		&RangeNode{FieldName: "price", Lower: index.EncodeInt64(-5), Upper: index.EncodeInt64(10), IncludeLower: true},
+		&RangeNode{FieldName: "date", Upper: index.EncodeInt64(0), IncludeUpper: true},
+	}
+
+	for _, node := range nodes {
+		data, err := MarshalJSON(node)
+		if !assert.NoError(t, err) {
+			continue
+		}
+
+		decoded, err := UnmarshalJSON(data)
+		if assert.NoError(t, err, string(data)) {
+			assert.Equal(t, node, decoded, string(data))
+		}
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	data, err := MarshalJSON(&BooleanNode{Clauses: []*BooleanClause{
+		clause(MustNot, term("body", "hello")),
+		clause(Should, &TermNode{FieldName: "id", Term: []byte{0xff}}),
+	}})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"boolean": {"clauses": [
+		{"type": "mustNot", "node": {"term": {"field": "body", "term": "hello"}}},
+		{"type": "should", "node": {"term": {"field": "id", "termBytes": "/w=="}}}
+	]}}`, string(data))
+
+	_, err = MarshalJSON(&BooleanNode{Clauses: []*BooleanClause{clause(Should, nil)}})
+	assert.EqualError(t, err, "boolean clause 0: nil node")
+
+	_, err = MarshalJSON(&BooleanNode{Clauses: []*BooleanClause{clause(Should, term("body", "hello")), nil}})
+	assert.EqualError(t, err, "boolean clause 1: nil node")
+
+	_, err = MarshalJSON(&BooleanNode{Clauses: []*BooleanClause{clause(MatchType(7), term("body", "hello"))}})
+	assert.EqualError(t, err, "unknown match type 7")
+}
+
+func TestUnmarshalJSONErrors(t *testing.T) {
+	tests := []struct {
+		input   string
+		message string
+	}{
+		{`null`, "missing node"},
+		{`{}`, "node must have exactly one type, got 0"},
+		{`{"term": {"field": "body", "term": "a"}, "prefix": {"field": "body", "prefix": "a"}}`, "node must have exactly one type, got 2"},
+		{`{"wildcard": {"field": "body"}}`, "wildcard: unknown node type"},
+		{`{"term": {"field": "body", "term": "a", "boost": 2}}`, `term: json: unknown field "boost"`},
+		{`{"term": {"field": "body"}}`, "term: missing term"},
+		{`{"term": {"term": "a"}}`, "term: missing field"},
+		{`{"term": {"field": "body", "term": "a", "termBytes": "YQ=="}}`, "term: both term and termBytes"},
+		{`{"term": null}`, "term: missing fields"},
+		{`{"term": {"field": "body", "term": "a"}} {}`, "invalid character '{' after top-level value"},
+		{`{"boolean": {"clauses": [{"type": "must", "node": {"term": {"field": "body", "term": "a"}}}, {"type": "maybe", "node": {"term": {"field": "body", "term": "b"}}}]}}`, `boolean: clauses[1]: unknown type "maybe"`},
+		{`{"boolean": {"clauses": [{"node": {"term": {"field": "body", "term": "a"}}}]}}`, "boolean: clauses[0]: missing type"},
+		{`{"boolean": {"clauses": [{"type": "must"}]}}`, "boolean: clauses[0]: missing node"},
+		{`{"boolean": {"clauses": [{"type": "must", "node": {"term": {"field": "body", "text": "a"}}}]}}`, `boolean: clauses[0]: term: json: unknown field "text"`},
+		{`{"boost": {"node": {"term": {"field": "body", "term": "a"}}}}`, "boost: boost must be positive and finite: 0"},
+		{`{"boost": {"boost": 2}}`, "boost: missing node"},
+		{`{"range": {"field": "price", "lowerBytes": "AQ=="}}`, "range: range of field price: bound of 1 bytes, expected 8"},
+		{`{"phrase": {"field": "body", "terms": []}}`, "phrase: missing terms"},
+		{`{"phrase": {"field": "body", "terms": ["a"], "slop": -1}}`, "phrase: negative slop -1"},
+		{`{"match": {"field": "body", "text": "a", "operator": "mustNot"}}`, `match: invalid operator "mustNot"`},
+		{`{"matchPhrase": {"field": "body", "text": 1}}`, "matchPhrase: json: cannot unmarshal number into Go struct field jsonMatchPhraseNode.text of type string"},
+	}
+
+	for _, test := range tests {
+		_, err := UnmarshalJSON([]byte(test.input))
+		assert.EqualError(t, err, test.message, test.input)
+	}
+}
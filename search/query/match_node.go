@@ -0,0 +1,108 @@
+package query
+
+import (
+	"github.com/larose/lynx/search/index"
+)
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// MatchNode
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+// MatchNode analyzes Text with the analyzer of the field and matches the
+// documents with its terms. Operator is Should to match any of the terms or
+// Must to match all of them.
+type MatchNode struct {
+	FieldName string
+	Text      string
+	Operator  MatchType
+}
+
+func (m *MatchNode) toBooleanNode(context *QueryContext) (*BooleanNode, error) {
+	analyzer, err := context.Analyzer(m.FieldName)
+	if err != nil {
+		return nil, err
+	}
+
+	terms := index.Analyze(analyzer, []byte(m.Text))
+
+	clauses := make([]*BooleanClause, len(terms))
+	for i, term := range terms {
+		clauses[i] = &BooleanClause{
+			Type: m.Operator,
+			Node: &TermNode{FieldName: m.FieldName, Term: term},
+		}
+	}
+
+	return &BooleanNode{Clauses: clauses}, nil
+}
+
+func (m *MatchNode) CreateRootNode(context *QueryContext) (RootNode, error) {
+	booleanNode, err := m.toBooleanNode(context)
+	if err != nil {
+		return nil, err
+	}
+
+	return booleanNode.CreateRootNode(context)
+}
+
+func (m *MatchNode) CreateChildNode(context *QueryContext) (ChildNode, error) {
+	booleanNode, err := m.toBooleanNode(context)
+	if err != nil {
+		return nil, err
+	}
+
+	return booleanNode.CreateChildNode(context)
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// MatchPhraseNode
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+// MatchPhraseNode analyzes Text with the analyzer of the field and matches the
+// documents where its terms occur in order. See PhraseNode for Slop.
+type MatchPhraseNode struct {
+	FieldName string
+	Text      string
+	Slop      int
+}
+
+// Returns nil if Text has no terms
+func (m *MatchPhraseNode) toPhraseNode(context *QueryContext) (*PhraseNode, error) {
+	analyzer, err := context.Analyzer(m.FieldName)
+	if err != nil {
+		return nil, err
+	}
+
+	terms := index.Analyze(analyzer, []byte(m.Text))
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	return &PhraseNode{FieldName: m.FieldName, Terms: terms, Slop: m.Slop}, nil
+}
+
+func (m *MatchPhraseNode) CreateRootNode(context *QueryContext) (RootNode, error) {
+	phraseNode, err := m.toPhraseNode(context)
+	if err != nil {
+		return nil, err
+	}
+
+	if phraseNode == nil {
+		return &EmptyRootNode{}, nil
+	}
+
+	return phraseNode.CreateRootNode(context)
+}
+
+func (m *MatchPhraseNode) CreateChildNode(context *QueryContext) (ChildNode, error) {
+	phraseNode, err := m.toPhraseNode(context)
+	if err != nil {
+		return nil, err
+	}
+
+	if phraseNode == nil {
+		return &EmptyChildNode{}, nil
+	}
+
+	return phraseNode.CreateChildNode(context)
+}
@@ -68,3 +68,64 @@ func (e *EmptyChildDocIterator) Score(documentContext *index.FieldLengthNorms) f
 func (e *EmptyChildDocIterator) UpperBound() float32 {
 	return 0
 }
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// Root doc iterator over a single child doc iterator
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+type RootChildDocIterator struct {
+	child ChildDocIterator
+	docId index.DocumentId
+}
+
+func newRootChildDocIterator(child ChildDocIterator) *RootChildDocIterator {
+	return &RootChildDocIterator{child: child}
+}
+
+func (it *RootChildDocIterator) Next(fieldLengthNorms *index.FieldLengthNorms, lowerBound float32) (index.DocumentId, float32, bool) {
+	for {
+		if !it.child.NextShallow(it.docId) {
+			return 0, 0, false
+		}
+
+		if it.child.BlockUpperBound() < lowerBound {
+			it.docId = it.child.BlockMaxDocId() + 1
+			continue
+		}
+
+		if !it.child.Next(it.docId) {
+			return 0, 0, false
+		}
+
+		docId := it.child.DocId()
+		it.docId = docId + 1
+
+		fieldLengthNorms.SetDocId(docId)
+
+		return docId, it.child.Score(fieldLengthNorms), true
+	}
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// Empty root node
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+// EmptyRootNode matches no documents.
+type EmptyRootNode struct {
+}
+
+func (e *EmptyRootNode) CreateRootDocIterator(context *ExecutionContext, segmentIndex int) RootDocIterator {
+	return nil
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// Empty child node
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+// EmptyChildNode matches no documents.
+type EmptyChildNode struct {
+}
+
+func (e *EmptyChildNode) CreateChildDocIterator(context *ExecutionContext, segmentIndex int) ChildDocIterator {
+	return nil
+}
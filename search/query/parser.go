@@ -0,0 +1,580 @@
+package query
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/larose/lynx/search/index"
+)
+
+// Query syntax
+//
+//   - term: matches the documents with the terms of the analyzed text
+//   - field:term: searches field instead of the default field
+//   - "some phrase": matches the terms in order, "some phrase"~2 with a slop
+//   - prefix*: matches the terms starting with prefix, lowercased but not
+//     analyzed
+//   - a AND b, a OR b, NOT a: AND binds tighter than OR, and clauses without
+//     operator are optional, as with OR
+//   - +a, -a: a is required, or excluded
+//   - (a b): groups clauses, field:(a b) searches field for all of them
+//   - a^2: multiplies the score of a
+//
+// Special characters are escaped with a backslash: \( \) \: \^ \~ \" \* \\,
+// and \+ \- at the start of a term.
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// ParseError
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+// ParseError is returned by Parse for an invalid query. Position is the byte
+// offset of the error in the input.
+type ParseError struct {
+	Position int
+	Message  string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("position %d: %s", e.Position, e.Message)
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// Parse
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+// Parse parses a query entered by a user. Terms without a field search
+// defaultField. The text of terms and phrases is analyzed with analyzer, or
+// with the analyzer of the field in the schema at search time when analyzer
+// is nil.
+//
+// Optional and excluded clauses without terms after analysis, e.g. stop words,
+// are dropped. Required clauses, the operands of AND included, and queries
+// without terms are errors. When analyzer is nil, they match no document
+// instead.
+func Parse(input string, defaultField string, analyzer index.Analyzer) (Node, error) {
+	tokens, err := tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{
+		analyzer: analyzer,
+		tokens:   tokens,
+	}
+
+	if p.peek().kind == tokenEOF {
+		return nil, &ParseError{Position: 0, Message: "empty query"}
+	}
+
+	node, err := p.parseClauses(defaultField)
+	if err != nil {
+		return nil, err
+	}
+
+	if token := p.peek(); token.kind != tokenEOF {
+		return nil, p.unexpected(token)
+	}
+
+	if node == nil {
+		return nil, &ParseError{Position: 0, Message: "no terms after analysis"}
+	}
+
+	return node, nil
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// Tokenizer
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+type tokenKind byte
+
+const (
+	tokenEOF tokenKind = iota
+	tokenTerm
+	tokenPrefix
+	tokenPhrase
+	tokenAnd
+	tokenOr
+	tokenNot
+	tokenPlus
+	tokenMinus
+	tokenLeftParen
+	tokenRightParen
+	tokenColon
+	tokenCaret
+	tokenTilde
+)
+
+type token struct {
+	kind tokenKind
+	// Unescaped text of terms, prefixes (without the *) and phrases
+	text string
+	// Byte offset in the input
+	position int
+}
+
+var singleCharTokens = map[rune]tokenKind{
+	'(': tokenLeftParen,
+	')': tokenRightParen,
+	':': tokenColon,
+	'^': tokenCaret,
+	'~': tokenTilde,
+	'+': tokenPlus,
+	'-': tokenMinus,
+}
+
+func isSpecial(r rune) bool {
+	return strings.ContainsRune(`()":^~\`, r) || unicode.IsSpace(r)
+}
+
+func tokenize(input string) ([]token, error) {
+	tokens := make([]token, 0)
+
+	for position := 0; position < len(input); {
+		r, size := utf8.DecodeRuneInString(input[position:])
+
+		if unicode.IsSpace(r) {
+			position += size
+			continue
+		}
+
+		if kind, ok := singleCharTokens[r]; ok {
+			tokens = append(tokens, token{kind: kind, text: string(r), position: position})
+			position += size
+			continue
+		}
+
+		if r == '"' {
+			text, end, err := scanPhrase(input, position)
+			if err != nil {
+				return nil, err
+			}
+
+			tokens = append(tokens, token{kind: tokenPhrase, text: text, position: position})
+			position = end
+			continue
+		}
+
+		term, end, err := scanTerm(input, position)
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, term)
+		position = end
+	}
+
+	return append(tokens, token{kind: tokenEOF, position: len(input)}), nil
+}
+
+// Returns the unescaped text of the phrase starting at the quote at start, and
+// the position after the closing quote
+func scanPhrase(input string, start int) (string, int, error) {
+	var text strings.Builder
+
+	for position := start + 1; position < len(input); position++ {
+		switch input[position] {
+		case '"':
+			return text.String(), position + 1, nil
+		case '\\':
+			if position+1 == len(input) {
+				return "", 0, &ParseError{Position: position, Message: "escape character at end of query"}
+			}
+			position++
+		}
+
+		text.WriteByte(input[position])
+	}
+
+	return "", 0, &ParseError{Position: start, Message: "unterminated phrase"}
+}
+
+// Returns the term, prefix or keyword starting at start, and the position
+// after it
+func scanTerm(input string, start int) (token, int, error) {
+	var text strings.Builder
+
+	escaped := false
+	prefix := false
+
+	position := start
+	for position < len(input) {
+		r, size := utf8.DecodeRuneInString(input[position:])
+
+		if r == '\\' {
+			if position+size == len(input) {
+				return token{}, 0, &ParseError{Position: position, Message: "escape character at end of query"}
+			}
+
+			escaped = true
+			r, size = utf8.DecodeRuneInString(input[position+1:])
+			text.WriteRune(r)
+			position += 1 + size
+			continue
+		}
+
+		if isSpecial(r) {
+			break
+		}
+
+		if r == '*' {
+			next, _ := utf8.DecodeRuneInString(input[position+size:])
+			if position+size < len(input) && !isSpecial(next) {
+				return token{}, 0, &ParseError{Position: position, Message: "wildcards are only supported at the end of a term"}
+			}
+
+			if text.Len() == 0 {
+				return token{}, 0, &ParseError{Position: position, Message: "empty prefix"}
+			}
+
+			prefix = true
+			position += size
+			break
+		}
+
+		text.WriteRune(r)
+		position += size
+	}
+
+	if prefix {
+		return token{kind: tokenPrefix, text: text.String(), position: start}, position, nil
+	}
+
+	kind := tokenTerm
+
+	if !escaped {
+		switch text.String() {
+		case "AND":
+			kind = tokenAnd
+		case "OR":
+			kind = tokenOr
+		case "NOT":
+			kind = tokenNot
+		}
+	}
+
+	return token{kind: kind, text: text.String(), position: start}, position, nil
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// Parser
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+type parser struct {
+	analyzer index.Analyzer
+	tokens   []token
+	next     int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *parser) consume() token {
+	token := p.tokens[p.next]
+	if token.kind != tokenEOF {
+		p.next++
+	}
+
+	return token
+}
+
+func (p *parser) unexpected(token token) *ParseError {
+	switch token.kind {
+	case tokenEOF:
+		return &ParseError{Position: token.position, Message: "unexpected end of query"}
+	case tokenPhrase:
+		return &ParseError{Position: token.position, Message: "unexpected phrase"}
+	default:
+		return &ParseError{Position: token.position, Message: fmt.Sprintf("unexpected %q", token.text)}
+	}
+}
+
+// clauses := and (["OR"] and)*
+//
+// Returns nil when no clause has terms after analysis
+func (p *parser) parseClauses(field string) (Node, error) {
+	clauses := make([]*BooleanClause, 0)
+	first := true
+
+	for {
+		token := p.peek()
+		if token.kind == tokenEOF || token.kind == tokenRightParen {
+			break
+		}
+
+		if token.kind == tokenOr && !first {
+			p.consume()
+		}
+
+		andClauses, err := p.parseAnd(field)
+		if err != nil {
+			return nil, err
+		}
+
+		clauses = append(clauses, andClauses...)
+		first = false
+	}
+
+	if len(clauses) == 0 {
+		return nil, nil
+	}
+
+	if len(clauses) == 1 && clauses[0].Type != MustNot {
+		return clauses[0].Node, nil
+	}
+
+	return &BooleanNode{Clauses: clauses}, nil
+}
+
+// and := unary ("AND" unary)*
+//
+// Returns no clause when the text of the terms has no terms after analysis.
+// The operands of AND are required, unless excluded, and must have terms.
+func (p *parser) parseAnd(field string) ([]*BooleanClause, error) {
+	clause, required, err := p.parseAndOperand(field)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.peek().kind != tokenAnd {
+		if clause == nil {
+			return nil, nil
+		}
+
+		return []*BooleanClause{clause}, nil
+	}
+
+	if required != nil {
+		return nil, required
+	}
+
+	clauses := make([]*BooleanClause, 0)
+	if clause != nil {
+		clauses = append(clauses, clause)
+	}
+
+	for p.peek().kind == tokenAnd {
+		p.consume()
+
+		clause, required, err := p.parseAndOperand(field)
+		if err != nil {
+			return nil, err
+		}
+
+		if required != nil {
+			return nil, required
+		}
+
+		if clause != nil {
+			clauses = append(clauses, clause)
+		}
+	}
+
+	for _, clause := range clauses {
+		if clause.Type == Should {
+			clause.Type = Must
+		}
+	}
+
+	switch len(clauses) {
+	case 0:
+		return nil, nil
+	case 1:
+		return clauses, nil
+	default:
+		return []*BooleanClause{{Type: Should, Node: &BooleanNode{Clauses: clauses}}}, nil
+	}
+}
+
+// Parses a unary. When it has no terms after analysis and is not excluded,
+// also returns the error to report if it is an operand of AND.
+func (p *parser) parseAndOperand(field string) (*BooleanClause, *ParseError, error) {
+	token := p.peek()
+
+	clause, err := p.parseUnary(field)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if clause == nil && token.kind != tokenMinus && token.kind != tokenNot {
+		return nil, errNoTermsInRequiredClause(token.position), nil
+	}
+
+	return clause, nil, nil
+}
+
+func errNoTermsInRequiredClause(position int) *ParseError {
+	return &ParseError{Position: position, Message: "no terms after analysis in required clause"}
+}
+
+// unary := ["+" | "-" | "NOT"] primary ["^" boost]
+//
+// Returns nil when the clause is optional or excluded and has no terms after
+// analysis
+func (p *parser) parseUnary(field string) (*BooleanClause, error) {
+	position := p.peek().position
+	matchType := Should
+
+	switch p.peek().kind {
+	case tokenPlus:
+		p.consume()
+		matchType = Must
+	case tokenMinus, tokenNot:
+		p.consume()
+		matchType = MustNot
+	}
+
+	node, err := p.parsePrimary(field, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.peek().kind == tokenCaret {
+		p.consume()
+
+		value, err := p.parseNumber("boost")
+		if err != nil {
+			return nil, err
+		}
+
+		boost, err := strconv.ParseFloat(value.text, 32)
+		if err != nil || !(boost > 0) || math.IsInf(boost, 1) {
+			return nil, &ParseError{Position: value.position, Message: fmt.Sprintf("invalid boost %q", value.text)}
+		}
+
+		if node != nil {
+			node = &BoostNode{Node: node, Boost: float32(boost)}
+		}
+	}
+
+	if node == nil {
+		if matchType == Must {
+			return nil, errNoTermsInRequiredClause(position)
+		}
+
+		return nil, nil
+	}
+
+	return &BooleanClause{Type: matchType, Node: node}, nil
+}
+
+// primary := "(" clauses ")" | field ":" primary | term | prefix | phrase ["~" slop]
+//
+// Returns nil when the text has no terms after analysis
+func (p *parser) parsePrimary(field string, allowField bool) (Node, error) {
+	token := p.consume()
+
+	switch token.kind {
+	case tokenLeftParen:
+		node, err := p.parseClauses(field)
+		if err != nil {
+			return nil, err
+		}
+
+		if p.peek().kind != tokenRightParen {
+			return nil, &ParseError{Position: token.position, Message: "missing closing parenthesis"}
+		}
+
+		p.consume()
+
+		return node, nil
+
+	case tokenTerm:
+		if p.peek().kind == tokenColon {
+			colon := p.consume()
+
+			if !allowField {
+				return nil, &ParseError{Position: colon.position, Message: fmt.Sprintf("unexpected %q", colon.text)}
+			}
+
+			if next := p.peek(); next.kind == tokenEOF || next.kind == tokenRightParen {
+				return nil, &ParseError{Position: colon.position, Message: fmt.Sprintf("missing query for field %s", token.text)}
+			}
+
+			return p.parsePrimary(token.text, false)
+		}
+
+		return p.termNode(field, token.text), nil
+
+	case tokenPrefix:
+		return &PrefixNode{FieldName: field, Prefix: bytes.ToLower([]byte(token.text))}, nil
+
+	case tokenPhrase:
+		slop := 0
+
+		if p.peek().kind == tokenTilde {
+			p.consume()
+
+			value, err := p.parseNumber("slop")
+			if err != nil {
+				return nil, err
+			}
+
+			_slop, err := strconv.ParseUint(value.text, 10, 31)
+			if err != nil {
+				return nil, &ParseError{Position: value.position, Message: fmt.Sprintf("invalid slop %q", value.text)}
+			}
+
+			slop = int(_slop)
+		}
+
+		return p.phraseNode(field, token.text, slop), nil
+
+	default:
+		return nil, p.unexpected(token)
+	}
+}
+
+func (p *parser) parseNumber(name string) (token, error) {
+	token := p.consume()
+	if token.kind != tokenTerm {
+		return token, &ParseError{Position: token.position, Message: fmt.Sprintf("missing %s", name)}
+	}
+
+	return token, nil
+}
+
+func (p *parser) termNode(field, text string) Node {
+	if p.analyzer == nil {
+		return &MatchNode{FieldName: field, Text: text, Operator: Should}
+	}
+
+	terms := index.Analyze(p.analyzer, []byte(text))
+
+	switch len(terms) {
+	case 0:
+		return nil
+	case 1:
+		return &TermNode{FieldName: field, Term: terms[0]}
+	}
+
+	clauses := make([]*BooleanClause, len(terms))
+	for i, term := range terms {
+		clauses[i] = &BooleanClause{Type: Should, Node: &TermNode{FieldName: field, Term: term}}
+	}
+
+	return &BooleanNode{Clauses: clauses}
+}
+
+func (p *parser) phraseNode(field, text string, slop int) Node {
+	if p.analyzer == nil {
+		return &MatchPhraseNode{FieldName: field, Text: text, Slop: slop}
+	}
+
+	terms := index.Analyze(p.analyzer, []byte(text))
+
+	switch len(terms) {
+	case 0:
+		return nil
+	case 1:
+		return &TermNode{FieldName: field, Term: terms[0]}
+	}
+
+	return &PhraseNode{FieldName: field, Terms: terms, Slop: slop}
+}
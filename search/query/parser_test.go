@@ -0,0 +1,201 @@
+package query
+
+import (
+	"testing"
+
+	"github.com/larose/lynx/search/index"
+	"github.com/stretchr/testify/assert"
+)
+
+func term(fieldName, term string) *TermNode {
+	return &TermNode{FieldName: fieldName, Term: []byte(term)}
+}
+
+func clause(matchType MatchType, node Node) *BooleanClause {
+	return &BooleanClause{Type: matchType, Node: node}
+}
+
+func TestParse(t *testing.T) {
+	analyzer := index.NewStandardAnalyzer()
+
+	tests := []struct {
+		input    string
+		expected Node
+	}{
+		{"Hello", term("body", "hello")},
+		{"title:Hello", term("title", "hello")},
+		{"hello world", &BooleanNode{Clauses: []*BooleanClause{
+			clause(Should, term("body", "hello")),
+			clause(Should, term("body", "world")),
+		}}},
+		{"hello OR world", &BooleanNode{Clauses: []*BooleanClause{
+			clause(Should, term("body", "hello")),
+			clause(Should, term("body", "world")),
+		}}},
+		{"hello AND world", &BooleanNode{Clauses: []*BooleanClause{
+			clause(Must, term("body", "hello")),
+			clause(Must, term("body", "world")),
+		}}},
+		{"a OR b AND NOT c", &BooleanNode{Clauses: []*BooleanClause{
+			clause(Should, term("body", "a")),
+			clause(Should, &BooleanNode{Clauses: []*BooleanClause{
+				clause(Must, term("body", "b")),
+				clause(MustNot, term("body", "c")),
+			}}),
+		}}},
+		{"+hello -world lynx", &BooleanNode{Clauses: []*BooleanClause{
+			clause(Must, term("body", "hello")),
+			clause(MustNot, term("body", "world")),
+			clause(Should, term("body", "lynx")),
+		}}},
+		{"+hello", term("body", "hello")},
+		{"NOT hello", &BooleanNode{Clauses: []*BooleanClause{
+			clause(MustNot, term("body", "hello")),
+		}}},
+		{"title:(hello world) +(a OR b)", &BooleanNode{Clauses: []*BooleanClause{
+			clause(Should, &BooleanNode{Clauses: []*BooleanClause{
+				clause(Should, term("title", "hello")),
+				clause(Should, term("title", "world")),
+			}}),
+			clause(Must, &BooleanNode{Clauses: []*BooleanClause{
+				clause(Should, term("body", "a")),
+				clause(Should, term("body", "b")),
+			}}),
+		}}},
+		{`"Hello World"`, &PhraseNode{FieldName: "body", Terms: [][]byte{[]byte("hello"), []byte("world")}}},
+		{`title:"hello world"~2`, &PhraseNode{FieldName: "title", Terms: [][]byte{[]byte("hello"), []byte("world")}, Slop: 2}},
+		{`"hello"`, term("body", "hello")},
+		{"Hel*", &PrefixNode{FieldName: "body", Prefix: []byte("hel")}},
+		{"title:hel* world", &BooleanNode{Clauses: []*BooleanClause{
+			clause(Should, &PrefixNode{FieldName: "title", Prefix: []byte("hel")}),
+			clause(Should, term("body", "world")),
+		}}},
+		// Split by the analyzer
+		{"e-mail", &BooleanNode{Clauses: []*BooleanClause{
+			clause(Should, term("body", "e")),
+			clause(Should, term("body", "mail")),
+		}}},
+		// Escaped special characters and keywords
+		{`a\:b \AND \*`, &BooleanNode{Clauses: []*BooleanClause{
+			clause(Should, &BooleanNode{Clauses: []*BooleanClause{
+				clause(Should, term("body", "a")),
+				clause(Should, term("body", "b")),
+			}}),
+			clause(Should, term("body", "and")),
+		}}},
+		{"title:hello^2.5 body:hello", &BooleanNode{Clauses: []*BooleanClause{
+			clause(Should, &BoostNode{Node: term("title", "hello"), Boost: 2.5}),
+			clause(Should, term("body", "hello")),
+		}}},
+		{"+(a b)^3", &BoostNode{Node: &BooleanNode{Clauses: []*BooleanClause{
+			clause(Should, term("body", "a")),
+			clause(Should, term("body", "b")),
+		}}, Boost: 3}},
+		{`"hello world"~1^2`, &BoostNode{Node: &PhraseNode{FieldName: "body", Terms: [][]byte{[]byte("hello"), []byte("world")}, Slop: 1}, Boost: 2}},
+		// No terms after analysis
+		{"hello ...", term("body", "hello")},
+	}
+
+	for _, test := range tests {
+		node, err := Parse(test.input, "body", analyzer)
+		if assert.NoError(t, err, test.input) {
+			assert.Equal(t, test.expected, node, test.input)
+		}
+	}
+}
+
+func TestParseWithoutAnalyzer(t *testing.T) {
+	node, err := Parse(`title:Hello "big world"~1`, "body", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, &BooleanNode{Clauses: []*BooleanClause{
+		clause(Should, &MatchNode{FieldName: "title", Text: "Hello", Operator: Should}),
+		clause(Should, &MatchPhraseNode{FieldName: "body", Text: "big world", Slop: 1}),
+	}}, node)
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		position int
+		message  string
+	}{
+		{"", 0, "empty query"},
+		{"   ", 0, "empty query"},
+		{`hello "world`, 6, "unterminated phrase"},
+		{"(hello world", 0, "missing closing parenthesis"},
+		{"a (b (c)", 2, "missing closing parenthesis"},
+		{"hello)", 5, `unexpected ")"`},
+		{"AND hello", 0, `unexpected "AND"`},
+		{"hello OR", 8, "unexpected end of query"},
+		{"hello AND OR world", 10, `unexpected "OR"`},
+		{"title:", 5, "missing query for field title"},
+		{"(title:)", 6, "missing query for field title"},
+		{"a:b:c", 3, `unexpected ":"`},
+		{"he*llo", 2, "wildcards are only supported at the end of a term"},
+		{"hello *", 6, "empty prefix"},
+		{`hello\`, 5, "escape character at end of query"},
+		{`"hello"~x`, 8, `invalid slop "x"`},
+		{`"hello"~`, 8, "missing slop"},
+		{"hello^", 6, "missing boost"},
+		{"hello^x", 6, `invalid boost "x"`},
+		{"hello^-1", 6, "missing boost"},
+		{"hello^0", 6, `invalid boost "0"`},
+		{"...", 0, "no terms after analysis"},
+		{"hello +...", 6, "no terms after analysis in required clause"},
+	}
+
+	for _, test := range tests {
+		_, err := Parse(test.input, "body", index.NewStandardAnalyzer())
+
+		var parseError *ParseError
+		if assert.ErrorAs(t, err, &parseError, test.input) {
+			assert.Equal(t, test.position, parseError.Position, test.input)
+			assert.Equal(t, test.message, parseError.Message, test.input)
+		}
+	}
+}
+
+func TestParseStopWords(t *testing.T) {
+	analyzer := index.NewEnglishAnalyzer()
+
+	tests := []struct {
+		input    string
+		expected Node
+	}{
+		{"the cat", term("body", "cat")},
+		{"cat -the", term("body", "cat")},
+		{"cat (the a)", term("body", "cat")},
+		{"cat AND NOT the", term("body", "cat")},
+	}
+
+	for _, test := range tests {
+		node, err := Parse(test.input, "body", analyzer)
+		if assert.NoError(t, err, test.input) {
+			assert.Equal(t, test.expected, node, test.input)
+		}
+	}
+
+	errorTests := []struct {
+		input    string
+		position int
+		message  string
+	}{
+		{"the", 0, "no terms after analysis"},
+		{"the OR a", 0, "no terms after analysis"},
+		{"(the a)", 0, "no terms after analysis"},
+		{"+the cat", 0, "no terms after analysis in required clause"},
+		{`cat +"the a"`, 4, "no terms after analysis in required clause"},
+		{"the AND cat", 0, "no terms after analysis in required clause"},
+		{"cat AND title:the", 8, "no terms after analysis in required clause"},
+	}
+
+	for _, test := range errorTests {
+		_, err := Parse(test.input, "body", analyzer)
+
+		var parseError *ParseError
+		if assert.ErrorAs(t, err, &parseError, test.input) {
+			assert.Equal(t, test.position, parseError.Position, test.input)
+			assert.Equal(t, test.message, parseError.Message, test.input)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package query
+
+import (
+	"fmt"
+
+	"github.com/larose/lynx/search/index"
+)
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// Node
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+// PhraseNode matches documents where Terms occur in order in the field.
+type PhraseNode struct {
+	FieldName string
+	Terms     [][]byte
+	// Maximum number of extra positions allowed between the first and the
+	// last term of the phrase. 0 means the terms must be adjacent.
+	Slop int
+}
+
+func (p *PhraseNode) registerTerms(context *QueryContext) (int, []int, error) {
+	if len(p.Terms) == 0 {
+		return 0, nil, fmt.Errorf("phrase must have at least one term")
+	}
+
+	if p.Slop < 0 {
+		return 0, nil, fmt.Errorf("phrase slop must not be negative: %d", p.Slop)
+	}
+
+	fieldIndex := 0
+	termIndexes := make([]int, len(p.Terms))
+
+	for i, term := range p.Terms {
+		fieldIndex, termIndexes[i] = context.RegisterTerm(p.FieldName, term)
+	}
+
+	return fieldIndex, termIndexes, nil
+}
+
+func (p *PhraseNode) CreateRootNode(context *QueryContext) (RootNode, error) {
+	if len(p.Terms) == 1 {
+		return (&TermNode{FieldName: p.FieldName, Term: p.Terms[0]}).CreateRootNode(context)
+	}
+
+	childNode, err := p.CreateChildNode(context)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RootPhraseNode{childNode: childNode.(*ChildPhraseNode)}, nil
+}
+
+func (p *PhraseNode) CreateChildNode(context *QueryContext) (ChildNode, error) {
+	if len(p.Terms) == 1 {
+		return (&TermNode{FieldName: p.FieldName, Term: p.Terms[0]}).CreateChildNode(context)
+	}
+
+	// Segments written before the positions have none
+	for _, segmentReader := range context.SegmentReaders {
+		if segmentReader.Info.HasField(p.FieldName) && !segmentReader.Info.HasPositions(p.FieldName) {
+			return nil, fmt.Errorf("phrase on field %s: segment %d has no positions", p.FieldName, segmentReader.Id)
+		}
+	}
+
+	fieldIndex, termIndexes, err := p.registerTerms(context)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ChildPhraseNode{fieldIndex: fieldIndex, slop: p.Slop, termIndexes: termIndexes}, nil
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// RootPhraseNode
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+type RootPhraseNode struct {
+	childNode *ChildPhraseNode
+}
+
+func (p *RootPhraseNode) CreateRootDocIterator(context *ExecutionContext, segmentIndex int) RootDocIterator {
+	childDocIterator := p.childNode.CreateChildDocIterator(context, segmentIndex)
+	if childDocIterator == nil {
+		return nil
+	}
+
+	return newRootChildDocIterator(childDocIterator)
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// ChildPhraseNode
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+type ChildPhraseNode struct {
+	// field index in query context
+	fieldIndex int
+	slop       int
+	// term indexes in query context, in phrase order
+	termIndexes []int
+}
+
+func (p *ChildPhraseNode) CreateChildDocIterator(context *ExecutionContext, segmentIndex int) ChildDocIterator {
+	positionsIterators := make([]*index.TermPositionsIterator, len(p.termIndexes))
+	idf := float32(0)
+
+	for i, termIndex := range p.termIndexes {
+		termInfo := context.termInfos[segmentIndex][p.fieldIndex][termIndex]
+
+		if termInfo == nil {
+			return nil
+		}
+
+		freqsIterator := context.fieldFreqsReaders[segmentIndex][p.fieldIndex].TermFreqsIterator(termInfo)
+		positionsIterators[i] = context.fieldPositionsReaders[segmentIndex][p.fieldIndex].TermPositionsIterator(freqsIterator, termInfo)
+		idf += context.termIdfs[p.fieldIndex][termIndex]
+	}
+
+	return newChildPhraseDocIterator(p.fieldIndex, positionsIterators, p.slop, context.PrecomputedFieldNorms[p.fieldIndex], idf)
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// ChildPhraseDocIterator
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+type ChildPhraseDocIterator struct {
+	docId                       index.DocumentId
+	fieldIndex                  int
+	globalUpperBound            float32
+	phraseFreq                  uint64
+	positionsIterators          []*index.TermPositionsIterator
+	precomputedFieldLengthNorms []float32
+	slop                        int
+	// phraseIdf is the sum of the idf of the terms
+	phraseIdf float32
+
+	// Buffers used by computePhraseFreq, indexed by term
+	positions       [][]uint64
+	positionIndexes []int
+}
+
+func newChildPhraseDocIterator(fieldIndex int, positionsIterators []*index.TermPositionsIterator, slop int, precomputedFieldLengthNorms []float32, phraseIdf float32) *ChildPhraseDocIterator {
+	return &ChildPhraseDocIterator{
+		fieldIndex:                  fieldIndex,
+		globalUpperBound:            phraseIdf * (index.Bm25K1 + 1),
+		positionsIterators:          positionsIterators,
+		precomputedFieldLengthNorms: precomputedFieldLengthNorms,
+		slop:                        slop,
+		phraseIdf:                   phraseIdf,
+		positions:                   make([][]uint64, len(positionsIterators)),
+		positionIndexes:             make([]int, len(positionsIterators)),
+	}
+}
+
+func (p *ChildPhraseDocIterator) BlockMaxDocId() index.DocumentId {
+	blockMaxDocId := p.positionsIterators[0].LastDocId
+
+	for _, it := range p.positionsIterators[1:] {
+		blockMaxDocId = min(blockMaxDocId, it.LastDocId)
+	}
+
+	return blockMaxDocId
+}
+
+// The phrase freq is bounded by the smallest term freq and the field length of
+// a doc is at least the largest min field length of the blocks.
+func (p *ChildPhraseDocIterator) BlockUpperBound() float32 {
+	maxFreq, minLengthId := p.positionsIterators[0].BlockMaxFreqMinLengthId()
+
+	for _, it := range p.positionsIterators[1:] {
+		_maxFreq, _minLengthId := it.BlockMaxFreqMinLengthId()
+		maxFreq = min(maxFreq, _maxFreq)
+		minLengthId = max(minLengthId, _minLengthId)
+	}
+
+	return p.computeScoreForUpperBound(maxFreq, minLengthId)
+}
+
+func (p *ChildPhraseDocIterator) computeScoreForUpperBound(freq uint64, lengthId byte) float32 {
+	_freq := float32(freq)
+	lengthNorm := p.precomputedFieldLengthNorms[lengthId]
+	termFreqFactor := (_freq * (index.Bm25K1 + 1)) / (_freq + lengthNorm)
+	return p.phraseIdf * float32(termFreqFactor)
+}
+
+func (p *ChildPhraseDocIterator) DocId() index.DocumentId {
+	return p.docId
+}
+
+func (p *ChildPhraseDocIterator) GlobalUpperBound() float32 {
+	return p.globalUpperBound
+}
+
+func (p *ChildPhraseDocIterator) IDF() float32 {
+	return p.phraseIdf
+}
+
+func (p *ChildPhraseDocIterator) Next(docId index.DocumentId) bool {
+	candidateDocId := docId
+
+	for {
+		allAtCandidateDocId := true
+
+		for _, it := range p.positionsIterators {
+			if !it.Next(candidateDocId) {
+				return false
+			}
+
+			if it.DocId() != candidateDocId {
+				candidateDocId = it.DocId()
+				allAtCandidateDocId = false
+				break
+			}
+		}
+
+		if !allAtCandidateDocId {
+			continue
+		}
+
+		phraseFreq := p.computePhraseFreq()
+		if phraseFreq > 0 {
+			p.docId = candidateDocId
+			p.phraseFreq = phraseFreq
+			return true
+		}
+
+		candidateDocId++
+	}
+}
+
+// Counts the positions of the first term that start a match. For each of
+// them, the following terms are matched greedily to their next position,
+// which gives the shortest match starting at that position.
+func (p *ChildPhraseDocIterator) computePhraseFreq() uint64 {
+	positions := p.positions
+	for i, it := range p.positionsIterators {
+		positions[i] = it.Positions()
+		p.positionIndexes[i] = 0
+	}
+
+	maxLength := uint64(len(positions)-1) + uint64(p.slop)
+	phraseFreq := uint64(0)
+
+	for _, startPosition := range positions[0] {
+		previousPosition := startPosition
+
+		for i := 1; i < len(positions); i++ {
+			termPositions := positions[i]
+			j := p.positionIndexes[i]
+
+			for j < len(termPositions) && termPositions[j] <= previousPosition {
+				j++
+			}
+
+			p.positionIndexes[i] = j
+
+			if j == len(termPositions) {
+				// No more match for this start position or the next ones
+				return phraseFreq
+			}
+
+			previousPosition = termPositions[j]
+		}
+
+		if previousPosition-startPosition <= maxLength {
+			phraseFreq++
+		}
+	}
+
+	return phraseFreq
+}
+
+func (p *ChildPhraseDocIterator) NextShallow(docId index.DocumentId) bool {
+	for _, it := range p.positionsIterators {
+		if !it.NextShallow(docId) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (p *ChildPhraseDocIterator) Score(fieldLengthNorms *index.FieldLengthNorms) float32 {
+	phraseFreq := float32(p.phraseFreq)
+	lengthNorm := fieldLengthNorms.Get(p.fieldIndex)
+	termFreqFactor := (phraseFreq * (index.Bm25K1 + 1)) / (phraseFreq + lengthNorm)
+	return p.phraseIdf * float32(termFreqFactor)
+}
@@ -0,0 +1,81 @@
+package query
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+)
+
+// Maximum number of terms a PrefixNode expands to
+const MaxPrefixTerms = 1024
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// PrefixNode
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+// PrefixNode matches the documents with a term starting with Prefix. It
+// expands to the terms of the searched segments, and fails when there are
+// more than MaxPrefixTerms of them.
+type PrefixNode struct {
+	FieldName string
+	Prefix    []byte
+}
+
+func (p *PrefixNode) toBooleanNode(context *QueryContext) (*BooleanNode, error) {
+	terms := make([][]byte, 0)
+
+	for _, segmentReader := range context.SegmentReaders {
+		if !segmentReader.Info.HasField(p.FieldName) {
+			continue
+		}
+
+		dictionaryReader, err := segmentReader.DictionaryReader(p.FieldName)
+		if err != nil {
+			return nil, err
+		}
+
+		segmentTerms, ok := dictionaryReader.TermsWithPrefix(p.Prefix, MaxPrefixTerms)
+		if !ok {
+			return nil, fmt.Errorf("prefix %q of field %s matches more than %d terms", p.Prefix, p.FieldName, MaxPrefixTerms)
+		}
+
+		for _, term := range segmentTerms {
+			i, found := slices.BinarySearchFunc(terms, term, bytes.Compare)
+			if !found {
+				terms = slices.Insert(terms, i, bytes.Clone(term))
+			}
+		}
+
+		if len(terms) > MaxPrefixTerms {
+			return nil, fmt.Errorf("prefix %q of field %s matches more than %d terms", p.Prefix, p.FieldName, MaxPrefixTerms)
+		}
+	}
+
+	clauses := make([]*BooleanClause, len(terms))
+	for i, term := range terms {
+		clauses[i] = &BooleanClause{
+			Type: Should,
+			Node: &TermNode{FieldName: p.FieldName, Term: term},
+		}
+	}
+
+	return &BooleanNode{Clauses: clauses}, nil
+}
+
+func (p *PrefixNode) CreateRootNode(context *QueryContext) (RootNode, error) {
+	booleanNode, err := p.toBooleanNode(context)
+	if err != nil {
+		return nil, err
+	}
+
+	return booleanNode.CreateRootNode(context)
+}
+
+func (p *PrefixNode) CreateChildNode(context *QueryContext) (ChildNode, error) {
+	booleanNode, err := p.toBooleanNode(context)
+	if err != nil {
+		return nil, err
+	}
+
+	return booleanNode.CreateChildNode(context)
+}
@@ -2,6 +2,8 @@ package query
 
 import (
 	"bytes"
+
+	"github.com/larose/lynx/search/index"
 )
 
 type QueryField struct {
@@ -11,6 +13,19 @@ type QueryField struct {
 
 type QueryContext struct {
 	Fields []*QueryField
+	// Analyzers of the text fields. The standard analyzer is used when nil.
+	Schema *index.Schema
+	// Segments searched, used by the nodes that expand to the terms of the
+	// index
+	SegmentReaders []*index.SegmentReader
+}
+
+func (c *QueryContext) Analyzer(fieldName string) (index.Analyzer, error) {
+	if c.Schema == nil {
+		return index.NewStandardAnalyzer(), nil
+	}
+
+	return c.Schema.Analyzer(fieldName)
 }
 
 func (c *QueryContext) RegisterTerm(fieldName string, term []byte) (int, int) {
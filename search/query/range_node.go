@@ -0,0 +1,227 @@
+package query
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/RoaringBitmap/roaring/v2"
+	"github.com/larose/lynx/search/index"
+)
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// Node
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+// RangeNode matches the documents with a value of a numeric field between
+// Lower and Upper. The bounds are encoded like the values of the field, e.g.
+// by index.EncodeInt64, and a nil bound leaves the range open on its side.
+//
+// The documents score 0, so that the node filters the documents without
+// changing their score when it is a Must clause of a BooleanNode.
+type RangeNode struct {
+	FieldName    string
+	Lower        []byte
+	Upper        []byte
+	IncludeLower bool
+	IncludeUpper bool
+}
+
+// Returns the encoded values matched by the range, bounds included, or false
+// if it matches no values
+func (r *RangeNode) bounds() ([]byte, []byte, bool, error) {
+	for _, bound := range [][]byte{r.Lower, r.Upper} {
+		if bound != nil && len(bound) != 8 {
+			return nil, nil, false, fmt.Errorf("range of field %s: bound of %d bytes, expected 8", r.FieldName, len(bound))
+		}
+	}
+
+	min := uint64(0)
+	if r.Lower != nil {
+		min = binary.BigEndian.Uint64(r.Lower)
+
+		if !r.IncludeLower {
+			if min == math.MaxUint64 {
+				return nil, nil, false, nil
+			}
+
+			min++
+		}
+	}
+
+	max := uint64(math.MaxUint64)
+	if r.Upper != nil {
+		max = binary.BigEndian.Uint64(r.Upper)
+
+		if !r.IncludeUpper {
+			if max == 0 {
+				return nil, nil, false, nil
+			}
+
+			max--
+		}
+	}
+
+	if min > max {
+		return nil, nil, false, nil
+	}
+
+	return binary.BigEndian.AppendUint64(nil, min), binary.BigEndian.AppendUint64(nil, max), true, nil
+}
+
+// Returns the docs matching the range in each segment of the context
+func (r *RangeNode) docIds(context *QueryContext) ([]*roaring.Bitmap, error) {
+	lower, upper, ok, err := r.bounds()
+	if err != nil {
+		return nil, err
+	}
+
+	docIds := make([]*roaring.Bitmap, len(context.SegmentReaders))
+
+	for i, segmentReader := range context.SegmentReaders {
+		docIds[i] = roaring.NewBitmap()
+
+		if !ok || !segmentReader.Info.HasField(r.FieldName) {
+			continue
+		}
+
+		dictionaryReader, err := segmentReader.DictionaryReader(r.FieldName)
+		if err != nil {
+			return nil, err
+		}
+
+		fieldFreqsReader, err := segmentReader.FieldFreqsReader(r.FieldName)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, termRange := range index.NumericTermRanges(lower, upper) {
+			for _, termInfo := range dictionaryReader.TermInfosInRange(termRange.Lower, termRange.Upper) {
+				it := fieldFreqsReader.TermFreqsIterator(termInfo)
+
+				for docId := index.DocumentId(0); it.Next(docId); docId = it.DocId() + 1 {
+					docIds[i].Add(uint32(it.DocId()))
+				}
+			}
+		}
+	}
+
+	return docIds, nil
+}
+
+func (r *RangeNode) CreateRootNode(context *QueryContext) (RootNode, error) {
+	docIds, err := r.docIds(context)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RangeRootNode{docIds: docIds}, nil
+}
+
+func (r *RangeNode) CreateChildNode(context *QueryContext) (ChildNode, error) {
+	docIds, err := r.docIds(context)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RangeChildNode{docIds: docIds}, nil
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// RangeRootNode
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+type RangeRootNode struct {
+	// docIds[segmentIndex]
+	docIds []*roaring.Bitmap
+}
+
+func (r *RangeRootNode) CreateRootDocIterator(context *ExecutionContext, segmentIndex int) RootDocIterator {
+	if r.docIds[segmentIndex].IsEmpty() {
+		return nil
+	}
+
+	return newRootChildDocIterator(newRangeDocIterator(r.docIds[segmentIndex]))
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// RangeChildNode
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+type RangeChildNode struct {
+	// docIds[segmentIndex]
+	docIds []*roaring.Bitmap
+}
+
+func (r *RangeChildNode) CreateChildDocIterator(context *ExecutionContext, segmentIndex int) ChildDocIterator {
+	if r.docIds[segmentIndex].IsEmpty() {
+		return nil
+	}
+
+	return newRangeDocIterator(r.docIds[segmentIndex])
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// RangeDocIterator
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+// RangeDocIterator iterates over the docs of a bitmap, with a score of 0. The
+// whole bitmap is a single block.
+type RangeDocIterator struct {
+	docId     index.DocumentId
+	iterator  roaring.IntPeekable
+	lastDocId index.DocumentId
+	started   bool
+}
+
+func newRangeDocIterator(docIds *roaring.Bitmap) *RangeDocIterator {
+	return &RangeDocIterator{
+		iterator:  docIds.Iterator(),
+		lastDocId: index.DocumentId(docIds.Maximum()),
+	}
+}
+
+func (r *RangeDocIterator) BlockMaxDocId() index.DocumentId {
+	return r.lastDocId
+}
+
+func (r *RangeDocIterator) BlockUpperBound() float32 {
+	return 0
+}
+
+func (r *RangeDocIterator) DocId() index.DocumentId {
+	return r.docId
+}
+
+func (r *RangeDocIterator) GlobalUpperBound() float32 {
+	return 0
+}
+
+// The IDF only ranks the iterators by rarity, the larger the rarer
+func (r *RangeDocIterator) IDF() float32 {
+	return 0
+}
+
+func (r *RangeDocIterator) Next(docId index.DocumentId) bool {
+	if r.started && docId <= r.docId {
+		return true
+	}
+
+	r.iterator.AdvanceIfNeeded(uint32(docId))
+	if !r.iterator.HasNext() {
+		return false
+	}
+
+	r.docId = index.DocumentId(r.iterator.Next())
+	r.started = true
+
+	return true
+}
+
+func (r *RangeDocIterator) NextShallow(docId index.DocumentId) bool {
+	return docId <= r.lastDocId
+}
+
+func (r *RangeDocIterator) Score(fieldLengthNorms *index.FieldLengthNorms) float32 {
+	return 0
+}
@@ -0,0 +1,268 @@
+package query
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"math"
+
+	"github.com/larose/lynx/search/index"
+)
+
+type SortKind int
+
+const (
+	// The values of a field, read from its doc values
+	SortByField SortKind = iota
+	SortByScore
+	SortByDocId
+)
+
+// SortKey is a key of the order of a SortedCollector. Keys sort in increasing
+// order, unless Descending is set. Docs without a value for a field come
+// last, in both orders. Docs with several values of a sorted set field sort
+// by their smallest value in increasing order, and by their largest value in
+// decreasing order.
+type SortKey struct {
+	Kind SortKind
+	// Field of a SortByField key, with doc values in the schema of the index
+	FieldName  string
+	Descending bool
+}
+
+// sortedDoc is a collected doc with the values of its field keys
+type sortedDoc struct {
+	docScore DocScore
+	// values[i] is the value of key i, or nil if key i is not a field key or
+	// the doc has no value
+	values [][]byte
+}
+
+// SortedCollector keeps the first topN docs in the order of its keys, then of
+// their doc ids. The values of the fields are compared by value, not by
+// ordinal, so that docs of different segments compare correctly.
+type SortedCollector struct {
+	topN int
+	keys []SortKey
+	// Worst doc at the top
+	heap sortedHeap
+
+	// docValuesReaders[i] reads the values of key i in the current segment,
+	// nil if key i is not a field key or the segment has no doc values for it
+	docValuesReaders []*index.DocValuesReader
+	// Reused for each collected doc
+	candidate sortedDoc
+	numbers   [][8]byte
+	ords      []uint32
+}
+
+func NewSortedCollector(topN int, keys ...SortKey) *SortedCollector {
+	collector := &SortedCollector{
+		topN:             topN,
+		keys:             keys,
+		docValuesReaders: make([]*index.DocValuesReader, len(keys)),
+		candidate:        sortedDoc{values: make([][]byte, len(keys))},
+		numbers:          make([][8]byte, len(keys)),
+	}
+
+	collector.heap.keys = keys
+
+	return collector
+}
+
+func (c *SortedCollector) SetSegment(segmentReader *index.SegmentReader) error {
+	for i, key := range c.keys {
+		c.docValuesReaders[i] = nil
+
+		if key.Kind != SortByField || !segmentReader.Info.HasDocValues(key.FieldName) {
+			continue
+		}
+
+		docValuesReader, err := segmentReader.DocValuesReader(key.FieldName)
+		if err != nil {
+			return err
+		}
+
+		c.docValuesReaders[i] = docValuesReader
+	}
+
+	return nil
+}
+
+func (c *SortedCollector) Collect(docId uint64, score float32) {
+	localDocId := index.DocumentId(uint32(docId))
+
+	c.candidate.docScore = DocScore{DocId: docId, Score: score}
+	for i := range c.keys {
+		c.candidate.values[i] = c.value(i, localDocId)
+	}
+
+	if c.heap.Len() < c.topN {
+		heap.Push(&c.heap, c.candidate.clone())
+		return
+	}
+
+	if c.topN > 0 && c.heap.before(&c.candidate, c.heap.docs[0]) {
+		c.heap.docs[0] = c.candidate.clone()
+		heap.Fix(&c.heap, 0)
+	}
+}
+
+// Returns the value of key i for the doc of the current segment. The value is
+// only valid until the next call.
+func (c *SortedCollector) value(i int, docId index.DocumentId) []byte {
+	docValuesReader := c.docValuesReaders[i]
+	if docValuesReader == nil {
+		return nil
+	}
+
+	switch docValuesReader.Type {
+	case index.NumericDocValues:
+		number, ok := docValuesReader.Numeric(docId)
+		if !ok {
+			return nil
+		}
+
+		binary.BigEndian.PutUint64(c.numbers[i][:], number)
+		return c.numbers[i][:]
+
+	case index.SortedDocValues:
+		ord, ok := docValuesReader.Ord(docId)
+		if !ok {
+			return nil
+		}
+
+		return docValuesReader.Value(ord)
+
+	default:
+		c.ords = docValuesReader.Ords(docId, c.ords[:0])
+		if len(c.ords) == 0 {
+			return nil
+		}
+
+		if c.keys[i].Descending {
+			return docValuesReader.Value(c.ords[len(c.ords)-1])
+		}
+
+		return docValuesReader.Value(c.ords[0])
+	}
+}
+
+// Get returns the docs in the order of the keys
+func (c *SortedCollector) Get() []*DocScore {
+	results := make([]*DocScore, c.heap.Len())
+
+	for i := len(results) - 1; i >= 0; i-- {
+		results[i] = &heap.Pop(&c.heap).(*sortedDoc).docScore
+	}
+
+	return results
+}
+
+// LowerBound is the score of the worst doc when the docs are sorted by
+// decreasing score first, so that the docs with a lower score are skipped.
+// It is negative otherwise, as any doc may be collected.
+func (c *SortedCollector) LowerBound() float32 {
+	if c.heap.Len() < c.topN || len(c.keys) == 0 {
+		return float32(math.Inf(-1))
+	}
+
+	if key := c.keys[0]; key.Kind != SortByScore || !key.Descending {
+		return float32(math.Inf(-1))
+	}
+
+	return c.heap.docs[0].docScore.Score
+}
+
+// Copies the values, which point to the files of the segment or to the
+// buffers of the collector
+func (doc *sortedDoc) clone() *sortedDoc {
+	values := make([][]byte, len(doc.values))
+	for i, value := range doc.values {
+		if value != nil {
+			values[i] = bytes.Clone(value)
+		}
+	}
+
+	return &sortedDoc{docScore: doc.docScore, values: values}
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// sortedHeap
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+// sortedHeap is a heap of docs with the last doc in the order of the keys at
+// the top
+type sortedHeap struct {
+	docs []*sortedDoc
+	keys []SortKey
+}
+
+// Returns whether a comes before b in the order of the keys, then of the doc
+// ids
+func (h *sortedHeap) before(a, b *sortedDoc) bool {
+	for i, key := range h.keys {
+		comparison := 0
+
+		switch key.Kind {
+		case SortByField:
+			// Docs without a value come last, whatever the order
+			aValue, bValue := a.values[i], b.values[i]
+			if aValue == nil || bValue == nil {
+				if aValue != nil || bValue != nil {
+					return aValue != nil
+				}
+
+				continue
+			}
+
+			comparison = bytes.Compare(aValue, bValue)
+
+		case SortByScore:
+			if a.docScore.Score < b.docScore.Score {
+				comparison = -1
+			} else if a.docScore.Score > b.docScore.Score {
+				comparison = 1
+			}
+
+		case SortByDocId:
+			if a.docScore.DocId < b.docScore.DocId {
+				comparison = -1
+			} else if a.docScore.DocId > b.docScore.DocId {
+				comparison = 1
+			}
+		}
+
+		if key.Descending {
+			comparison = -comparison
+		}
+
+		if comparison != 0 {
+			return comparison < 0
+		}
+	}
+
+	return a.docScore.DocId < b.docScore.DocId
+}
+
+func (h *sortedHeap) Len() int { return len(h.docs) }
+
+func (h *sortedHeap) Less(i, j int) bool {
+	return h.before(h.docs[j], h.docs[i])
+}
+
+func (h *sortedHeap) Swap(i, j int) {
+	h.docs[i], h.docs[j] = h.docs[j], h.docs[i]
+}
+
+func (h *sortedHeap) Push(item any) {
+	h.docs = append(h.docs, item.(*sortedDoc))
+}
+
+func (h *sortedHeap) Pop() any {
+	old := h.docs
+	n := len(old)
+	x := old[n-1]
+	h.docs = old[0 : n-1]
+	return x
+}
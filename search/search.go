@@ -7,7 +7,9 @@ import (
 
 func Search(_query query.Node, indexReader *index.IndexReader, collector query.Collector) error {
 	queryContext := &query.QueryContext{
-		Fields: make([]*query.QueryField, 0, 10),
+		Fields:         make([]*query.QueryField, 0, 10),
+		Schema:         indexReader.Schema,
+		SegmentReaders: indexReader.SegmentReaders,
 	}
 
 	compiledQueryNode, err := _query.CreateRootNode(queryContext)
@@ -31,6 +33,12 @@ func Search(_query query.Node, indexReader *index.IndexReader, collector query.C
 			continue
 		}
 
+		if segmentCollector, ok := collector.(query.SegmentCollector); ok {
+			if err := segmentCollector.SetSegment(segmentReader); err != nil {
+				return err
+			}
+		}
+
 		for {
 			lowerBound := collector.LowerBound()
 			localDocId, score, exists := docIterator.Next(documentContext, lowerBound)
@@ -2,12 +2,19 @@ package search_test
 
 import (
 	"bufio"
+	"cmp"
 	"encoding/binary"
 	"fmt"
 	"log"
+	"maps"
+	"math/rand"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/larose/lynx/search"
 	"github.com/larose/lynx/search/index"
@@ -16,6 +23,19 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+// Analyzer of the case sensitive fields of the tests
+const caseSensitiveAnalyzerName = "case_sensitive"
+
+func init() {
+	caseSensitiveAnalyzer := &index.ChainAnalyzer{
+		NewTokenizer: func() index.TokenStream { return index.NewStandardTokenizer() },
+	}
+
+	if err := index.RegisterAnalyzer(caseSensitiveAnalyzerName, caseSensitiveAnalyzer); err != nil {
+		log.Fatal(err)
+	}
+}
+
 type Item struct {
 	id   uint32
 	text string
@@ -91,7 +111,12 @@ func initRandomParagraphsIndex() string {
 		log.Fatal(fmt.Errorf("Error creating batch iterator: %v\n", err))
 	}
 
-	indexWriter := index.NewIndexWriter(directory)
+	indexWriter, err := index.NewIndexWriter(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer indexWriter.Close()
 
 	for {
 		batch, ok := iterator()
@@ -115,7 +140,10 @@ func initRandomParagraphsIndex() string {
 }
 
 func initSimpleIndex() string {
-	directory := filepath.Join("testdata", "directory")
+	return initSimpleIndexAt(filepath.Join("testdata", "directory"))
+}
+
+func initSimpleIndexAt(directory string) string {
 	os.RemoveAll(directory)
 
 	err := os.MkdirAll(directory, 0700)
@@ -123,7 +151,12 @@ func initSimpleIndex() string {
 		log.Fatal(err)
 	}
 
-	indexWriter := index.NewIndexWriter(directory)
+	indexWriter, err := index.NewIndexWriter(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer indexWriter.Close()
 
 	{
 		docs := []index.Document{
@@ -324,7 +357,12 @@ func TestSearchTitleTwoDocuments(t *testing.T) {
 func TestSearchDeleteDocument(t *testing.T) {
 	directory := initSimpleIndex()
 
-	indexWriter := index.NewIndexWriter(directory)
+	indexWriter, err := index.NewIndexWriter(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer indexWriter.Close()
 
 	values := make([][]byte, 0, 1)
 	values = append(values, utils.Uint64ToBytes(89))
@@ -524,3 +562,2143 @@ func TestSearchManyDocuments(t *testing.T) {
 		assert.Equal(t, uint32(23163), binary.BigEndian.Uint32(value))
 	}
 }
+
+func TestSearchPhrase(t *testing.T) {
+	directory := initSimpleIndex()
+
+	indexReader, err := index.NewIndexReader(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_query := &query.PhraseNode{FieldName: "body", Terms: [][]byte{[]byte("business"), []byte("world")}}
+
+	collector := query.NewTopNCollector(10)
+
+	err = search.Search(_query, indexReader, collector)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	results := collector.Get()
+
+	assert.Len(t, results, 1)
+
+	value, err := indexReader.Value("id", results[0].DocId)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	assert.Equal(t, uint64(3), binary.BigEndian.Uint64(value))
+}
+
+// The segments written before the positions are searched, but phrases on
+// their fields are rejected
+func TestSearchVersion0Index(t *testing.T) {
+	indexReader, err := index.NewIndexReader(filepath.Join("index", "testdata", "v0"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer indexReader.Close()
+
+	collector := query.NewTopNCollector(10)
+
+	err = search.Search(&query.TermNode{FieldName: "body", Term: []byte("fox")}, indexReader, collector)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ids := make([]uint64, 0)
+	for _, result := range collector.Get() {
+		value, err := indexReader.Value("id", result.DocId)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		ids = append(ids, binary.BigEndian.Uint64(value))
+	}
+
+	slices.Sort(ids)
+	assert.Equal(t, []uint64{0, 2, 4}, ids)
+
+	// The second segment has no title
+	collector = query.NewTopNCollector(10)
+
+	err = search.Search(&query.TermNode{FieldName: "title", Term: []byte("foxes")}, indexReader, collector)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	assert.Len(t, collector.Get(), 1)
+
+	collector = query.NewTopNCollector(10)
+
+	err = search.Search(&query.PrefixNode{FieldName: "title", Prefix: []byte("b")}, indexReader, collector)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	assert.Len(t, collector.Get(), 1)
+
+	_query := &query.PhraseNode{FieldName: "body", Terms: [][]byte{[]byte("brown"), []byte("fox")}}
+	err = search.Search(_query, indexReader, query.NewTopNCollector(10))
+	assert.ErrorContains(t, err, "phrase on field body: segment 887699001 has no positions")
+}
+
+// The baseline analyzed the text without NFKC normalization, and so must its
+// queries
+func TestSearchVersion0IndexUnicode(t *testing.T) {
+	indexReader, err := index.NewIndexReader(filepath.Join("index", "testdata", "v0_unicode"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer indexReader.Close()
+
+	assert.Equal(t, index.StandardV0AnalyzerName, indexReader.Schema.AnalyzerName("body"))
+
+	for text, expectedIds := range map[string][]uint64{
+		"ｆｏｘ":  {0},
+		"ＦＯＸ":  {0},
+		"ﬁsh":  {0},
+		"fox":  {1},
+		"fish": {1},
+		"CAFÉ": {2},
+		"cafe": nil,
+	} {
+		ids, _ := searchIdsAndScores(&query.MatchNode{FieldName: "body", Text: text}, indexReader, 10)
+		assert.ElementsMatch(t, expectedIds, ids, text)
+	}
+}
+
+func TestSearchPhraseSlop(t *testing.T) {
+	directory := initSimpleIndex()
+
+	indexReader, err := index.NewIndexReader(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_query := &query.BooleanNode{
+		Clauses: []*query.BooleanClause{
+			{
+				Type: query.Should,
+				Node: &query.PhraseNode{FieldName: "body", Terms: [][]byte{[]byte("hello"), []byte("business")}},
+			},
+			{
+				Type: query.Should,
+				Node: &query.TermNode{FieldName: "body", Term: []byte("roger")},
+			},
+		},
+	}
+
+	collector := query.NewTopNCollector(10)
+
+	err = search.Search(_query, indexReader, collector)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	results := collector.Get()
+
+	assert.Len(t, results, 1)
+
+	_query.Clauses[0].Node = &query.PhraseNode{FieldName: "body", Terms: [][]byte{[]byte("hello"), []byte("business")}, Slop: 1}
+
+	collector = query.NewTopNCollector(10)
+
+	err = search.Search(_query, indexReader, collector)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	results = collector.Get()
+
+	assert.Len(t, results, 2)
+
+	value, err := indexReader.Value("id", results[0].DocId)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	assert.Equal(t, uint64(9), binary.BigEndian.Uint64(value))
+}
+
+func TestSearchMustAndShould(t *testing.T) {
+	directory := initSimpleIndex()
+
+	indexReader, err := index.NewIndexReader(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_query := &query.BooleanNode{
+		Clauses: []*query.BooleanClause{
+			{
+				Type: query.Must,
+				Node: &query.TermNode{FieldName: "title", Term: []byte("is")},
+			},
+			{
+				Type: query.Should,
+				Node: &query.TermNode{FieldName: "body", Term: []byte("roger")},
+			},
+		},
+	}
+
+	collector := query.NewTopNCollector(10)
+
+	err = search.Search(_query, indexReader, collector)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	results := collector.Get()
+
+	assert.Len(t, results, 2)
+
+	{
+		value, err := indexReader.Value("id", results[0].DocId)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		assert.Equal(t, uint64(34), binary.BigEndian.Uint64(value))
+	}
+
+	{
+		value, err := indexReader.Value("id", results[1].DocId)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		assert.Equal(t, uint64(89), binary.BigEndian.Uint64(value))
+	}
+}
+
+func TestSearchMustNot(t *testing.T) {
+	directory := initSimpleIndex()
+
+	indexReader, err := index.NewIndexReader(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_query := &query.BooleanNode{
+		Clauses: []*query.BooleanClause{
+			{
+				Type: query.Should,
+				Node: &query.TermNode{FieldName: "body", Term: []byte("is")},
+			},
+			{
+				Type: query.Should,
+				Node: &query.TermNode{FieldName: "body", Term: []byte("business")},
+			},
+			{
+				Type: query.MustNot,
+				Node: &query.TermNode{FieldName: "body", Term: []byte("hello")},
+			},
+			{
+				Type: query.MustNot,
+				Node: &query.TermNode{FieldName: "title", Term: []byte("ok")},
+			},
+		},
+	}
+
+	collector := query.NewTopNCollector(10)
+
+	err = search.Search(_query, indexReader, collector)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	results := collector.Get()
+
+	assert.Len(t, results, 2)
+
+	{
+		value, err := indexReader.Value("id", results[0].DocId)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		assert.Equal(t, uint64(89), binary.BigEndian.Uint64(value))
+	}
+
+	{
+		value, err := indexReader.Value("id", results[1].DocId)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		assert.Equal(t, uint64(3), binary.BigEndian.Uint64(value))
+	}
+}
+
+func TestSearchParsedQuery(t *testing.T) {
+	directory := initSimpleIndex()
+
+	indexReader, err := index.NewIndexReader(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer indexReader.Close()
+
+	_query, err := query.Parse("+(busi* OR apple) -title:local", "body", index.NewStandardAnalyzer())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ids, _ := searchIdsAndScores(_query, indexReader, 10)
+	assert.ElementsMatch(t, []uint64{9, 89}, ids)
+
+	// Prefix matching a term of another segment only
+	_query, err = query.Parse(`rog* OR "local business"`, "body", nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ids, _ = searchIdsAndScores(_query, indexReader, 10)
+	assert.ElementsMatch(t, []uint64{34, 3}, ids)
+}
+
+func initRandomIndex(random *rand.Rand, vocabulary []string) (string, map[uint64][]string) {
+	directory := filepath.Join("testdata", "directory")
+	os.RemoveAll(directory)
+
+	err := os.MkdirAll(directory, 0700)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	indexWriter, err := index.NewIndexWriter(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer indexWriter.Close()
+
+	terms := make(map[uint64][]string)
+	id := uint64(0)
+
+	for segment := 0; segment < 3; segment++ {
+		docs := make([]index.Document, 0, 700)
+
+		for i := 0; i < 700; i++ {
+			docTerms := make([]string, 1+random.Intn(20))
+			for j := range docTerms {
+				// Skewed so that terms have different frequencies
+				docTerms[j] = vocabulary[min(random.Intn(len(vocabulary)), random.Intn(len(vocabulary)))]
+			}
+
+			terms[id] = docTerms
+			docs = append(docs, index.Document{
+				{Name: "id", FieldType: index.ByteFieldType, Value: utils.Uint64ToBytes(id)},
+				{Name: "body", FieldType: index.TextFieldType, Value: []byte(strings.Join(docTerms, " "))},
+			})
+			id++
+		}
+
+		if err := indexWriter.AddDocuments(docs); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	return directory, terms
+}
+
+func randomQuery(random *rand.Rand, vocabulary []string, depth int) query.Node {
+	if depth == 0 || random.Intn(3) == 0 {
+		return &query.TermNode{FieldName: "body", Term: []byte(vocabulary[random.Intn(len(vocabulary))])}
+	}
+
+	clauses := make([]*query.BooleanClause, 1+random.Intn(4))
+	for i := range clauses {
+		clauses[i] = &query.BooleanClause{
+			Type: query.MatchType(random.Intn(3)),
+			Node: randomQuery(random, vocabulary, depth-1),
+		}
+	}
+
+	return &query.BooleanNode{Clauses: clauses}
+}
+
+func matches(node query.Node, docTerms []string) bool {
+	switch n := node.(type) {
+	case *query.TermNode:
+		return slices.Contains(docTerms, string(n.Term))
+	case *query.BoostNode:
+		return matches(n.Node, docTerms)
+	case *query.BooleanNode:
+		hasMust := false
+		hasShouldMatch := false
+
+		for _, clause := range n.Clauses {
+			clauseMatches := matches(clause.Node, docTerms)
+
+			switch clause.Type {
+			case query.Must:
+				if !clauseMatches {
+					return false
+				}
+				hasMust = true
+			case query.MustNot:
+				if clauseMatches {
+					return false
+				}
+			case query.Should:
+				hasShouldMatch = hasShouldMatch || clauseMatches
+			}
+		}
+
+		return hasMust || hasShouldMatch
+	}
+
+	log.Fatalf("unknown node %T", node)
+	return false
+}
+
+func TestSearchNestedBooleanRandom(t *testing.T) {
+	random := rand.New(rand.NewSource(42))
+	vocabulary := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+
+	directory, terms := initRandomIndex(random, vocabulary)
+
+	indexReader, err := index.NewIndexReader(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for i := 0; i < 100; i++ {
+		_query := randomQuery(random, vocabulary, 3)
+
+		collector := query.NewTopNCollector(1_000_000)
+
+		err = search.Search(_query, indexReader, collector)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		results := collector.Get()
+
+		expectedIds := make([]uint64, 0)
+		for id, docTerms := range terms {
+			if matches(_query, docTerms) {
+				expectedIds = append(expectedIds, id)
+			}
+		}
+
+		ids := make([]uint64, 0, len(results))
+		for _, result := range results {
+			value, err := indexReader.Value("id", result.DocId)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			ids = append(ids, binary.BigEndian.Uint64(value))
+		}
+
+		assert.ElementsMatch(t, expectedIds, ids, "query %d", i)
+
+		// With pruning, the top results must have the same scores
+		topCollector := query.NewTopNCollector(10)
+
+		err = search.Search(_query, indexReader, topCollector)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		topResults := topCollector.Get()
+
+		assert.Len(t, topResults, min(10, len(results)), "query %d", i)
+		for j := range topResults {
+			assert.InDelta(t, results[j].Score, topResults[j].Score, 1e-4, "query %d", i)
+		}
+	}
+}
+
+// Wraps random nodes of the query in boosts
+func boostRandomNodes(random *rand.Rand, node query.Node) query.Node {
+	if booleanNode, ok := node.(*query.BooleanNode); ok {
+		for _, clause := range booleanNode.Clauses {
+			clause.Node = boostRandomNodes(random, clause.Node)
+		}
+	}
+
+	if random.Intn(2) == 0 {
+		return node
+	}
+
+	return &query.BoostNode{Node: node, Boost: 0.1 + 5*random.Float32()}
+}
+
+func TestSearchBoostRandom(t *testing.T) {
+	random := rand.New(rand.NewSource(7))
+	vocabulary := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+
+	directory, terms := initRandomIndex(random, vocabulary)
+
+	indexReader, err := index.NewIndexReader(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer indexReader.Close()
+
+	for i := 0; i < 100; i++ {
+		_query := boostRandomNodes(random, randomQuery(random, vocabulary, 3))
+
+		ids, scores := searchIdsAndScores(_query, indexReader, 1_000_000)
+
+		expectedIds := make([]uint64, 0)
+		for id, docTerms := range terms {
+			if matches(_query, docTerms) {
+				expectedIds = append(expectedIds, id)
+			}
+		}
+
+		assert.ElementsMatch(t, expectedIds, ids, "query %d", i)
+
+		// Pruning must hold with the boosted upper bounds
+		_, topScores := searchIdsAndScores(_query, indexReader, 10)
+
+		assert.Len(t, topScores, min(10, len(scores)), "query %d", i)
+		for j := range topScores {
+			assert.InDelta(t, scores[j], topScores[j], 1e-4, "query %d", i)
+		}
+	}
+}
+
+func TestSearchBoost(t *testing.T) {
+	directory := initSimpleIndex()
+
+	indexReader, err := index.NewIndexReader(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer indexReader.Close()
+
+	_, scores := searchIdsAndScores(&query.TermNode{FieldName: "body", Term: []byte("business")}, indexReader, 10)
+	_, boostedScores := searchIdsAndScores(&query.BoostNode{Node: &query.TermNode{FieldName: "body", Term: []byte("business")}, Boost: 3}, indexReader, 10)
+
+	assert.Len(t, boostedScores, len(scores))
+	for i := range scores {
+		assert.InDelta(t, 3*scores[i], boostedScores[i], 1e-5)
+	}
+
+	// Doc 3 has "business" in its title, doc 9 has "hello" in its title
+	_query, err := query.Parse("title:business^0.5 title:hello", "body", nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ids, _ := searchIdsAndScores(_query, indexReader, 10)
+	assert.Equal(t, []uint64{9, 3}, ids)
+
+	_query, err = query.Parse("title:business^3 title:hello", "body", nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ids, _ = searchIdsAndScores(_query, indexReader, 10)
+	assert.Equal(t, []uint64{3, 9}, ids)
+
+	err = search.Search(&query.BoostNode{Node: &query.TermNode{FieldName: "body", Term: []byte("business")}, Boost: -1}, indexReader, query.NewTopNCollector(10))
+	assert.EqualError(t, err, "boost must be positive and finite: -1")
+}
+
+// Returns the index of docs with an int64 "price", a float64 "rating" and a
+// "date", in two segments, and the prices by id
+func initRangeIndex(random *rand.Rand) (string, map[uint64]int64) {
+	directory := filepath.Join("testdata", "range")
+	os.RemoveAll(directory)
+
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		log.Fatal(err)
+	}
+
+	indexWriter, err := index.NewIndexWriter(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer indexWriter.Close()
+
+	prices := make(map[uint64]int64)
+	date := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for segment := 0; segment < 2; segment++ {
+		docs := make([]index.Document, 0, 500)
+
+		for i := 0; i < 500; i++ {
+			id := uint64(len(prices))
+			price := random.Int63n(2000) - 1000
+
+			// Values far apart, to match terms of every precision
+			if i%50 == 0 {
+				price = random.Int63() - random.Int63()
+			}
+
+			prices[id] = price
+			body := "even"
+			if id%2 == 1 {
+				body = "odd"
+			}
+
+			docs = append(docs, index.Document{
+				{Name: "id", FieldType: index.ByteFieldType, Value: utils.Uint64ToBytes(id)},
+				{Name: "body", FieldType: index.TextFieldType, Value: []byte(body)},
+				{Name: "price", FieldType: index.Int64FieldType, Value: index.EncodeInt64(price)},
+				{Name: "rating", FieldType: index.Float64FieldType, Value: index.EncodeFloat64(float64(id%10) / 2)},
+				{Name: "date", FieldType: index.DateFieldType, Value: index.EncodeDate(date.AddDate(0, 0, int(id)))},
+			})
+		}
+
+		if err := indexWriter.AddDocuments(docs); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	return directory, prices
+}
+
+func TestSearchRangeRandom(t *testing.T) {
+	random := rand.New(rand.NewSource(11))
+
+	directory, prices := initRangeIndex(random)
+
+	indexReader, err := index.NewIndexReader(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer indexReader.Close()
+
+	for i := 0; i < 100; i++ {
+		lower, upper := random.Int63n(2400)-1200, random.Int63n(2400)-1200
+		if i%10 == 0 {
+			lower, upper = random.Int63()-random.Int63(), random.Int63()-random.Int63()
+		}
+
+		rangeNode := &query.RangeNode{
+			FieldName:    "price",
+			Lower:        index.EncodeInt64(lower),
+			Upper:        index.EncodeInt64(upper),
+			IncludeLower: random.Intn(2) == 0,
+			IncludeUpper: random.Intn(2) == 0,
+		}
+
+		// Open ranges
+		switch random.Intn(5) {
+		case 0:
+			rangeNode.Lower = nil
+		case 1:
+			rangeNode.Upper = nil
+		}
+
+		expectedIds := make([]uint64, 0)
+		for id, price := range prices {
+			if (rangeNode.Lower == nil || price > lower || (rangeNode.IncludeLower && price == lower)) &&
+				(rangeNode.Upper == nil || price < upper || (rangeNode.IncludeUpper && price == upper)) {
+				expectedIds = append(expectedIds, id)
+			}
+		}
+
+		ids, scores := searchIdsAndScores(rangeNode, indexReader, 1_000_000)
+		assert.ElementsMatch(t, expectedIds, ids, "range %d", i)
+
+		for _, score := range scores {
+			assert.Zero(t, score)
+		}
+	}
+}
+
+func TestSearchRange(t *testing.T) {
+	directory, prices := initRangeIndex(rand.New(rand.NewSource(5)))
+
+	indexReader, err := index.NewIndexReader(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer indexReader.Close()
+
+	// A filter doesn't change the scores
+	oddIds, oddScores := searchIdsAndScores(&query.TermNode{FieldName: "body", Term: []byte("odd")}, indexReader, 1_000_000)
+
+	expectedIds := make([]uint64, 0)
+	expectedScores := make(map[uint64]float32)
+	for i, id := range oddIds {
+		if prices[id] >= 0 {
+			expectedIds = append(expectedIds, id)
+			expectedScores[id] = oddScores[i]
+		}
+	}
+
+	ids, scores := searchIdsAndScores(&query.BooleanNode{Clauses: []*query.BooleanClause{
+		{Type: query.Must, Node: &query.TermNode{FieldName: "body", Term: []byte("odd")}},
+		{Type: query.Must, Node: &query.RangeNode{FieldName: "price", Lower: index.EncodeInt64(0), IncludeLower: true}},
+	}}, indexReader, 1_000_000)
+
+	actualScores := make(map[uint64]float32)
+	for i, id := range ids {
+		actualScores[id] = scores[i]
+	}
+
+	assert.Equal(t, expectedScores, actualScores)
+
+	// Excluded by a MustNot range
+	ids, _ = searchIdsAndScores(&query.BooleanNode{Clauses: []*query.BooleanClause{
+		{Type: query.Must, Node: &query.TermNode{FieldName: "body", Term: []byte("odd")}},
+		{Type: query.MustNot, Node: &query.RangeNode{FieldName: "price", Upper: index.EncodeInt64(0)}},
+	}}, indexReader, 1_000_000)
+	assert.ElementsMatch(t, expectedIds, ids)
+
+	// Docs only matching a Should range score 0
+	ids, scores = searchIdsAndScores(&query.BooleanNode{Clauses: []*query.BooleanClause{
+		{Type: query.Should, Node: &query.TermNode{FieldName: "body", Term: []byte("odd")}},
+		{Type: query.Should, Node: &query.RangeNode{FieldName: "price", Lower: index.EncodeInt64(0), IncludeLower: true}},
+	}}, indexReader, 1_000_000)
+
+	for i, id := range ids {
+		if id%2 == 0 {
+			assert.GreaterOrEqual(t, prices[id], int64(0))
+			assert.Zero(t, scores[i])
+		}
+	}
+
+	assert.Len(t, ids, len(oddIds)+countIf(prices, func(id uint64, price int64) bool { return id%2 == 0 && price >= 0 }))
+
+	// Float and date ranges. Ratings are 0, 0.5, ..., 4.5 and the dates one
+	// day apart from 2024-01-01.
+	ids, _ = searchIdsAndScores(&query.BooleanNode{Clauses: []*query.BooleanClause{
+		{Type: query.Must, Node: &query.RangeNode{FieldName: "rating", Lower: index.EncodeFloat64(4), Upper: index.EncodeFloat64(4.5), IncludeLower: true}},
+		{Type: query.Must, Node: &query.RangeNode{
+			FieldName:    "date",
+			Lower:        index.EncodeDate(time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)),
+			Upper:        index.EncodeDate(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)),
+			IncludeLower: true,
+		}},
+	}}, indexReader, 1_000_000)
+	assert.ElementsMatch(t, []uint64{18, 28}, ids)
+
+	// Exact values
+	ids, _ = searchIdsAndScores(&query.TermNode{FieldName: "rating", Term: index.NumericTerm(index.EncodeFloat64(2.5))}, indexReader, 1_000_000)
+	assert.Len(t, ids, 100)
+
+	// Empty ranges
+	ids, _ = searchIdsAndScores(&query.RangeNode{FieldName: "rating", Lower: index.EncodeFloat64(1), Upper: index.EncodeFloat64(1)}, indexReader, 10)
+	assert.Empty(t, ids)
+
+	ids, _ = searchIdsAndScores(&query.RangeNode{FieldName: "missing", Lower: index.EncodeFloat64(1)}, indexReader, 10)
+	assert.Empty(t, ids)
+
+	err = search.Search(&query.RangeNode{FieldName: "price", Lower: []byte{1}}, indexReader, query.NewTopNCollector(10))
+	assert.EqualError(t, err, "range of field price: bound of 1 bytes, expected 8")
+}
+
+func countIf(prices map[uint64]int64, predicate func(id uint64, price int64) bool) int {
+	count := 0
+	for id, price := range prices {
+		if predicate(id, price) {
+			count++
+		}
+	}
+
+	return count
+}
+
+type sortTestDoc struct {
+	date     int64
+	hasDate  bool
+	category string
+	tags     []string
+}
+
+func initSortIndex(random *rand.Rand) (string, map[uint64]*sortTestDoc) {
+	directory := filepath.Join("testdata", "sort")
+	os.RemoveAll(directory)
+
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		log.Fatal(err)
+	}
+
+	indexWriter, err := index.NewIndexWriter(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer indexWriter.Close()
+
+	// Doc ids in the order of the ids
+	indexWriter.SetMergePolicy(&index.NoMergePolicy{})
+
+	schema := index.NewSchema(index.StandardAnalyzerName)
+	schema.SetFieldDocValues("date", index.NumericDocValues)
+	schema.SetFieldDocValues("category", index.SortedDocValues)
+	schema.SetFieldDocValues("tags", index.SortedSetDocValues)
+	indexWriter.SetSchema(schema)
+
+	words := []string{"news", "sport", "world"}
+	categories := []string{"", "culture", "politics", "science"}
+	tags := []string{"a", "b", "c", "d", "e"}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	sortDocs := make(map[uint64]*sortTestDoc)
+
+	for segment := 0; segment < 3; segment++ {
+		docs := make([]index.Document, 0, 200)
+
+		for i := 0; i < 200; i++ {
+			id := uint64(len(sortDocs))
+			body := make([]string, 1+random.Intn(5))
+			for j := range body {
+				body[j] = words[random.Intn(len(words))]
+			}
+
+			doc := index.Document{
+				{Name: "id", FieldType: index.ByteFieldType, Value: utils.Uint64ToBytes(id)},
+				{Name: "body", FieldType: index.TextFieldType, Value: []byte(strings.Join(body, " "))},
+			}
+
+			sortDoc := &sortTestDoc{category: categories[random.Intn(len(categories))]}
+
+			// Few dates, so that docs are tied
+			if random.Intn(10) > 0 {
+				date := start.AddDate(0, 0, random.Intn(50))
+				sortDoc.date = date.UnixMilli()
+				sortDoc.hasDate = true
+				doc = append(doc, index.Field{Name: "date", FieldType: index.DateFieldType, Value: index.EncodeDate(date)})
+			}
+
+			if sortDoc.category != "" {
+				doc = append(doc, index.Field{Name: "category", FieldType: index.ByteFieldType, Value: []byte(sortDoc.category)})
+			}
+
+			for j := random.Intn(3); j > 0; j-- {
+				tag := tags[random.Intn(len(tags))]
+				sortDoc.tags = append(sortDoc.tags, tag)
+				doc = append(doc, index.Field{Name: "tags", FieldType: index.ByteFieldType, Value: []byte(tag)})
+			}
+
+			sortDocs[id] = sortDoc
+			docs = append(docs, doc)
+		}
+
+		if err := indexWriter.AddDocuments(docs); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	deletedIds := make([][]byte, 0)
+	for id := range sortDocs {
+		if id%7 == 0 {
+			deletedIds = append(deletedIds, utils.Uint64ToBytes(id))
+			delete(sortDocs, id)
+		}
+	}
+
+	if err := indexWriter.DeleteDocuments("id", deletedIds); err != nil {
+		log.Fatal(err)
+	}
+
+	return directory, sortDocs
+}
+
+// Compares the docs without a value after the others, in both orders
+func compareMissingLast(aHas, bHas bool, compare func() int) int {
+	if aHas != bHas {
+		if aHas {
+			return -1
+		}
+
+		return 1
+	}
+
+	if !aHas {
+		return 0
+	}
+
+	return compare()
+}
+
+func TestSearchSorted(t *testing.T) {
+	directory, sortDocs := initSortIndex(rand.New(rand.NewSource(3)))
+
+	indexReader, err := index.NewIndexReader(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer indexReader.Close()
+
+	news := &query.TermNode{FieldName: "body", Term: []byte("news")}
+	matchIds, matchScores := searchIdsAndScores(news, indexReader, 1_000_000)
+	scores := make(map[uint64]float32)
+	for i, id := range matchIds {
+		scores[id] = matchScores[i]
+	}
+
+	tests := []struct {
+		keys []query.SortKey
+		// Compares the docs of two ids, before the tie-break by id
+		compare func(a, b uint64) int
+	}{
+		{
+			// Newest first
+			keys: []query.SortKey{{Kind: query.SortByField, FieldName: "date", Descending: true}},
+			compare: func(a, b uint64) int {
+				return compareMissingLast(sortDocs[a].hasDate, sortDocs[b].hasDate, func() int {
+					return cmp.Compare(sortDocs[b].date, sortDocs[a].date)
+				})
+			},
+		},
+		{
+			keys: []query.SortKey{{Kind: query.SortByField, FieldName: "category"}, {Kind: query.SortByScore, Descending: true}},
+			compare: func(a, b uint64) int {
+				comparison := compareMissingLast(sortDocs[a].category != "", sortDocs[b].category != "", func() int {
+					return strings.Compare(sortDocs[a].category, sortDocs[b].category)
+				})
+				if comparison != 0 {
+					return comparison
+				}
+
+				return cmp.Compare(scores[b], scores[a])
+			},
+		},
+		{
+			// Largest tag first
+			keys: []query.SortKey{{Kind: query.SortByField, FieldName: "tags", Descending: true}, {Kind: query.SortByDocId, Descending: true}},
+			compare: func(a, b uint64) int {
+				comparison := compareMissingLast(len(sortDocs[a].tags) > 0, len(sortDocs[b].tags) > 0, func() int {
+					return strings.Compare(slices.Max(sortDocs[b].tags), slices.Max(sortDocs[a].tags))
+				})
+				if comparison != 0 {
+					return comparison
+				}
+
+				return cmp.Compare(b, a)
+			},
+		},
+		{
+			keys: []query.SortKey{{Kind: query.SortByScore, Descending: true}, {Kind: query.SortByField, FieldName: "date"}},
+			compare: func(a, b uint64) int {
+				if comparison := cmp.Compare(scores[b], scores[a]); comparison != 0 {
+					return comparison
+				}
+
+				return compareMissingLast(sortDocs[a].hasDate, sortDocs[b].hasDate, func() int {
+					return cmp.Compare(sortDocs[a].date, sortDocs[b].date)
+				})
+			},
+		},
+	}
+
+	for i, test := range tests {
+		expectedIds := slices.Clone(matchIds)
+		slices.SortFunc(expectedIds, func(a, b uint64) int {
+			if comparison := test.compare(a, b); comparison != 0 {
+				return comparison
+			}
+
+			return cmp.Compare(a, b)
+		})
+
+		for _, topN := range []int{10, len(expectedIds)} {
+			collector := query.NewSortedCollector(topN, test.keys...)
+			if err := search.Search(news, indexReader, collector); err != nil {
+				log.Fatal(err)
+			}
+
+			ids := make([]uint64, 0, topN)
+			for _, result := range collector.Get() {
+				value, err := indexReader.Value("id", result.DocId)
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				id := binary.BigEndian.Uint64(value)
+				ids = append(ids, id)
+				assert.Equal(t, scores[id], result.Score)
+			}
+
+			assert.Equal(t, expectedIds[:topN], ids, "test %d, top %d", i, topN)
+		}
+	}
+}
+
+func randomDocuments(random *rand.Rand, vocabulary []string, firstId uint64, numDocs int) ([]index.Document, map[uint64][]string) {
+	docs := make([]index.Document, 0, numDocs)
+	terms := make(map[uint64][]string, numDocs)
+
+	for id := firstId; id < firstId+uint64(numDocs); id++ {
+		docTerms := make([]string, 1+random.Intn(20))
+		for j := range docTerms {
+			docTerms[j] = vocabulary[min(random.Intn(len(vocabulary)), random.Intn(len(vocabulary)))]
+		}
+
+		terms[id] = docTerms
+		docs = append(docs, index.Document{
+			{Name: "id", FieldType: index.ByteFieldType, Value: utils.Uint64ToBytes(id)},
+			{Name: "body", FieldType: index.TextFieldType, Value: []byte(strings.Join(docTerms, " "))},
+		})
+	}
+
+	return docs, terms
+}
+
+func searchIdsAndScores(_query query.Node, indexReader *index.IndexReader, n int) ([]uint64, []float32) {
+	collector := query.NewTopNCollector(n)
+
+	if err := search.Search(_query, indexReader, collector); err != nil {
+		log.Fatal(err)
+	}
+
+	results := collector.Get()
+	ids := make([]uint64, 0, len(results))
+	scores := make([]float32, 0, len(results))
+
+	for _, result := range results {
+		value, err := indexReader.Value("id", result.DocId)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		ids = append(ids, binary.BigEndian.Uint64(value))
+		scores = append(scores, result.Score)
+	}
+
+	return ids, scores
+}
+
+func TestSearchMergeSegments(t *testing.T) {
+	random := rand.New(rand.NewSource(42))
+	vocabulary := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+
+	directory := filepath.Join("testdata", "merge")
+	os.RemoveAll(directory)
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		log.Fatal(err)
+	}
+
+	indexWriter, err := index.NewIndexWriter(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer indexWriter.Close()
+	indexWriter.SetMergePolicy(&index.NoMergePolicy{})
+
+	allDocs := make([]index.Document, 0, 800)
+	terms := make(map[uint64][]string)
+
+	for segment := 0; segment < 4; segment++ {
+		docs, docTerms := randomDocuments(random, vocabulary, uint64(len(allDocs)), 200)
+		if err := indexWriter.AddDocuments(docs); err != nil {
+			log.Fatal(err)
+		}
+
+		allDocs = append(allDocs, docs...)
+		maps.Copy(terms, docTerms)
+	}
+
+	deletedIds := make([][]byte, 0)
+	for id := uint64(0); id < uint64(len(allDocs)); id += 7 {
+		deletedIds = append(deletedIds, utils.Uint64ToBytes(id))
+		delete(terms, id)
+	}
+
+	if err := indexWriter.DeleteDocuments("id", deletedIds); err != nil {
+		log.Fatal(err)
+	}
+
+	indexWriter.SetMergePolicy(&index.LogMergePolicy{MergeFactor: 4, MinMergeDocs: 1})
+	if err := indexWriter.Merge(); err != nil {
+		log.Fatal(err)
+	}
+
+	indexReader, err := index.NewIndexReader(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	assert.Len(t, indexReader.SegmentReaders, 1)
+	assert.Equal(t, uint32(len(terms)), indexReader.SegmentReaders[0].Info.DocCount)
+	assert.True(t, indexReader.SegmentReaders[0].DeletedDocIds.IsEmpty())
+
+	// Same live docs, in the same order, written in a single segment
+	expectedDirectory := filepath.Join("testdata", "merge_expected")
+	os.RemoveAll(expectedDirectory)
+	if err := os.MkdirAll(expectedDirectory, 0700); err != nil {
+		log.Fatal(err)
+	}
+
+	liveDocs := make([]index.Document, 0, len(terms))
+	for id, doc := range allDocs {
+		if _, exists := terms[uint64(id)]; exists {
+			liveDocs = append(liveDocs, doc)
+		}
+	}
+
+	expectedIndexWriter, err := index.NewIndexWriter(expectedDirectory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer expectedIndexWriter.Close()
+
+	if err := expectedIndexWriter.AddDocuments(liveDocs); err != nil {
+		log.Fatal(err)
+	}
+
+	expectedIndexReader, err := index.NewIndexReader(expectedDirectory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for i := 0; i < 50; i++ {
+		var _query query.Node
+		if i%2 == 0 {
+			_query = randomQuery(random, vocabulary, 3)
+		} else {
+			_query = &query.PhraseNode{
+				FieldName: "body",
+				Terms:     [][]byte{[]byte(vocabulary[random.Intn(3)]), []byte(vocabulary[random.Intn(3)])},
+			}
+		}
+
+		ids, scores := searchIdsAndScores(_query, indexReader, 1_000_000)
+		expectedIds, expectedScores := searchIdsAndScores(_query, expectedIndexReader, 1_000_000)
+
+		assert.ElementsMatch(t, expectedIds, ids, "query %d", i)
+		assert.InDeltaSlice(t, expectedScores, scores, 1e-4, "query %d", i)
+
+		for _, id := range ids {
+			_, exists := terms[id]
+			assert.True(t, exists, "query %d", i)
+		}
+	}
+}
+
+func TestSearchForceMergeDeletes(t *testing.T) {
+	directory := initSimpleIndex()
+
+	indexWriter, err := index.NewIndexWriter(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer indexWriter.Close()
+
+	if err := indexWriter.DeleteDocuments("id", [][]byte{utils.Uint64ToBytes(89), utils.Uint64ToBytes(34)}); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := indexWriter.ForceMergeDeletes(); err != nil {
+		log.Fatal(err)
+	}
+
+	indexReader, err := index.NewIndexReader(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// The segment of doc 34 has no live doc left
+	assert.Len(t, indexReader.SegmentReaders, 1)
+	assert.Equal(t, uint32(2), indexReader.SegmentReaders[0].Info.DocCount)
+	assert.True(t, indexReader.SegmentReaders[0].DeletedDocIds.IsEmpty())
+
+	{
+		ids, _ := searchIdsAndScores(&query.TermNode{FieldName: "title", Term: []byte("is")}, indexReader, 10)
+		assert.Empty(t, ids)
+	}
+
+	{
+		ids, _ := searchIdsAndScores(&query.TermNode{FieldName: "body", Term: []byte("business")}, indexReader, 10)
+		assert.Equal(t, []uint64{3, 9}, ids)
+	}
+
+	{
+		ids, _ := searchIdsAndScores(&query.PhraseNode{FieldName: "body", Terms: [][]byte{[]byte("business"), []byte("world")}}, indexReader, 10)
+		assert.Equal(t, []uint64{3}, ids)
+	}
+}
+
+func TestSearchForceMergeDeletesThreshold(t *testing.T) {
+	directory := initSimpleIndex()
+
+	indexWriter, err := index.NewIndexWriter(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer indexWriter.Close()
+	indexWriter.SetForceMergeDeletesThreshold(0.5)
+
+	if err := indexWriter.DeleteDocuments("id", [][]byte{utils.Uint64ToBytes(89)}); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := indexWriter.ForceMergeDeletes(); err != nil {
+		log.Fatal(err)
+	}
+
+	indexReader, err := index.NewIndexReader(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// 1 deleted doc out of 3 is below the threshold
+	assert.Len(t, indexReader.SegmentReaders, 2)
+	assert.Equal(t, uint32(3), indexReader.SegmentReaders[0].Info.DocCount)
+	assert.Equal(t, uint64(1), indexReader.SegmentReaders[0].DeletedDocIds.GetCardinality())
+}
+
+func TestSearchUpdateDocuments(t *testing.T) {
+	directory := initSimpleIndex()
+
+	indexWriter, err := index.NewIndexWriter(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer indexWriter.Close()
+
+	docs := []index.Document{
+		[]index.Field{
+			{Name: "id", FieldType: index.ByteFieldType, Value: utils.Uint64ToBytes(89)},
+			{Name: "body", FieldType: index.TextFieldType, Value: []byte("This is a banana")},
+			{Name: "title", FieldType: index.TextFieldType, Value: []byte("Fruits")},
+		},
+		[]index.Field{
+			{Name: "id", FieldType: index.ByteFieldType, Value: utils.Uint64ToBytes(100)},
+			{Name: "body", FieldType: index.TextFieldType, Value: []byte("An apple and a banana")},
+			{Name: "title", FieldType: index.TextFieldType, Value: []byte("More fruits")},
+		},
+	}
+
+	if err := indexWriter.UpdateDocuments("id", docs); err != nil {
+		log.Fatal(err)
+	}
+
+	indexReader, err := index.NewIndexReader(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	{
+		ids, _ := searchIdsAndScores(&query.TermNode{FieldName: "body", Term: []byte("apple")}, indexReader, 10)
+		assert.Equal(t, []uint64{100}, ids)
+	}
+
+	{
+		ids, _ := searchIdsAndScores(&query.TermNode{FieldName: "body", Term: []byte("banana")}, indexReader, 10)
+		assert.ElementsMatch(t, []uint64{89, 100}, ids)
+	}
+
+	{
+		ids, _ := searchIdsAndScores(&query.TermNode{FieldName: "id", Term: utils.Uint64ToBytes(89)}, indexReader, 10)
+		assert.Equal(t, []uint64{89}, ids)
+	}
+
+	// Missing id field
+	err = indexWriter.UpdateDocuments("id", []index.Document{
+		[]index.Field{
+			{Name: "body", FieldType: index.TextFieldType, Value: []byte("No id")},
+		},
+	})
+	assert.Error(t, err)
+}
+
+func TestSearchAnalyzer(t *testing.T) {
+	directory := filepath.Join("testdata", "analyzer")
+	os.RemoveAll(directory)
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		log.Fatal(err)
+	}
+
+	// Titles are case sensitive
+	schema := index.NewSchema(index.StandardAnalyzerName)
+	schema.SetFieldAnalyzer("title", caseSensitiveAnalyzerName)
+
+	indexWriter, err := index.NewIndexWriter(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer indexWriter.Close()
+	indexWriter.SetSchema(schema)
+
+	docs := []index.Document{
+		[]index.Field{
+			{Name: "id", FieldType: index.ByteFieldType, Value: utils.Uint64ToBytes(1)},
+			{Name: "title", FieldType: index.TextFieldType, Value: []byte("Apple Pie")},
+			{Name: "body", FieldType: index.TextFieldType, Value: []byte("An Apple a day")},
+		},
+		[]index.Field{
+			{Name: "id", FieldType: index.ByteFieldType, Value: utils.Uint64ToBytes(2)},
+			{Name: "title", FieldType: index.TextFieldType, Value: []byte("apple pie")},
+			{Name: "body", FieldType: index.TextFieldType, Value: []byte("Keeps the Doctor away")},
+		},
+	}
+
+	if err := indexWriter.AddDocuments(docs); err != nil {
+		log.Fatal(err)
+	}
+
+	indexReader, err := index.NewIndexReader(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	{
+		ids, _ := searchIdsAndScores(&query.MatchNode{FieldName: "title", Text: "Apple"}, indexReader, 10)
+		assert.Equal(t, []uint64{1}, ids)
+	}
+
+	{
+		ids, _ := searchIdsAndScores(&query.MatchNode{FieldName: "body", Text: "APPLE doctor"}, indexReader, 10)
+		assert.ElementsMatch(t, []uint64{1, 2}, ids)
+	}
+
+	{
+		ids, _ := searchIdsAndScores(&query.MatchNode{FieldName: "body", Text: "APPLE doctor", Operator: query.Must}, indexReader, 10)
+		assert.Empty(t, ids)
+	}
+
+	{
+		ids, _ := searchIdsAndScores(&query.MatchPhraseNode{FieldName: "body", Text: "the DOCTOR"}, indexReader, 10)
+		assert.Equal(t, []uint64{2}, ids)
+	}
+
+	{
+		ids, _ := searchIdsAndScores(&query.MatchNode{FieldName: "body", Text: "..."}, indexReader, 10)
+		assert.Empty(t, ids)
+	}
+}
+
+func TestSearchSavedAnalyzers(t *testing.T) {
+	directory := filepath.Join("testdata", "saved_analyzers")
+	os.RemoveAll(directory)
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		log.Fatal(err)
+	}
+
+	schema := index.NewSchema(index.StandardAnalyzerName)
+	schema.SetFieldAnalyzer("title", caseSensitiveAnalyzerName)
+
+	indexWriter, err := index.NewIndexWriter(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer indexWriter.Close()
+	indexWriter.SetSchema(schema)
+
+	doc := index.Document{
+		{Name: "id", FieldType: index.ByteFieldType, Value: utils.Uint64ToBytes(1)},
+		{Name: "title", FieldType: index.TextFieldType, Value: []byte("Apple Pie")},
+	}
+
+	if err := indexWriter.AddDocuments([]index.Document{doc}); err != nil {
+		log.Fatal(err)
+	}
+
+	// The analyzer of a field can't change
+	indexWriter.SetSchema(index.NewSchema(index.StandardAnalyzerName))
+	assert.Error(t, indexWriter.AddDocuments([]index.Document{doc}))
+
+	unknownSchema := index.NewSchema(index.StandardAnalyzerName)
+	unknownSchema.SetFieldAnalyzer("body", "unknown")
+	indexWriter.SetSchema(unknownSchema)
+	assert.Error(t, indexWriter.AddDocuments([]index.Document{{{Name: "body", FieldType: index.TextFieldType, Value: []byte("Pie")}}}))
+
+	// The reader uses the analyzer saved by the writer
+	indexReader, err := index.NewIndexReader(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer indexReader.Close()
+
+	assert.Equal(t, caseSensitiveAnalyzerName, indexReader.Schema.AnalyzerName("title"))
+
+	{
+		ids, _ := searchIdsAndScores(&query.MatchNode{FieldName: "title", Text: "Apple"}, indexReader, 10)
+		assert.Equal(t, []uint64{1}, ids)
+	}
+
+	{
+		ids, _ := searchIdsAndScores(&query.MatchNode{FieldName: "title", Text: "apple"}, indexReader, 10)
+		assert.Empty(t, ids)
+	}
+}
+
+func TestSearchEnglishAnalyzer(t *testing.T) {
+	directory := filepath.Join("testdata", "english_analyzer")
+	os.RemoveAll(directory)
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		log.Fatal(err)
+	}
+
+	schema := index.NewSchema(index.EnglishAnalyzerName)
+
+	indexWriter, err := index.NewIndexWriter(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer indexWriter.Close()
+	indexWriter.SetSchema(schema)
+
+	docs := []index.Document{
+		[]index.Field{
+			{Name: "id", FieldType: index.ByteFieldType, Value: utils.Uint64ToBytes(1)},
+			{Name: "body", FieldType: index.TextFieldType, Value: []byte("The dog is running in the park")},
+		},
+		[]index.Field{
+			{Name: "id", FieldType: index.ByteFieldType, Value: utils.Uint64ToBytes(2)},
+			{Name: "body", FieldType: index.TextFieldType, Value: []byte("Parks and connections")},
+		},
+	}
+
+	if err := indexWriter.AddDocuments(docs); err != nil {
+		log.Fatal(err)
+	}
+
+	indexReader, err := index.NewIndexReader(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	{
+		ids, _ := searchIdsAndScores(&query.MatchNode{FieldName: "body", Text: "runs"}, indexReader, 10)
+		assert.Equal(t, []uint64{1}, ids)
+	}
+
+	{
+		ids, _ := searchIdsAndScores(&query.MatchNode{FieldName: "body", Text: "the park"}, indexReader, 10)
+		assert.ElementsMatch(t, []uint64{1, 2}, ids)
+	}
+
+	{
+		// Stop words are dropped from the query
+		ids, _ := searchIdsAndScores(&query.MatchNode{FieldName: "body", Text: "the"}, indexReader, 10)
+		assert.Empty(t, ids)
+	}
+
+	{
+		ids, _ := searchIdsAndScores(&query.MatchPhraseNode{FieldName: "body", Text: "runs in parks"}, indexReader, 10)
+		assert.Equal(t, []uint64{1}, ids)
+	}
+}
+
+func TestSearchFoldingAnalyzer(t *testing.T) {
+	directory := filepath.Join("testdata", "folding_analyzer")
+	os.RemoveAll(directory)
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		log.Fatal(err)
+	}
+
+	schema := index.NewSchema(index.FoldingAnalyzerName)
+
+	indexWriter, err := index.NewIndexWriter(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer indexWriter.Close()
+	indexWriter.SetSchema(schema)
+
+	docs := []index.Document{
+		[]index.Field{
+			{Name: "id", FieldType: index.ByteFieldType, Value: utils.Uint64ToBytes(1)},
+			{Name: "body", FieldType: index.TextFieldType, Value: []byte("Le Café de Flore")},
+		},
+		[]index.Field{
+			{Name: "id", FieldType: index.ByteFieldType, Value: utils.Uint64ToBytes(2)},
+			{Name: "body", FieldType: index.TextFieldType, Value: []byte("ＣＡＦＥ")},
+		},
+	}
+
+	if err := indexWriter.AddDocuments(docs); err != nil {
+		log.Fatal(err)
+	}
+
+	indexReader, err := index.NewIndexReader(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, text := range []string{"cafe", "café", "CAFÉ"} {
+		ids, _ := searchIdsAndScores(&query.MatchNode{FieldName: "body", Text: text}, indexReader, 10)
+		assert.ElementsMatch(t, []uint64{1, 2}, ids, text)
+	}
+}
+
+// Number of files of directory opened by the process. Files leaked by the
+// previous tests may be closed at any time by the garbage collector, so they
+// are not counted.
+func openFileDescriptorCount(directory string) int {
+	absoluteDirectory, err := filepath.Abs(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	count := 0
+	for _, entry := range entries {
+		target, err := os.Readlink(filepath.Join("/proc/self/fd", entry.Name()))
+		if err != nil {
+			continue
+		}
+
+		// Files of the previous tests, removed with their directory
+		if strings.HasSuffix(target, " (deleted)") {
+			continue
+		}
+
+		if strings.HasPrefix(target, absoluteDirectory+string(filepath.Separator)) {
+			count++
+		}
+	}
+
+	return count
+}
+
+func TestIndexReaderClose(t *testing.T) {
+	if _, err := os.Stat("/proc/self/fd"); err != nil {
+		t.Skip("no /proc/self/fd")
+	}
+
+	directory := initSimpleIndex()
+
+	// Deleted file and merged segments
+	indexWriter, err := index.NewIndexWriter(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer indexWriter.Close()
+	if err := indexWriter.DeleteDocuments("id", [][]byte{utils.Uint64ToBytes(3)}); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := indexWriter.ForceMergeDeletes(); err != nil {
+		log.Fatal(err)
+	}
+
+	fileDescriptorCount := openFileDescriptorCount(directory)
+
+	indexReader, err := index.NewIndexReader(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	indexReader.IncRef()
+
+	queries := []query.Node{
+		&query.TermNode{FieldName: "body", Term: []byte("business")},
+		&query.PhraseNode{FieldName: "body", Terms: [][]byte{[]byte("hello"), []byte("world")}},
+		&query.MatchNode{FieldName: "title", Text: "this is ok"},
+	}
+
+	for _, _query := range queries {
+		ids, _ := searchIdsAndScores(_query, indexReader, 10)
+		assert.NotEmpty(t, ids)
+	}
+
+	assert.Greater(t, openFileDescriptorCount(directory), fileDescriptorCount)
+
+	// Still used
+	assert.NoError(t, indexReader.Close())
+	assert.Greater(t, openFileDescriptorCount(directory), fileDescriptorCount)
+
+	assert.NoError(t, indexReader.Close())
+	assert.Equal(t, fileDescriptorCount, openFileDescriptorCount(directory))
+
+	assert.Error(t, indexReader.Close())
+
+	// The writer closes the readers it opens
+	indexWriter.SetMergePolicy(&index.LogMergePolicy{MergeFactor: 2, MinMergeDocs: 1})
+	if err := indexWriter.UpdateDocuments("id", []index.Document{
+		[]index.Field{
+			{Name: "id", FieldType: index.ByteFieldType, Value: utils.Uint64ToBytes(9)},
+			{Name: "body", FieldType: index.TextFieldType, Value: []byte("Updated")},
+		},
+	}); err != nil {
+		log.Fatal(err)
+	}
+
+	assert.Equal(t, fileDescriptorCount, openFileDescriptorCount(directory))
+}
+
+func TestIndexReaderReopen(t *testing.T) {
+	if _, err := os.Stat("/proc/self/fd"); err != nil {
+		t.Skip("no /proc/self/fd")
+	}
+
+	directory := initSimpleIndex()
+
+	fileDescriptorCount := openFileDescriptorCount(directory)
+
+	indexReader, err := index.NewIndexReader(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	businessQuery := &query.TermNode{FieldName: "body", Term: []byte("business")}
+
+	{
+		ids, _ := searchIdsAndScores(businessQuery, indexReader, 10)
+		assert.ElementsMatch(t, []uint64{3, 9}, ids)
+	}
+
+	indexWriter, err := index.NewIndexWriter(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer indexWriter.Close()
+	indexWriter.SetMergePolicy(&index.NoMergePolicy{})
+
+	if err := indexWriter.AddDocuments([]index.Document{
+		[]index.Field{
+			{Name: "id", FieldType: index.ByteFieldType, Value: utils.Uint64ToBytes(50)},
+			{Name: "body", FieldType: index.TextFieldType, Value: []byte("Business as usual")},
+		},
+	}); err != nil {
+		log.Fatal(err)
+	}
+
+	// New segment
+	reopenedIndexReader, err := indexReader.Reopen()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	assert.Len(t, reopenedIndexReader.SegmentReaders, 3)
+	assert.Same(t, indexReader.SegmentReaders[0], reopenedIndexReader.SegmentReaders[0])
+	assert.Same(t, indexReader.SegmentReaders[1], reopenedIndexReader.SegmentReaders[1])
+
+	{
+		ids, _ := searchIdsAndScores(businessQuery, reopenedIndexReader, 10)
+		assert.ElementsMatch(t, []uint64{3, 9, 50}, ids)
+	}
+
+	if err := indexWriter.DeleteDocuments("id", [][]byte{utils.Uint64ToBytes(9)}); err != nil {
+		log.Fatal(err)
+	}
+
+	// New deleted docs only
+	deletedIndexReader, err := reopenedIndexReader.Reopen()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	assert.Len(t, deletedIndexReader.SegmentReaders, 3)
+	assert.NotSame(t, reopenedIndexReader.SegmentReaders[0], deletedIndexReader.SegmentReaders[0])
+
+	// The files are shared
+	for i := range deletedIndexReader.SegmentReaders {
+		dictionaryReader, err := reopenedIndexReader.SegmentReaders[i].DictionaryReader("body")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		deletedDictionaryReader, err := deletedIndexReader.SegmentReaders[i].DictionaryReader("body")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		assert.Same(t, dictionaryReader, deletedDictionaryReader)
+	}
+
+	{
+		ids, _ := searchIdsAndScores(businessQuery, deletedIndexReader, 10)
+		assert.ElementsMatch(t, []uint64{3, 50}, ids)
+	}
+
+	// Previous readers don't change
+	{
+		ids, _ := searchIdsAndScores(businessQuery, indexReader, 10)
+		assert.ElementsMatch(t, []uint64{3, 9}, ids)
+	}
+
+	assert.NoError(t, indexReader.Close())
+	assert.NoError(t, reopenedIndexReader.Close())
+
+	{
+		ids, _ := searchIdsAndScores(businessQuery, deletedIndexReader, 10)
+		assert.ElementsMatch(t, []uint64{3, 50}, ids)
+	}
+
+	assert.NoError(t, deletedIndexReader.Close())
+	assert.NoError(t, indexWriter.Close())
+
+	assert.Equal(t, fileDescriptorCount, openFileDescriptorCount(directory))
+}
+
+func TestIndexWriterLock(t *testing.T) {
+	directory := initSimpleIndex()
+
+	indexWriter, err := index.NewIndexWriter(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = index.NewIndexWriter(directory)
+	assert.ErrorIs(t, err, index.ErrLocked)
+
+	assert.NoError(t, indexWriter.Close())
+	assert.Error(t, indexWriter.AddDocuments(nil))
+	assert.Error(t, indexWriter.Close())
+
+	// The lock is released
+	otherIndexWriter, err := index.NewIndexWriter(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	assert.NoError(t, otherIndexWriter.Close())
+}
+
+// Returns the segments and deleted files in the directory, as "segment.<id>"
+// and "deleted.<id>"
+func indexFilePrefixes(directory string) []string {
+	dirEntries, err := os.ReadDir(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	prefixes := make([]string, 0)
+
+	for _, dirEntry := range dirEntries {
+		parts := strings.SplitN(dirEntry.Name(), ".", 3)
+		if len(parts) < 2 || (parts[0] != "segment" && parts[0] != "deleted") {
+			continue
+		}
+
+		prefix := parts[0] + "." + parts[1]
+		if !slices.Contains(prefixes, prefix) {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+
+	slices.Sort(prefixes)
+
+	return prefixes
+}
+
+func commitFilePrefixes(commits ...*index.Commit) []string {
+	prefixes := make([]string, 0)
+
+	for _, commit := range commits {
+		for _, segmentId := range commit.SegmentIds {
+			prefixes = append(prefixes, fmt.Sprintf("segment.%d", segmentId))
+		}
+
+		if commit.DeletedId != nil {
+			prefixes = append(prefixes, fmt.Sprintf("deleted.%d", *commit.DeletedId))
+		}
+	}
+
+	slices.Sort(prefixes)
+
+	return slices.Compact(prefixes)
+}
+
+func TestIndexWriterCollectGarbage(t *testing.T) {
+	// Other tests leave readers open on testdata/directory
+	directory := initSimpleIndexAt(filepath.Join("testdata", "garbage"))
+
+	indexWriter, err := index.NewIndexWriter(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer indexWriter.Close()
+
+	currentCommit := func() *index.Commit {
+		commit, err := indexWriter.Snapshot()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := indexWriter.ReleaseSnapshot(commit); err != nil {
+			log.Fatal(err)
+		}
+
+		return commit
+	}
+
+	// Files of a failed write, and a file unknown to the index
+	for _, name := range []string{"segment.123.body.frequencies", "deleted.77.data", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(directory, name), nil, 0600); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	assert.NoError(t, indexWriter.CollectGarbage())
+	assert.Equal(t, commitFilePrefixes(currentCommit()), indexFilePrefixes(directory))
+	assert.FileExists(t, filepath.Join(directory, "notes.txt"))
+
+	// The files of an open reader are kept
+	indexReader, err := index.NewIndexReader(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	readerCommit := currentCommit()
+
+	if err := indexWriter.DeleteDocuments("id", [][]byte{utils.Uint64ToBytes(89)}); err != nil {
+		log.Fatal(err)
+	}
+
+	indexWriter.SetMergePolicy(&index.LogMergePolicy{MergeFactor: 2, MinMergeDocs: 10})
+	if err := indexWriter.Merge(); err != nil {
+		log.Fatal(err)
+	}
+
+	assert.Len(t, currentCommit().SegmentIds, 1)
+	assert.Equal(t, commitFilePrefixes(currentCommit(), readerCommit), indexFilePrefixes(directory))
+
+	{
+		ids, _ := searchIdsAndScores(&query.TermNode{FieldName: "body", Term: []byte("apple")}, indexReader, 10)
+		assert.Equal(t, []uint64{89}, ids)
+	}
+
+	assert.NoError(t, indexReader.Close())
+	assert.NoError(t, indexWriter.CollectGarbage())
+	assert.Equal(t, commitFilePrefixes(currentCommit()), indexFilePrefixes(directory))
+
+	// The files of a snapshot are kept until it's released, and the next
+	// commit removes them
+	snapshot, err := indexWriter.Snapshot()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := indexWriter.UpdateDocuments("id", []index.Document{
+		[]index.Field{
+			{Name: "id", FieldType: index.ByteFieldType, Value: utils.Uint64ToBytes(34)},
+			{Name: "body", FieldType: index.TextFieldType, Value: []byte("Roger that, over")},
+		},
+	}); err != nil {
+		log.Fatal(err)
+	}
+
+	assert.Equal(t, commitFilePrefixes(currentCommit(), snapshot), indexFilePrefixes(directory))
+	assert.NoError(t, indexWriter.ReleaseSnapshot(snapshot))
+	assert.Error(t, indexWriter.ReleaseSnapshot(snapshot))
+
+	if err := indexWriter.DeleteDocuments("id", [][]byte{utils.Uint64ToBytes(9)}); err != nil {
+		log.Fatal(err)
+	}
+
+	assert.Equal(t, commitFilePrefixes(currentCommit()), indexFilePrefixes(directory))
+	assert.FileExists(t, filepath.Join(directory, "notes.txt"))
+}
+
+// Run by TestIndexReaderOtherProcess in a child process: opens a reader of
+// the index, then searches it once a line is read from stdin
+func TestIndexReaderChildProcess(t *testing.T) {
+	directory := os.Getenv("LYNX_TEST_READER_DIRECTORY")
+	if directory == "" {
+		t.Skip("run by TestIndexReaderOtherProcess")
+	}
+
+	indexReader, err := index.NewIndexReader(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer indexReader.Close()
+
+	fmt.Println("open")
+
+	if _, err := bufio.NewReader(os.Stdin).ReadString('\n'); err != nil {
+		log.Fatal(err)
+	}
+
+	ids, _ := searchIdsAndScores(&query.TermNode{FieldName: "body", Term: []byte("apple")}, indexReader, 10)
+	fmt.Println("ids", ids)
+}
+
+// The garbage collection of the writer doesn't know the readers of other
+// processes: they must still read the files it removes
+func TestIndexReaderOtherProcess(t *testing.T) {
+	directory := initSimpleIndexAt(filepath.Join("testdata", "other_process"))
+
+	child := exec.Command(os.Args[0], "-test.run=^TestIndexReaderChildProcess$")
+	child.Env = append(os.Environ(), "LYNX_TEST_READER_DIRECTORY="+directory)
+	child.Stderr = os.Stderr
+
+	stdin, err := child.StdinPipe()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	stdout, err := child.StdoutPipe()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := child.Start(); err != nil {
+		log.Fatal(err)
+	}
+
+	lines := bufio.NewScanner(stdout)
+	if !lines.Scan() || lines.Text() != "open" {
+		log.Fatalf("child process: %q, %v", lines.Text(), lines.Err())
+	}
+
+	indexWriter, err := index.NewIndexWriter(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer indexWriter.Close()
+
+	if err := indexWriter.DeleteDocuments("id", [][]byte{utils.Uint64ToBytes(89)}); err != nil {
+		log.Fatal(err)
+	}
+
+	indexWriter.SetMergePolicy(&index.LogMergePolicy{MergeFactor: 2, MinMergeDocs: 10})
+	if err := indexWriter.Merge(); err != nil {
+		log.Fatal(err)
+	}
+
+	// The files of the reader of the child process are removed
+	commit, err := indexWriter.Snapshot()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	assert.NoError(t, indexWriter.ReleaseSnapshot(commit))
+	assert.Equal(t, commitFilePrefixes(commit), indexFilePrefixes(directory))
+
+	indexReader, err := index.NewIndexReader(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer indexReader.Close()
+
+	ids, _ := searchIdsAndScores(&query.TermNode{FieldName: "body", Term: []byte("apple")}, indexReader, 10)
+	assert.Empty(t, ids)
+
+	// The reader of the child process still sees its commit
+	if _, err := stdin.Write([]byte("\n")); err != nil {
+		log.Fatal(err)
+	}
+
+	if !lines.Scan() {
+		log.Fatalf("child process: %v", lines.Err())
+	}
+
+	assert.Equal(t, "ids [89]", lines.Text())
+
+	for lines.Scan() {
+	}
+
+	assert.NoError(t, child.Wait())
+}
+
+// Copies the files of the index at source to directory
+func copyIndex(source, directory string) string {
+	os.RemoveAll(directory)
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		log.Fatal(err)
+	}
+
+	dirEntries, err := os.ReadDir(source)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, dirEntry := range dirEntries {
+		data, err := os.ReadFile(filepath.Join(source, dirEntry.Name()))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := os.WriteFile(filepath.Join(directory, dirEntry.Name()), data, 0600); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	return directory
+}
+
+// The index of index/testdata/v0 was written with random segment ids
+func TestIndexWriterVersion0SegmentIds(t *testing.T) {
+	directory := copyIndex(filepath.Join("index", "testdata", "v0"), filepath.Join("testdata", "segment_ids_v0"))
+
+	oldDocIds := []uint64{
+		index.ToGlobalDocId(887699001, 0),
+		index.ToGlobalDocId(887699001, 2),
+		index.ToGlobalDocId(2172391158, 0),
+		index.ToGlobalDocId(2172391158, 1),
+	}
+
+	readIds := func(indexReader *index.IndexReader) []uint64 {
+		ids := make([]uint64, 0, len(oldDocIds))
+
+		for _, docId := range oldDocIds {
+			value, err := indexReader.Value("id", docId)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			ids = append(ids, binary.BigEndian.Uint64(value))
+		}
+
+		return ids
+	}
+
+	// The ids of the writer start after the id of the deleted file, moved
+	// next to a segment id of the commit, which must be skipped
+	commitBytes, err := os.ReadFile(filepath.Join(directory, "commit"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	commitBytes = []byte(strings.Replace(string(commitBytes), `"deletedId":0`, `"deletedId":887699000`, 1))
+	if err := os.Rename(filepath.Join(directory, "deleted.0.data"), filepath.Join(directory, "deleted.887699000.data")); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := os.Rename(filepath.Join(directory, "deleted.0.index"), filepath.Join(directory, "deleted.887699000.index")); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(directory, "commit"), commitBytes, 0600); err != nil {
+		log.Fatal(err)
+	}
+
+	indexWriter, err := index.NewIndexWriter(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer indexWriter.Close()
+	indexWriter.SetMergePolicy(&index.NoMergePolicy{})
+	// The text fields keep the analyzer of the baseline
+	indexWriter.SetSchema(index.NewSchema(index.StandardV0AnalyzerName))
+
+	for id := uint64(5); id < 7; id++ {
+		if err := indexWriter.AddDocuments([]index.Document{
+			[]index.Field{
+				{Name: "id", FieldType: index.ByteFieldType, Value: utils.Uint64ToBytes(id)},
+				{Name: "body", FieldType: index.TextFieldType, Value: []byte("The brown fox")},
+			},
+		}); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if err := indexWriter.DeleteDocuments("id", [][]byte{utils.Uint64ToBytes(4)}); err != nil {
+		log.Fatal(err)
+	}
+
+	commit, err := indexWriter.Snapshot()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	assert.NoError(t, indexWriter.ReleaseSnapshot(commit))
+	assert.Equal(t, []uint32{887699001, 2172391158, 887699002, 887699003}, commit.SegmentIds)
+	assert.Equal(t, uint32(887699004), *commit.DeletedId)
+
+	indexReader, err := index.NewIndexReader(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer indexReader.Close()
+
+	// The docs of the old segments keep their ids
+	assert.Equal(t, []uint64{0, 2, 3, 4}, readIds(indexReader))
+
+	ids, _ := searchIdsAndScores(&query.TermNode{FieldName: "body", Term: []byte("fox")}, indexReader, 10)
+	assert.ElementsMatch(t, []uint64{0, 2, 5, 6}, ids)
+}
+
+func TestIndexWriterSegmentIds(t *testing.T) {
+	directory := initSimpleIndexAt(filepath.Join("testdata", "segment_ids"))
+
+	indexWriter, err := index.NewIndexWriter(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	commit, err := indexWriter.Snapshot()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	assert.NoError(t, indexWriter.ReleaseSnapshot(commit))
+	assert.Equal(t, []uint32{0, 1}, commit.SegmentIds)
+	assert.Equal(t, uint32(2), commit.Counter)
+
+	assert.NoError(t, indexWriter.Close())
+
+	// An index written before the counter
+	commitBytes, err := os.ReadFile(filepath.Join(directory, "commit"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	commitBytes = []byte(strings.Replace(string(commitBytes), `,"counter":2`, "", 1))
+	if err := os.WriteFile(filepath.Join(directory, "commit"), commitBytes, 0600); err != nil {
+		log.Fatal(err)
+	}
+
+	indexWriter, err = index.NewIndexWriter(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer indexWriter.Close()
+	indexWriter.SetMergePolicy(&index.NoMergePolicy{})
+
+	if err := indexWriter.DeleteDocuments("id", [][]byte{utils.Uint64ToBytes(89)}); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := indexWriter.AddDocuments([]index.Document{
+		[]index.Field{
+			{Name: "id", FieldType: index.ByteFieldType, Value: utils.Uint64ToBytes(50)},
+			{Name: "body", FieldType: index.TextFieldType, Value: []byte("This is a business")},
+		},
+	}); err != nil {
+		log.Fatal(err)
+	}
+
+	commit, err = indexWriter.Snapshot()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	assert.NoError(t, indexWriter.ReleaseSnapshot(commit))
+	assert.Equal(t, []uint32{0, 1, 3}, commit.SegmentIds)
+	assert.Equal(t, uint32(2), *commit.DeletedId)
+	assert.Equal(t, uint32(4), commit.Counter)
+
+	indexReader, err := index.NewIndexReader(directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer indexReader.Close()
+
+	ids, _ := searchIdsAndScores(&query.TermNode{FieldName: "body", Term: []byte("this")}, indexReader, 10)
+	assert.ElementsMatch(t, []uint64{9, 50}, ids)
+}
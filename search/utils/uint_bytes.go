@@ -13,3 +13,7 @@ func Uint64ToBytes(val uint64) []byte {
 	binary.BigEndian.PutUint64(b, val)
 	return b
 }
+
+func BytesToUint32(b []byte) uint32 {
+	return binary.BigEndian.Uint32(b)
+}